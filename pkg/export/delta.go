@@ -0,0 +1,437 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
+	"github.com/sirupsen/logrus"
+)
+
+// deltaMinEntrySize the entries of the image archives smaller than it are always
+// shipped, they are the manifests and configs needed to read the images of the archive
+const deltaMinEntrySize = 1 << 20
+
+// PackageDelta the files and image layers a delta package takes from its base package
+type PackageDelta struct {
+	BaseAppVersion string `json:"base_app_version"`
+	// BaseManifest the sha256 of the manifest of the base package
+	BaseManifest string `json:"base_manifest"`
+	// Files the files taken from the base package as they are
+	Files []PackageFile `json:"files,omitempty"`
+	// Entries the entries of the image archives taken from the archives of the base package
+	Entries []DeltaEntry `json:"entries,omitempty"`
+}
+
+// DeltaEntry an entry of an image archive taken from an archive of the base package
+type DeltaEntry struct {
+	Archive string `json:"archive"`
+	PackageEntry
+	BaseArchive string `json:"base_archive"`
+	BasePath    string `json:"base_path"`
+}
+
+// deltaBase the manifest of the package the delta is made of
+type deltaBase struct {
+	manifest *PackageManifest
+	sha256   string
+}
+
+type deltaBaseKey struct{}
+
+func withDeltaBase(ctx context.Context, base *deltaBase) context.Context {
+	return context.WithValue(ctx, deltaBaseKey{}, base)
+}
+
+func deltaBaseFromContext(ctx context.Context) *deltaBase {
+	base, _ := ctx.Value(deltaBaseKey{}).(*deltaBase)
+	return base
+}
+
+// loadDeltaBase read the manifest of the base package, file is the package-manifest.json
+// or the tarball package
+func loadDeltaBase(file string) (*deltaBase, error) {
+	var body []byte
+	var err error
+	if path.Ext(file) == ".json" {
+		body, err = ioutil.ReadFile(file)
+	} else {
+		body, err = readPackagedManifest(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read the manifest of base package %s failure %s", file, err.Error())
+	}
+	var manifest PackageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("read the manifest of base package %s failure %s", file, err.Error())
+	}
+	if manifest.Delta != nil {
+		return nil, fmt.Errorf("base package %s is a delta package", file)
+	}
+	sum := sha256.Sum256(body)
+	return &deltaBase{manifest: &manifest, sha256: hex.EncodeToString(sum[:])}, nil
+}
+
+// readPackagedManifest read the manifest in the top dir of the tarball package
+func readPackagedManifest(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dr, err := archive.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found", ManifestFileName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if path.Base(name) == ManifestFileName && strings.Count(name, "/") == 1 {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+// makeDelta remove the files and image archive entries of the export dir that are in
+// the base package
+func makeDelta(ctx context.Context, exportPath string, base *deltaBase) (*PackageDelta, error) {
+	delta := &PackageDelta{BaseAppVersion: base.manifest.AppVersion, BaseManifest: base.sha256}
+	baseFiles := make(map[string]PackageFile)
+	baseEntries := make(map[string]DeltaEntry)
+	for _, file := range base.manifest.Files {
+		baseFiles[file.Path] = file
+		for _, entry := range file.Entries {
+			baseEntries[entry.SHA256] = DeltaEntry{PackageEntry: entry, BaseArchive: file.Path, BasePath: entry.Path}
+		}
+	}
+	err := filepath.Walk(exportPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(exportPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if path.Ext(rel) == ".tar" {
+			entries, err := deltaArchive(p, rel, baseEntries)
+			delta.Entries = append(delta.Entries, entries...)
+			return err
+		}
+		file, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		if baseFile, ok := baseFiles[rel]; ok && baseFile.SHA256 == file.SHA256 {
+			delta.Files = append(delta.Files, baseFile)
+			return os.Remove(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("make delta of version %s failure %s", base.manifest.AppVersion, err.Error())
+	}
+	return delta, nil
+}
+
+// deltaArchive rewrite the image archive without the entries found in the base package
+func deltaArchive(file, archive string, baseEntries map[string]DeltaEntry) ([]DeltaEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := hashEntries(f)
+	f.Close()
+	if err != nil {
+		// not a tar file, ship it as it is
+		return nil, nil
+	}
+	var delta []DeltaEntry
+	skip := make(map[string]bool)
+	for _, entry := range entries {
+		baseEntry, ok := baseEntries[entry.SHA256]
+		if !ok || entry.Size < deltaMinEntrySize {
+			continue
+		}
+		baseEntry.Archive, baseEntry.PackageEntry = archive, entry
+		delta = append(delta, baseEntry)
+		skip[entry.Path] = true
+	}
+	if len(delta) == 0 {
+		return nil, nil
+	}
+	err = rewriteArchive(file, func(tw *tar.Writer) error { return nil }, func(hdr *tar.Header) bool {
+		return !skip[path.Clean(hdr.Name)]
+	})
+	return delta, err
+}
+
+// rewriteArchive copy the entries of the archive that are kept, then add the entries
+// written by extra
+func rewriteArchive(file string, extra func(tw *tar.Writer) error, keep func(hdr *tar.Header) bool) (err error) {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := file + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	tr := tar.NewReader(src)
+	tw := tar.NewWriter(dst)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !keep(hdr) {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	if err := extra(tw); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// AssembleDelta restore the files and image archive entries the delta package in dir
+// takes from the base package in baseDir, all of them are checked against the manifest
+func AssembleDelta(ctx context.Context, dir, baseDir string, manifest *PackageManifest, logger *logrus.Logger) error {
+	delta := manifest.Delta
+	if delta == nil {
+		return nil
+	}
+	for _, file := range delta.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := copyBaseFile(dir, baseDir, file); err != nil {
+			return err
+		}
+	}
+	entries := make(map[string][]DeltaEntry)
+	var archives []string
+	for _, entry := range delta.Entries {
+		if _, ok := entries[entry.Archive]; !ok {
+			archives = append(archives, entry.Archive)
+		}
+		entries[entry.Archive] = append(entries[entry.Archive], entry)
+	}
+	sort.Strings(archives)
+	for _, archive := range archives {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		file, err := packagePath(dir, archive)
+		if err != nil {
+			return err
+		}
+		err = rewriteArchive(file, func(tw *tar.Writer) error {
+			return copyBaseEntries(tw, baseDir, entries[archive])
+		}, func(*tar.Header) bool { return true })
+		if err != nil {
+			return fmt.Errorf("assemble image archive %s failure: %w", archive, err)
+		}
+		logger.Infof("restore %d entries of image archive %s from the base package", len(entries[archive]), archive)
+	}
+	return nil
+}
+
+func copyBaseFile(dir, baseDir string, file PackageFile) error {
+	src, err := packagePath(baseDir, file.Path)
+	if err != nil {
+		return err
+	}
+	dst, err := packagePath(dir, file.Path)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return &IntegrityError{Path: file.Path, Reason: "the file is missing in the base package"}
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	d := NewDigester()
+	if _, err := io.Copy(io.MultiWriter(out, d), in); err != nil {
+		return err
+	}
+	return file.Compare(d.Size(), d.Sum())
+}
+
+// copyBaseEntries append the entries read from the image archives of the base package
+func copyBaseEntries(tw *tar.Writer, baseDir string, entries []DeltaEntry) error {
+	// the entries with the same content are taken from the same base entry
+	byArchive := make(map[string]map[string][]DeltaEntry)
+	for _, entry := range entries {
+		if byArchive[entry.BaseArchive] == nil {
+			byArchive[entry.BaseArchive] = make(map[string][]DeltaEntry)
+		}
+		byArchive[entry.BaseArchive][entry.BasePath] = append(byArchive[entry.BaseArchive][entry.BasePath], entry)
+	}
+	for archive, wanted := range byArchive {
+		file, err := packagePath(baseDir, archive)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return &IntegrityError{Path: archive, Reason: "the image archive is missing in the base package"}
+		}
+		err = copyEntries(tw, tar.NewReader(f), wanted, baseDir)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for basePath := range wanted {
+			return &IntegrityError{Path: archive + ":" + basePath, Reason: "the entry is missing in the base package"}
+		}
+	}
+	return nil
+}
+
+// copyEntries copy the wanted entries of the base archive to all their destinations, the
+// copied entries are removed from wanted
+func copyEntries(tw *tar.Writer, tr *tar.Reader, wanted map[string][]DeltaEntry, tmpDir string) error {
+	for len(wanted) > 0 {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dsts, ok := wanted[path.Clean(hdr.Name)]
+		if !ok {
+			continue
+		}
+		delete(wanted, path.Clean(hdr.Name))
+		if err := copyEntry(tw, hdr, tr, dsts, tmpDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyEntry write the base entry as every destination, the content is kept in a temporary
+// file under tmpDir if there is more than one destination
+func copyEntry(tw *tar.Writer, hdr *tar.Header, r io.Reader, dsts []DeltaEntry, tmpDir string) error {
+	var tmp *os.File
+	if len(dsts) > 1 {
+		var err error
+		if tmp, err = ioutil.TempFile(tmpDir, ".delta-entry-"); err != nil {
+			return err
+		}
+		defer func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}()
+		r = io.TeeReader(r, tmp)
+	}
+	for i, entry := range dsts {
+		if i > 0 {
+			if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			r = tmp
+		}
+		hdr.Name = entry.Path
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		d := NewDigester()
+		if _, err := io.Copy(io.MultiWriter(tw, d), r); err != nil {
+			return err
+		}
+		if d.Size() != entry.Size || d.Sum() != entry.SHA256 {
+			return &IntegrityError{Path: entry.Archive + ":" + entry.Path, Reason: "the entry of the base package does not match"}
+		}
+	}
+	return nil
+}
+
+// packagePath the path of the file of the package in dir, the paths outside of dir
+// are rejected
+func packagePath(dir, name string) (string, error) {
+	name = path.Clean(name)
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", &IntegrityError{Path: name, Reason: "the path is outside of the package"}
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
@@ -0,0 +1,183 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// writeExportDir write the files and the image archive of the entries into the dir
+func writeExportDir(t *testing.T, dir string, files map[string][]byte, entries map[string][]byte) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), body, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for name, body := range entries {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg})
+		tw.Write(body)
+	}
+	tw.Close()
+	if err := ioutil.WriteFile(path.Join(dir, "component-images.tar"), archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readEntries(t *testing.T, file string) map[string][]byte {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return entries
+		}
+		entries[hdr.Name], _ = ioutil.ReadAll(tr)
+	}
+}
+
+func TestDeltaExport(t *testing.T) {
+	logger := logrus.StandardLogger()
+	shared := bytes.Repeat([]byte("a"), deltaMinEntrySize)
+	changed := bytes.Repeat([]byte("b"), deltaMinEntrySize)
+	home := t.TempDir()
+	baseDir := path.Join(home, "demo-1.0-ram")
+	writeExportDir(t, baseDir,
+		map[string][]byte{"metadata.json": []byte(`{"group_version":"1.0"}`), "README": []byte("readme")},
+		map[string][]byte{"manifest.json": []byte("[]"), "shared/layer.tar": shared, "old/layer.tar": []byte("old")})
+	ram := testRAM()
+	if err := WritePackageManifest(context.Background(), baseDir, ram, logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Packaging("base.tar.gz", home, baseDir); err != nil {
+		t.Fatal(err)
+	}
+	base, err := loadDeltaBase(path.Join(home, "base.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := path.Join(t.TempDir(), "demo-2.0-ram")
+	writeExportDir(t, dir,
+		map[string][]byte{"metadata.json": []byte(`{"group_version":"2.0"}`), "README": []byte("readme")},
+		map[string][]byte{"manifest.json": []byte("[]"), "shared/layer.tar": shared, "new/layer.tar": changed})
+	delta, err := makeDelta(context.Background(), dir, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Files) != 1 || delta.Files[0].Path != "README" || len(delta.Entries) != 1 || delta.Entries[0].Path != "shared/layer.tar" {
+		t.Fatalf("unexpected delta %+v", delta)
+	}
+	if _, err := os.Stat(path.Join(dir, "README")); !os.IsNotExist(err) {
+		t.Errorf("the file of the base package should be left out")
+	}
+	if entries := readEntries(t, path.Join(dir, "component-images.tar")); len(entries) != 2 || entries["shared/layer.tar"] != nil {
+		t.Errorf("the layer of the base package should be left out, got %d entries", len(entries))
+	}
+	if err := writePackageManifest(context.Background(), dir, ram, logger, delta); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ReadPackageManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Verify(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AssembleDelta(context.Background(), dir, baseDir, manifest, logger); err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadFile(path.Join(dir, "README")); string(body) != "readme" {
+		t.Errorf("the file of the base package is not restored")
+	}
+	entries := readEntries(t, path.Join(dir, "component-images.tar"))
+	if len(entries) != 3 || !bytes.Equal(entries["shared/layer.tar"], shared) || !bytes.Equal(entries["new/layer.tar"], changed) {
+		t.Errorf("the image archive is not restored, got %d entries", len(entries))
+	}
+
+	// the base package is changed after the delta is made
+	writeExportDir(t, baseDir, nil, map[string][]byte{"shared/layer.tar": changed})
+	var integrityErr *IntegrityError
+	if err := AssembleDelta(context.Background(), dir, baseDir, manifest, logger); !errors.As(err, &integrityErr) {
+		t.Errorf("expected the mismatched base entry reported, got %v", err)
+	}
+}
+
+func TestDeltaSameContentEntries(t *testing.T) {
+	logger := logrus.StandardLogger()
+	shared := bytes.Repeat([]byte("a"), deltaMinEntrySize)
+	entries := map[string][]byte{"manifest.json": []byte("[]"), "web/layer.tar": shared, "api/layer.tar": shared}
+	home := t.TempDir()
+	baseDir := path.Join(home, "demo-1.0-ram")
+	writeExportDir(t, baseDir, map[string][]byte{"metadata.json": []byte(`{"group_version":"1.0"}`)}, entries)
+	ram := testRAM()
+	if err := WritePackageManifest(context.Background(), baseDir, ram, logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Packaging("base.tar.gz", home, baseDir); err != nil {
+		t.Fatal(err)
+	}
+	base, err := loadDeltaBase(path.Join(home, "base.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := path.Join(t.TempDir(), "demo-2.0-ram")
+	writeExportDir(t, dir, map[string][]byte{"metadata.json": []byte(`{"group_version":"2.0"}`)}, entries)
+	delta, err := makeDelta(context.Background(), dir, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Entries) != 2 {
+		t.Fatalf("expected both unchanged entries taken from the base package, got %+v", delta.Entries)
+	}
+	if err := writePackageManifest(context.Background(), dir, ram, logger, delta); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ReadPackageManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AssembleDelta(context.Background(), dir, baseDir, manifest, logger); err != nil {
+		t.Fatal(err)
+	}
+	restored := readEntries(t, path.Join(dir, "component-images.tar"))
+	if !bytes.Equal(restored["web/layer.tar"], shared) || !bytes.Equal(restored["api/layer.tar"], shared) {
+		t.Errorf("both entries with the same content should be restored, got %d entries", len(restored))
+	}
+}
@@ -19,8 +19,10 @@
 package export
 
 import (
+	"context"
 	"fmt"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
 	"io/ioutil"
 	"os"
 	"path"
@@ -41,9 +43,18 @@ type dockerComposeExporter struct {
 }
 
 func (d *dockerComposeExporter) Export() (*Result, error) {
+	return d.ExportWithContext(context.Background())
+}
+
+func (d *dockerComposeExporter) ExportWithContext(ctx context.Context) (re *Result, err error) {
+	packageName := packageFileName(ctx, fmt.Sprintf("%s-%s-dockercompose.tar.gz", d.ram.AppName, d.ram.AppVersion))
+	defer func() {
+		cleanupCanceledExport(ctx, d.logger, err, d.exportPath, path.Join(d.homePath, packageName))
+	}()
 
 	d.logger.Infof("start export app %s to docker compose app spec", d.ram.AppName)
 	// Delete the old application group directory and then regenerate the application package
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePrepare, Message: "prepare export dir"})
 	if err := PrepareExportDir(d.exportPath); err != nil {
 		d.logger.Errorf("prepare export dir failure %s", err.Error())
 		return nil, err
@@ -51,7 +62,7 @@ func (d *dockerComposeExporter) Export() (*Result, error) {
 
 	d.logger.Infof("success prepare export dir")
 	// Save components attachments
-	if err := d.saveComponents(); err != nil {
+	if err := d.saveComponents(ctx); err != nil {
 		return nil, err
 	}
 	d.logger.Infof("success save components")
@@ -65,22 +76,26 @@ func (d *dockerComposeExporter) Export() (*Result, error) {
 		return nil, err
 	}
 	d.logger.Infof("success build start script")
+	if err := WritePackageManifest(ctx, d.exportPath, d.ram, d.logger); err != nil {
+		d.logger.Error(err)
+		return nil, err
+	}
 	// packaging
-	packageName := fmt.Sprintf("%s-%s-dockercompose.tar.gz", d.ram.AppName, d.ram.AppVersion)
-	name, err := Packaging(packageName, d.homePath, d.exportPath)
+	name, err := PackagingWithContext(ctx, packageName, d.homePath, d.exportPath)
 	if err != nil {
 		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
 		d.logger.Error(err)
 		return nil, err
 	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseDone, Message: "export success"})
 	d.logger.Infof("success export app " + d.ram.AppName)
 	return &Result{PackagePath: path.Join(d.homePath, name), PackageName: name}, nil
 }
 
 // saveComponents Bulk export of mirrored mode, lower disk footprint for the entire package
-func (d *dockerComposeExporter) saveComponents() error {
+func (d *dockerComposeExporter) saveComponents(ctx context.Context) error {
 	dockerCompose := newDockerCompose(d.ram)
-	var componentImageNames []string
+	var pulls imagePulls
 	for _, component := range d.ram.Components {
 		componentName := component.ServiceCname
 		componentEnName := dockerCompose.GetServiceName(component.ServiceShareID)
@@ -100,16 +115,16 @@ func (d *dockerComposeExporter) saveComponents() error {
 		}
 		if component.ShareImage != "" {
 			// app is image type
-			_, err := d.imageClient.ImagePull(component.ShareImage, component.AppImage.HubUser, component.AppImage.HubPassword, 30)
-			if err != nil {
-				return err
-			}
-			d.logger.Infof("pull component %s image success", componentName)
-			componentImageNames = append(componentImageNames, component.ShareImage)
+			ps := componentPlatforms(ctx, component, d.logger)
+			pulls.add(componentName, component.ShareImage, component.AppImage.HubUser, component.AppImage.HubPassword, ps...)
 		}
 	}
+	if err := pulls.pull(ctx, d.imageClient, d.logger); err != nil {
+		return err
+	}
 	start := time.Now()
-	err := d.imageClient.ImageSave(fmt.Sprintf("%s/component-images.tar", d.exportPath), componentImageNames)
+	componentImageNames := pulls.images()
+	err := image.ImageSaveWithContext(pulls.platforms.context(ctx), d.imageClient, fmt.Sprintf("%s/component-images.tar", d.exportPath), componentImageNames)
 	if err != nil {
 		logrus.Errorf("Failed to save image(%v) : %s", componentImageNames, err)
 		return err
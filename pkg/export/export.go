@@ -19,18 +19,26 @@
 package export
 
 import (
+	"context"
+	"crypto"
 	"fmt"
 	"github.com/containerd/containerd"
 	dockercli "github.com/docker/docker/client"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/sign"
 	"github.com/sirupsen/logrus"
 	"path"
+	"time"
 )
 
 //AppLocalExport export local package
 type AppLocalExport interface {
 	Export() (*Result, error)
+	// ExportWithContext stops the export when the context is canceled, and removes
+	// the half-written export directory and package
+	ExportWithContext(ctx context.Context) (*Result, error)
 }
 
 //Result export result
@@ -38,6 +46,13 @@ type Result struct {
 	PackagePath   string
 	PackageName   string
 	PackageFormat string
+	// VolumeIndex the index of the parts of the package if it's split, the package
+	// itself is removed then and PackagePath is the index too
+	VolumeIndex string
+	Volumes     []string
+	// ArtifactDigest the digest of the manifest of the app artifact, PackagePath is its
+	// reference then
+	ArtifactDigest string
 }
 
 //AppFormat app spec format
@@ -52,52 +67,345 @@ var (
 	SLG AppFormat = "slug"
 	//HELM
 	HELM AppFormat = "helm-chart"
+	//K8S plain kubernetes manifests
+	K8S AppFormat = "kubernetes"
+	//OCIArtifact the app pushed to a registry as an oci artifact
+	OCIArtifact AppFormat = "oci-artifact"
 )
 
-//New new exporter
-func New(format AppFormat, homePath string, ram v1alpha1.RainbondApplicationConfig, containerdCli *containerd.Client, dockerCli *dockercli.Client, logger *logrus.Logger) (AppLocalExport, error) {
-	imageClient, err := image.NewClient(containerdCli, dockerCli)
+//Option export option
+type Option func(*options)
+
+type options struct {
+	mirrors    []image.Mirror
+	pool       *image.Pool
+	signingKey string
+	signer     crypto.Signer
+	baseFile   string
+	base       *deltaBase
+	layout     *imageLayout
+	blobCache  bool
+	packaging  *packaging
+	volumeSize int64
+	artifact   *ArtifactRepository
+	// registryRoot the local store of the daemonless registry client, empty means the
+	// containerd or docker client is used
+	registryRoot string
+	// validate reject the templates that fail the validation
+	validate bool
+}
+
+func (o *options) imagePool() *image.Pool {
+	if o.pool == nil {
+		pool := image.DefaultPool
+		o.pool = &pool
+	}
+	return o.pool
+}
+
+//WithMirrors pull the component and plugin images from the mirrors of their registry first
+func WithMirrors(mirrors ...image.Mirror) Option {
+	return func(o *options) {
+		o.mirrors = append(o.mirrors, mirrors...)
+	}
+}
+
+//WithConcurrency pull at most n images at the same time
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.imagePool().Concurrency = n
+	}
+}
+
+//WithRetry retry a failed image pull the given times, the backoff doubles on every retry
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.imagePool().Retries = retries
+		o.imagePool().Backoff = backoff
+	}
+}
+
+//WithValidation validate the app template before the export, the templates that fail the
+//validation are rejected. The templates are not validated by default.
+func WithValidation() Option {
+	return func(o *options) {
+		o.validate = true
+	}
+}
+
+//WithSigningKey sign the package manifest with the ed25519 or ECDSA P-256 private key
+//of the PEM file, the detached signature is written next to the manifest
+func WithSigningKey(file string) Option {
+	return func(o *options) {
+		o.signingKey = file
+	}
+}
+
+//WithBase export the delta of the base package, the files and image layers that are in
+//the base package are left out. file is the base package or its package-manifest.json,
+//only the ram format supports it.
+func WithBase(file string) Option {
+	return func(o *options) {
+		o.baseFile = file
+	}
+}
+
+//WithImageLayout save all the images of the package into one oci image layout, the
+//layers shared by the component and plugin images are stored once. Only the ram format
+//supports it.
+func WithImageLayout() Option {
+	return func(o *options) {
+		if o.layout == nil {
+			o.layout = &imageLayout{}
+		}
+	}
+}
+
+//WithBlobCache keep the image blobs in a cache under the home path, so that the layers
+//unchanged since the last export are not written again. It implies WithImageLayout.
+func WithBlobCache() Option {
+	return func(o *options) {
+		WithImageLayout()(o)
+		o.blobCache = true
+	}
+}
+
+//WithBlobCacheLimit keep the blob cache of WithBlobCache under size bytes, the least
+//recently used blobs are removed after every export. It implies WithBlobCache.
+func WithBlobCacheLimit(size int64) Option {
+	return func(o *options) {
+		WithBlobCache()(o)
+		o.layout.cacheLimit = size
+	}
+}
+
+//WithCompression compress the package with gzip, zstd or none, concurrency is the
+//number of blocks compressed at the same time
+func WithCompression(compression archive.Compression, concurrency int) Option {
+	return func(o *options) {
+		o.packagingOptions().compression = compression
+		o.packaging.concurrency = concurrency
+	}
+}
+
+//WithStreamPackaging remove the files of the export dir as soon as they are written into
+//the package, so that large apps do not take the disk space twice. The images are still
+//saved to the export dir before packaging, a tar entry needs its size and the package
+//manifest needs the checksum of the image archive, so the disk holds the image archives
+//and the package written so far.
+func WithStreamPackaging() Option {
+	return func(o *options) {
+		o.packagingOptions().stream = true
+	}
+}
+
+func (o *options) packagingOptions() *packaging {
+	if o.packaging == nil {
+		o.packaging = &packaging{compression: archive.Gzip}
+	}
+	return o.packaging
+}
+
+//WithVolumeSize split the package into parts of at most size bytes and an index file,
+//so that it can be uploaded to the sites limiting the upload size or written to disks
+func WithVolumeSize(size int64) Option {
+	return func(o *options) {
+		o.volumeSize = size
+	}
+}
+
+//WithArtifactRepository the repository the oci-artifact format pushes the app to, the app
+//version is the tag of the artifact
+func WithArtifactRepository(repository ArtifactRepository) Option {
+	return func(o *options) {
+		o.artifact = &repository
+	}
+}
+
+//WithRegistryClient pull and save the images with the daemonless registry client instead
+//of containerd or docker, the images are stored under root
+func WithRegistryClient(root string) Option {
+	return func(o *options) {
+		o.registryRoot = root
+	}
+}
+
+//optionExporter applies the options to the context of the export
+type optionExporter struct {
+	AppLocalExport
+	options
+}
+
+func (o *optionExporter) Export() (*Result, error) {
+	return o.ExportWithContext(context.Background())
+}
+
+func (o *optionExporter) ExportWithContext(ctx context.Context) (*Result, error) {
+	if o.pool != nil {
+		ctx = image.WithPool(ctx, *o.pool)
+	}
+	if o.signer != nil {
+		ctx = withSigner(ctx, o.signer)
+	}
+	if o.base != nil {
+		ctx = withDeltaBase(ctx, o.base)
+	}
+	if o.layout != nil {
+		ctx = withImageLayout(ctx, o.layout)
+	}
+	if o.packaging != nil {
+		ctx = withPackaging(ctx, o.packaging)
+	}
+	result, err := o.AppLocalExport.ExportWithContext(ctx)
+	if err != nil || o.volumeSize <= 0 {
+		return result, err
+	}
+	indexPath, err := SplitPackage(ctx, result.PackagePath, o.volumeSize)
+	if err != nil {
+		return nil, fmt.Errorf("split package %s failure %s", result.PackageName, err.Error())
+	}
+	index, _, err := ReadVolumeIndex(indexPath)
 	if err != nil {
-		logger.Errorf("create image client error: %v", err)
 		return nil, err
 	}
+	result.PackagePath, result.VolumeIndex = indexPath, indexPath
+	for _, volume := range index.Volumes {
+		result.Volumes = append(result.Volumes, path.Join(path.Dir(indexPath), volume.Path))
+	}
+	return result, nil
+}
+
+//New new exporter
+func New(format AppFormat, homePath string, ram v1alpha1.RainbondApplicationConfig, containerdCli *containerd.Client, dockerCli *dockercli.Client, logger *logrus.Logger, opts ...Option) (AppLocalExport, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.validate {
+		if err := ram.Validation(); err != nil {
+			logger.Errorf("app template is invalid: %v", err)
+			return nil, err
+		}
+	}
+	if o.signingKey != "" {
+		signer, err := sign.LoadPrivateKey(o.signingKey)
+		if err != nil {
+			logger.Errorf("load signing key error: %v", err)
+			return nil, err
+		}
+		o.signer = signer
+	}
+	if o.baseFile != "" {
+		if format != RAM {
+			return nil, fmt.Errorf("delta export is not supported by format %s", format)
+		}
+		base, err := loadDeltaBase(o.baseFile)
+		if err != nil {
+			logger.Errorf("load base package error: %v", err)
+			return nil, err
+		}
+		o.base = base
+	}
+	if o.layout != nil {
+		if format != RAM {
+			return nil, fmt.Errorf("image layout is not supported by format %s", format)
+		}
+		if o.blobCache {
+			o.layout.cacheDir = path.Join(homePath, ".blob-cache")
+		}
+		if o.layout.cacheLimit < 0 {
+			return nil, fmt.Errorf("invalid blob cache limit %d", o.layout.cacheLimit)
+		}
+	}
+	if o.volumeSize < 0 {
+		return nil, fmt.Errorf("invalid volume size %d", o.volumeSize)
+	}
+	if format == OCIArtifact {
+		if o.artifact == nil || o.artifact.Repository == "" {
+			return nil, fmt.Errorf("the repository of the app artifact is not defined")
+		}
+		if o.volumeSize > 0 {
+			return nil, fmt.Errorf("volumes are not supported by format %s", format)
+		}
+	}
+	if o.packaging != nil {
+		compression, err := archive.ParseCompression(string(o.packaging.compression))
+		if err != nil {
+			return nil, err
+		}
+		o.packaging.compression = compression
+	}
+	var (
+		imageClient image.Client
+		err         error
+	)
+	// the app artifact references the images without pulling them
+	if format != OCIArtifact {
+		if o.registryRoot != "" {
+			imageClient, err = image.NewRegistryClient(o.registryRoot)
+		} else {
+			imageClient, err = image.NewClient(containerdCli, dockerCli)
+		}
+		if err != nil {
+			logger.Errorf("create image client error: %v", err)
+			return nil, err
+		}
+		imageClient = image.NewMirrorClient(imageClient, o.mirrors...)
+	}
+	var exporter AppLocalExport
 	switch format {
 	case RAM:
-		return &ramExporter{
+		exporter = &ramExporter{
 			logger:      logger,
 			ram:         ram,
 			imageClient: imageClient,
 			mode:        "offline",
 			homePath:    homePath,
 			exportPath:  path.Join(homePath, fmt.Sprintf("%s-%s-ram", ram.AppName, ram.AppVersion)),
-		}, nil
+		}
 	case DC:
-		return &dockerComposeExporter{
+		exporter = &dockerComposeExporter{
 			logger:      logger,
 			ram:         ram,
 			imageClient: imageClient,
 			homePath:    homePath,
 			exportPath:  path.Join(homePath, fmt.Sprintf("%s-%s-dockercompose", ram.AppName, ram.AppVersion)),
-		}, nil
+		}
 	case SLG:
-		return &slugExporter{
+		exporter = &slugExporter{
 			logger:      logger,
 			ram:         ram,
 			imageClient: imageClient,
 			mode:        "offline",
 			homePath:    homePath,
 			exportPath:  path.Join(homePath, fmt.Sprintf("%s-%s-slug", ram.AppName, ram.AppVersion)),
-		}, nil
+		}
 	case HELM:
-		return &helmChartExporter{
+		exporter = &helmChartExporter{
 			logger:      logger,
 			ram:         ram,
 			imageClient: imageClient,
 			mode:        "offline",
 			homePath:    homePath,
 			exportPath:  path.Join(homePath, fmt.Sprintf("%s-%s-helm", ram.AppName, ram.AppVersion)),
-		}, nil
+		}
+	case K8S:
+		exporter = &kubernetesExporter{
+			logger:      logger,
+			ram:         ram,
+			imageClient: imageClient,
+			mode:        "offline",
+			homePath:    homePath,
+			exportPath:  path.Join(homePath, fmt.Sprintf("%s-%s-k8s", ram.AppName, ram.AppVersion)),
+		}
+	case OCIArtifact:
+		exporter = &ociArtifactExporter{
+			logger:     logger,
+			ram:        ram,
+			repository: *o.artifact,
+		}
 	default:
 		panic("not support app format")
 	}
+	return &optionExporter{AppLocalExport: exporter, options: o}, nil
 }
@@ -0,0 +1,379 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeImageClient records the pulled images and writes an archive like docker save on save
+type fakeImageClient struct {
+	mu     sync.Mutex
+	pulled []string
+	// the platforms requested by the pulls and the save
+	pullPlatforms map[string][]string
+	savePlatforms []string
+}
+
+func (f *fakeImageClient) ImageSave(destination string, images []string) error {
+	return f.ImageSaveWithContext(context.Background(), destination, images)
+}
+func (f *fakeImageClient) ImageLoad(tarFile string) error { return nil }
+func (f *fakeImageClient) ImagePull(image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	return f.ImagePullWithContext(context.Background(), image, username, password, timeout)
+}
+func (f *fakeImageClient) ImagePush(image, user, pass string, timeout int) error { return nil }
+func (f *fakeImageClient) ImageTag(source, target string, timeout int) error     { return nil }
+func (f *fakeImageClient) ImageSaveWithContext(ctx context.Context, destination string, images []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.savePlatforms = image.Platforms(ctx)
+	// like containerd, the save walks the manifests of all the platforms, so they must be pulled
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range images {
+		pulled := make(map[string]bool)
+		for _, p := range f.pullPlatforms[name] {
+			pulled[p] = true
+		}
+		for _, p := range f.savePlatforms {
+			if !pulled[p] && (len(f.pullPlatforms[name]) > 0 || p != platforms.DefaultString()) {
+				return fmt.Errorf("content of image %s for platform %s not found", name, p)
+			}
+		}
+	}
+	return writeImageArchive(destination, images)
+}
+
+// writeImageArchive write an archive like docker save of the images, they share a base layer
+func writeImageArchive(destination string, images []string) error {
+	f, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	write := func(name string, body []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := tw.Write(body)
+		return err
+	}
+	type dockerManifest struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	manifests := []dockerManifest{}
+	for _, name := range images {
+		config, _ := json.Marshal(ocispec.Image{OS: "linux", Architecture: "amd64", Config: ocispec.ImageConfig{Env: []string{"IMAGE=" + name}}})
+		m := dockerManifest{Config: digest.FromBytes(config).Encoded() + ".json", RepoTags: []string{name}}
+		if err := write(m.Config, config); err != nil {
+			return err
+		}
+		for _, layer := range []string{"base-layer", name + "-layer"} {
+			file := digest.FromString(layer).Encoded() + "/layer.tar"
+			if err := write(file, []byte(layer)); err != nil {
+				return err
+			}
+			m.Layers = append(m.Layers, file)
+		}
+		manifests = append(manifests, m)
+	}
+	body, _ := json.Marshal(manifests)
+	if err := write("manifest.json", body); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+func (f *fakeImageClient) ImageLoadWithContext(ctx context.Context, tarFile string) error {
+	return ctx.Err()
+}
+func (f *fakeImageClient) ImagePullWithContext(ctx context.Context, name string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pulled = append(f.pulled, name)
+	if f.pullPlatforms == nil {
+		f.pullPlatforms = make(map[string][]string)
+	}
+	f.pullPlatforms[name] = image.Platforms(ctx)
+	return &ocispec.ImageConfig{}, nil
+}
+func (f *fakeImageClient) ImagePushWithContext(ctx context.Context, image, user, pass string, timeout int) error {
+	return ctx.Err()
+}
+func (f *fakeImageClient) ImageTagWithContext(ctx context.Context, source, target string, timeout int) error {
+	return ctx.Err()
+}
+
+func TestExportWithContextCanceled(t *testing.T) {
+	homePath := t.TempDir()
+	exporter := &kubernetesExporter{
+		logger:      logrus.StandardLogger(),
+		ram:         testRAM(),
+		imageClient: &fakeImageClient{},
+		mode:        "offline",
+		homePath:    homePath,
+		exportPath:  path.Join(homePath, "demo-1.0-k8s"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := exporter.ExportWithContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if _, err := os.Stat(exporter.exportPath); !os.IsNotExist(err) {
+		t.Errorf("export dir is not removed")
+	}
+
+	re, err := exporter.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(re.PackagePath); err != nil {
+		t.Errorf("package is not created: %v", err)
+	}
+}
+
+func TestExportCanceledCompression(t *testing.T) {
+	homePath := t.TempDir()
+	exporter := &ramExporter{
+		logger:      logrus.StandardLogger(),
+		ram:         testRAM(),
+		imageClient: &fakeImageClient{},
+		mode:        "offline",
+		homePath:    homePath,
+		exportPath:  path.Join(homePath, "demo-1.0-ram"),
+	}
+	// the package half written before the export is canceled
+	packagePath := path.Join(homePath, "demo-1.0-ram.tar.zst")
+	if err := ioutil.WriteFile(packagePath, []byte("half"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(withPackaging(context.Background(), &packaging{compression: archive.Zstd}))
+	cancel()
+	if _, err := exporter.ExportWithContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if _, err := os.Stat(packagePath); !os.IsNotExist(err) {
+		t.Errorf("the zstd package is not removed")
+	}
+}
+
+func TestExportPublishProgress(t *testing.T) {
+	homePath := t.TempDir()
+	exporter := &ramExporter{
+		logger:      logrus.StandardLogger(),
+		ram:         testRAM(),
+		imageClient: &fakeImageClient{},
+		mode:        "offline",
+		homePath:    homePath,
+		exportPath:  path.Join(homePath, "demo-1.0-ram"),
+	}
+	var phases []progress.Phase
+	ctx := progress.WithSink(context.Background(), progress.SinkFunc(func(event progress.Event) {
+		phases = append(phases, event.Phase)
+	}))
+	if _, err := exporter.ExportWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(phases) < 3 || phases[0] != progress.PhasePrepare || phases[len(phases)-1] != progress.PhaseDone {
+		t.Fatalf("unexpected progress phases %v", phases)
+	}
+	var packaged bool
+	for _, phase := range phases {
+		packaged = packaged || phase == progress.PhasePackage
+	}
+	if !packaged {
+		t.Errorf("package progress is not published: %v", phases)
+	}
+}
+
+func TestExportImageLayout(t *testing.T) {
+	homePath := t.TempDir()
+	ram := testRAM()
+	ram.Plugins = []*v1alpha1.Plugin{{PluginName: "mesh", ShareImage: "goodrain.me/mesh:1.0"}}
+	exporter := &ramExporter{
+		logger:      logrus.StandardLogger(),
+		ram:         ram,
+		imageClient: &fakeImageClient{},
+		mode:        "offline",
+		homePath:    homePath,
+		exportPath:  path.Join(homePath, "demo-1.0-ram"),
+	}
+	re, err := exporter.ExportWithContext(withImageLayout(context.Background(), &imageLayout{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(re.PackagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := path.Join(t.TempDir(), ImagesFileName)
+	var names []string
+	tr := tar.NewReader(gr)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		names = append(names, path.Base(hdr.Name))
+		if path.Base(hdr.Name) == ImagesFileName {
+			body, _ := ioutil.ReadAll(tr)
+			ioutil.WriteFile(images, body, 0644)
+		}
+	}
+	for _, name := range names {
+		if name == "component-images.tar" || name == "plugin-images.tar" {
+			t.Errorf("the merged image archive %s should not be packaged", name)
+		}
+	}
+	if tmp, _ := filepath.Glob(path.Join(homePath, ".blobs-*")); len(tmp) != 0 {
+		t.Errorf("the temporary blobs are not removed: %v", tmp)
+	}
+
+	// the images of the package are loaded like the import does
+	loader, err := image.NewRegistryClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.ImageLoad(images); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"nginx:1.19", "mysql:5.7", "goodrain.me/mesh:1.0"} {
+		if err := loader.ImageTag(name, name+"-copy", 1); err != nil {
+			t.Errorf("the image %s is not loaded: %v", name, err)
+		}
+	}
+}
+
+func TestExportBlobCacheLimit(t *testing.T) {
+	homePath := t.TempDir()
+	exporter := &ramExporter{
+		logger:      logrus.StandardLogger(),
+		ram:         testRAM(),
+		imageClient: &fakeImageClient{},
+		mode:        "offline",
+		homePath:    homePath,
+		exportPath:  path.Join(homePath, "demo-1.0-ram"),
+	}
+	cacheDir := path.Join(homePath, ".blob-cache")
+	layout := &imageLayout{cacheDir: cacheDir, cacheLimit: 1}
+	if _, err := exporter.ExportWithContext(withImageLayout(context.Background(), layout)); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := ioutil.ReadDir(path.Join(cacheDir, "sha256")); len(cached) != 0 {
+		t.Errorf("the blob cache over the limit should be pruned, got %d blobs", len(cached))
+	}
+}
+
+func TestSaveComponentsPlatforms(t *testing.T) {
+	ram := testRAM()
+	ram.Components[0].Arch = "arm64"
+	client := &fakeImageClient{}
+	if err := SaveComponents(ram, client, t.TempDir(), logrus.StandardLogger(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if ps := client.pullPlatforms["nginx:1.19"]; len(ps) == 0 || ps[0] != "linux/arm64" {
+		t.Errorf("the arch of the component is not pulled: %v", ps)
+	}
+	if ps := client.savePlatforms; len(ps) == 0 || ps[0] != "linux/arm64" || ps[len(ps)-1] != platforms.DefaultString() {
+		t.Errorf("unexpected saved platforms %v", client.savePlatforms)
+	}
+
+	ctx := image.WithPlatforms(context.Background(), "linux/amd64", "linux/arm64")
+	if err := SaveComponentsWithContext(ctx, ram, client, t.TempDir(), logrus.StandardLogger(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if ps := client.pullPlatforms["mysql:5.7"]; len(ps) != 2 {
+		t.Errorf("the platforms of the package are not pulled: %v", ps)
+	}
+	if len(client.savePlatforms) != 2 {
+		t.Errorf("unexpected saved platforms %v", client.savePlatforms)
+	}
+}
+
+func TestSaveComponentsMixedArch(t *testing.T) {
+	for _, arch := range []string{"", "linux/amd64", "linux/arm/v7"} {
+		ram := testRAM()
+		ram.Components[0].Arch = "arm64"
+		ram.Components[1].Arch = arch
+		client := &fakeImageClient{}
+		if err := SaveComponents(ram, client, t.TempDir(), logrus.StandardLogger(), nil); err != nil {
+			t.Fatalf("components of arch arm64 and %q: %v", arch, err)
+		}
+		if ps := client.pullPlatforms["mysql:5.7"]; len(ps) != len(client.savePlatforms) {
+			t.Errorf("the image should be pulled for the saved platforms %v, got %v", client.savePlatforms, ps)
+		}
+	}
+}
+
+func TestSaveComponentsDedup(t *testing.T) {
+	ram := testRAM()
+	ram.Components[1].ShareImage = ram.Components[0].ShareImage
+	client := &fakeImageClient{}
+	ctx := image.WithPool(context.Background(), image.Pool{Concurrency: 2})
+	if err := SaveComponentsWithContext(ctx, ram, client, t.TempDir(), logrus.StandardLogger(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.pulled) != 1 {
+		t.Errorf("the shared image should be pulled once, got %v", client.pulled)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	ram := testRAM()
+	// a disabled probe is not validated
+	ram.Components[0].Probes = []v1alpha1.ComponentProbe{{Mode: "liveness"}}
+	if _, err := New(K8S, t.TempDir(), ram, nil, nil, logrus.StandardLogger(), WithRegistryClient(t.TempDir()), WithValidation()); err != nil {
+		t.Fatalf("the template with a disabled probe should be exported: %v", err)
+	}
+	ram.Components[0].Memory = -1
+	if _, err := New(K8S, t.TempDir(), ram, nil, nil, logrus.StandardLogger(), WithRegistryClient(t.TempDir()), WithValidation()); err == nil {
+		t.Fatalf("the invalid template should not be exported")
+	}
+	if _, err := New(K8S, t.TempDir(), ram, nil, nil, logrus.StandardLogger(), WithRegistryClient(t.TempDir())); err != nil {
+		t.Errorf("the template is not validated by default: %v", err)
+	}
+}
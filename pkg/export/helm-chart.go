@@ -1,17 +1,17 @@
 package export
 
 import (
+	"context"
 	"fmt"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
 	"github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"os"
 	"path"
 	"sigs.k8s.io/yaml"
-	"strings"
-	"time"
 )
 
 var (
@@ -29,54 +29,71 @@ type helmChartExporter struct {
 }
 
 func (h *helmChartExporter) Export() (*Result, error) {
+	return h.ExportWithContext(context.Background())
+}
+
+func (h *helmChartExporter) ExportWithContext(ctx context.Context) (re *Result, err error) {
+	packageName := packageFileName(ctx, fmt.Sprintf("%s-%s-helm.tar.gz", h.ram.AppName, h.ram.AppVersion))
+	defer func() {
+		cleanupCanceledExport(ctx, h.logger, err, h.exportPath, path.Join(h.homePath, packageName))
+	}()
 	h.logger.Infof("start export app %s to helm chart spec", h.ram.AppName)
+	// Delete the old application group directory and then regenerate the application package
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePrepare, Message: "prepare export dir"})
+	if err := PrepareExportDir(h.exportPath); err != nil {
+		h.logger.Errorf("prepare export dir failure %s", err.Error())
+		return nil, err
+	}
 	dependentImages, err := h.initHelmChart()
 	if err != nil {
 		return nil, err
 	}
-	if err := SaveComponents(h.ram, h.imageClient, h.exportPath, h.logger, dependentImages); err != nil {
+	if err := SaveComponentsWithContext(ctx, h.ram, h.imageClient, h.exportPath, h.logger, dependentImages); err != nil {
 		h.logger.Errorf("helm chart export save component failure %v", err)
 		return nil, err
 	}
 	h.logger.Infof("success save components")
-	// Save plugin attachments
-	if err := SavePlugins(h.ram, h.imageClient, h.exportPath, h.logger); err != nil {
+	if len(h.ram.Plugins) > 0 {
+		// Save plugin attachments
+		if err := SavePluginsWithContext(ctx, h.ram, h.imageClient, h.exportPath, h.logger); err != nil {
+			return nil, err
+		}
+		h.logger.Infof("success save plugins")
+	}
+	if err := WritePackageManifest(ctx, h.exportPath, h.ram, h.logger); err != nil {
+		h.logger.Error(err)
 		return nil, err
 	}
-	h.logger.Infof("success save plugins")
-	packageName := fmt.Sprintf("%s-%s-helm.tar.gz", h.ram.AppName, h.ram.AppVersion)
-	name, err := Packaging(packageName, h.homePath, h.exportPath)
+	name, err := PackagingWithContext(ctx, packageName, h.homePath, h.exportPath)
 	if err != nil {
 		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
 		h.logger.Error(err)
 		return nil, err
 	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseDone, Message: "export success"})
 	h.logger.Infof("success export app " + h.ram.AppName)
 	return &Result{PackagePath: path.Join(h.homePath, name), PackageName: name}, nil
 }
 
+// initHelmChart writes Chart.yaml, values.yaml and the templates, and returns
+// the images referenced by the k8s resources of the application.
 func (h *helmChartExporter) initHelmChart() ([]string, error) {
 	helmChartPath := path.Join(h.exportPath, h.ram.AppName)
+	if err := os.MkdirAll(path.Join(helmChartPath, "templates"), 0755); err != nil {
+		return nil, err
+	}
 	err := h.writeChartYaml(helmChartPath)
 	if err != nil {
 		h.logger.Errorf("%v writeChartYaml failure %v", h.ram.AppName, err)
 		return nil, err
 	}
 	h.logger.Infof("writeChartYaml success")
-	for i := 0; i < 40; i++ {
-		time.Sleep(1 * time.Second)
-		if CheckFileExist(path.Join(helmChartPath, "dependent_image.txt")) {
-			h.logger.Infof("dependent_image.txt creeate success")
-			break
-		}
-	}
-	content, err := os.ReadFile(path.Join(helmChartPath, "dependent_image.txt"))
-	if err != nil {
-		h.logger.Errorf("read file dependent_image.txt failure %v", err)
+	if err := h.writeComponentTemplates(helmChartPath); err != nil {
+		h.logger.Errorf("%v write component templates failure %v", h.ram.AppName, err)
 		return nil, err
 	}
-	dependentImages := strings.Split(string(content), "\n")
-	err = h.writeTemplateYaml(helmChartPath)
+	h.logger.Infof("write values and component templates success")
+	dependentImages, err := h.writeTemplateYaml(helmChartPath)
 	if err != nil {
 		return nil, err
 	}
@@ -108,13 +125,16 @@ func (h *helmChartExporter) writeChartYaml(helmChartPath string) error {
 	return h.write(path.Join(helmChartPath, "Chart.yaml"), cyYaml)
 }
 
-func (h *helmChartExporter) writeTemplateYaml(helmChartPath string) error {
+// writeTemplateYaml writes the k8s resources of the application into the
+// templates and returns the images their pod specs depend on.
+func (h *helmChartExporter) writeTemplateYaml(helmChartPath string) ([]string, error) {
 	helmChartTemplatePath := path.Join(helmChartPath, "templates")
+	var dependentImages []string
 	for _, k8sResource := range h.ram.K8sResources {
 		var unstructuredObject unstructured.Unstructured
 		err := yaml.Unmarshal([]byte(k8sResource.Content), &unstructuredObject)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		unstructuredObject.SetNamespace("")
 		unstructuredObject.SetResourceVersion("")
@@ -122,14 +142,38 @@ func (h *helmChartExporter) writeTemplateYaml(helmChartPath string) error {
 		unstructuredObject.SetUID("")
 		unstructuredYaml, err := yaml.Marshal(&unstructuredObject)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = h.write(path.Join(helmChartTemplatePath, fmt.Sprintf("%v.yaml", unstructuredObject.GetKind())), unstructuredYaml)
+		err = h.write(path.Join(helmChartTemplatePath, fmt.Sprintf("%v.yaml", unstructuredObject.GetKind())), escapeHelmTemplate(unstructuredYaml))
 		if err != nil {
-			return err
+			return nil, err
 		}
+		dependentImages = append(dependentImages, resourceImages(unstructuredObject.Object)...)
 	}
-	return nil
+	return dependentImages, nil
+}
+
+// resourceImages returns the container images of a workload or pod object
+func resourceImages(object map[string]interface{}) []string {
+	var images []string
+	for _, podSpecPath := range [][]string{{"spec"}, {"spec", "template", "spec"}, {"spec", "jobTemplate", "spec", "template", "spec"}} {
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, found, err := unstructured.NestedSlice(object, append(podSpecPath, field)...)
+			if err != nil || !found {
+				continue
+			}
+			for _, container := range containers {
+				c, ok := container.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if image, ok := c["image"].(string); ok && image != "" {
+					images = append(images, image)
+				}
+			}
+		}
+	}
+	return images
 }
 
 func CheckFileExist(fileName string) bool {
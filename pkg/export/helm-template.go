@@ -0,0 +1,283 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// writeComponentTemplates renders the workload, services, volume claims and
+// config maps of every component into the chart templates, and writes the
+// values they reference into values.yaml.
+func (h *helmChartExporter) writeComponentTemplates(helmChartPath string) error {
+	resources := newKubernetesResources(h.ram)
+	values := make(map[string]interface{})
+	for _, com := range h.ram.Components {
+		key := helmValuesKey(resources.Name(com))
+		values[key] = helmComponentValues(resources, com)
+		tpl := newHelmTemplate(key)
+		var docs []string
+		for _, obj := range resources.ComponentObjects(com) {
+			body, err := tpl.render(obj)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, string(body))
+		}
+		filename := path.Join(helmChartPath, "templates", resources.Name(com)+".yaml")
+		if err := ioutil.WriteFile(filename, []byte(strings.Join(docs, "---\n")), 0644); err != nil {
+			return err
+		}
+	}
+	var docs []string
+	for _, cm := range resources.AppConfigGroups() {
+		body, err := yaml.Marshal(cm)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, string(escapeHelmTemplate(body)))
+	}
+	if len(docs) > 0 {
+		filename := path.Join(helmChartPath, "templates", "app-config-groups.yaml")
+		if err := ioutil.WriteFile(filename, []byte(strings.Join(docs, "---\n")), 0644); err != nil {
+			return err
+		}
+	}
+	body, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(helmChartPath, "values.yaml"), body, 0644)
+}
+
+var invalidHelmValuesKeyChars = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// helmValuesKey converts a resource name into a camel case key that can be
+// referenced as .Values.<key> in the templates
+func helmValuesKey(name string) string {
+	parts := invalidHelmValuesKeyChars.Split(name, -1)
+	var key string
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		if key == "" {
+			key = part
+			continue
+		}
+		key += strings.ToUpper(part[:1]) + part[1:]
+	}
+	if key == "" || (key[0] >= '0' && key[0] <= '9') {
+		key = "component" + key
+	}
+	return key
+}
+
+func helmComponentValues(resources *kubernetesResources, com *v1alpha1.Component) map[string]interface{} {
+	repository, tag := splitImageTag(com.ShareImage)
+	replicas := com.ExtendMethodRule.MinNode
+	if replicas < 1 {
+		replicas = 1
+	}
+	env := make(map[string]string)
+	for _, e := range resources.Env(com) {
+		env[e.Name] = e.Value
+	}
+	limits := make(map[string]string)
+	for name, quantity := range resources.Resources(com.Memory, com.CPU).Limits {
+		limits[string(name)] = quantity.String()
+	}
+	ports := make(map[string]int)
+	for _, port := range com.Ports {
+		ports[fmt.Sprintf("port%d", port.ContainerPort)] = port.ContainerPort
+	}
+	return map[string]interface{}{
+		"image": map[string]string{
+			"repository": repository,
+			"tag":        tag,
+		},
+		"replicas": replicas,
+		"resources": map[string]interface{}{
+			"limits": limits,
+		},
+		"env": env,
+		"service": map[string]interface{}{
+			"type":  string(core.ServiceTypeClusterIP),
+			"ports": ports,
+		},
+	}
+}
+
+// splitImageTag splits an image reference into repository and tag, a digest
+// reference is split at the algorithm so that it's joined back unchanged.
+func splitImageTag(image string) (string, string) {
+	i := strings.LastIndex(image, ":")
+	if i <= strings.LastIndex(image, "/") {
+		return image, "latest"
+	}
+	return image[:i], image[i+1:]
+}
+
+// helmTemplate replaces the fields of a kubernetes object with references to
+// the chart values. The object is marshaled with placeholder tokens first, the
+// rest of the content is escaped, and the tokens are finally replaced with the
+// template actions.
+type helmTemplate struct {
+	key    string
+	scalar map[string]string
+	block  map[string][]string
+}
+
+func newHelmTemplate(key string) *helmTemplate {
+	return &helmTemplate{
+		key:    key,
+		scalar: make(map[string]string),
+		block:  make(map[string][]string),
+	}
+}
+
+func (t *helmTemplate) token() string {
+	return fmt.Sprintf("__HELM_VALUE_%d__", len(t.scalar)+len(t.block))
+}
+
+// value returns a placeholder for a scalar action
+func (t *helmTemplate) value(action string) string {
+	token := t.token()
+	t.scalar[token] = action
+	return token
+}
+
+// lines returns a placeholder for a multi-line block under a mapping key
+func (t *helmTemplate) lines(lines ...string) string {
+	token := t.token()
+	t.block[token] = lines
+	return token
+}
+
+func (t *helmTemplate) ref(path string) string {
+	return fmt.Sprintf(".Values.%s.%s", t.key, path)
+}
+
+func (t *helmTemplate) render(obj interface{}) ([]byte, error) {
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	switch kind, _, _ := unstructured.NestedString(object, "kind"); kind {
+	case "Deployment", "StatefulSet":
+		if err := t.parameterizeWorkload(object); err != nil {
+			return nil, err
+		}
+	case "Service":
+		if err := t.parameterizeService(object); err != nil {
+			return nil, err
+		}
+	}
+	body, err := yaml.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	return t.replace(escapeHelmTemplate(body)), nil
+}
+
+func (t *helmTemplate) parameterizeWorkload(object map[string]interface{}) error {
+	if err := unstructured.SetNestedField(object, t.value(fmt.Sprintf("{{ %s }}", t.ref("replicas"))), "spec", "replicas"); err != nil {
+		return err
+	}
+	containers, _, err := unstructured.NestedSlice(object, "spec", "template", "spec", "containers")
+	if err != nil || len(containers) == 0 {
+		return err
+	}
+	container := containers[0].(map[string]interface{})
+	container["image"] = t.value(fmt.Sprintf("{{ %s }}:{{ %s }}", t.ref("image.repository"), t.ref("image.tag")))
+	container["resources"] = t.lines(fmt.Sprintf("{{- toYaml %s | nindent %%d }}", t.ref("resources")))
+	container["env"] = t.lines(
+		fmt.Sprintf("{{- range $name, $value := %s }}", t.ref("env")),
+		"- name: {{ $name }}",
+		"  value: {{ $value | quote }}",
+		"{{- end }}",
+	)
+	return unstructured.SetNestedSlice(object, containers, "spec", "template", "spec", "containers")
+}
+
+func (t *helmTemplate) parameterizeService(object map[string]interface{}) error {
+	if clusterIP, _, _ := unstructured.NestedString(object, "spec", "clusterIP"); clusterIP == core.ClusterIPNone {
+		// the headless service of a statefulset is not configurable
+		return nil
+	}
+	if err := unstructured.SetNestedField(object, t.value(fmt.Sprintf("{{ %s }}", t.ref("service.type"))), "spec", "type"); err != nil {
+		return err
+	}
+	ports, _, err := unstructured.NestedSlice(object, "spec", "ports")
+	if err != nil {
+		return err
+	}
+	for _, p := range ports {
+		port := p.(map[string]interface{})
+		if number, ok := port["port"].(int64); ok {
+			port["port"] = t.value(fmt.Sprintf("{{ %s }}", t.ref(fmt.Sprintf("service.ports.port%d", number))))
+		}
+	}
+	return unstructured.SetNestedSlice(object, ports, "spec", "ports")
+}
+
+var blockLine = regexp.MustCompile(`(?m)^( *)(- )?([A-Za-z]+): (__HELM_VALUE_\d+__)$`)
+
+func (t *helmTemplate) replace(body []byte) []byte {
+	content := blockLine.ReplaceAllStringFunc(string(body), func(line string) string {
+		match := blockLine.FindStringSubmatch(line)
+		lines, ok := t.block[match[4]]
+		if !ok {
+			return line
+		}
+		indent := len(match[1]) + len(match[2]) + 2
+		result := fmt.Sprintf("%s%s%s:", match[1], match[2], match[3])
+		for _, l := range lines {
+			if strings.Contains(l, "%d") {
+				l = fmt.Sprintf(l, indent)
+			}
+			result += "\n" + strings.Repeat(" ", indent) + l
+		}
+		return result
+	})
+	for token, action := range t.scalar {
+		content = strings.Replace(content, token, action, -1)
+	}
+	return []byte(content)
+}
+
+var helmDelimiters = regexp.MustCompile(`\{\{|\}\}`)
+
+// escapeHelmTemplate escapes the template delimiters in rendered content, so that
+// helm outputs them verbatim.
+func escapeHelmTemplate(body []byte) []byte {
+	return helmDelimiters.ReplaceAllFunc(body, func(delim []byte) []byte {
+		return []byte(fmt.Sprintf(`{{ "%s" }}`, delim))
+	})
+}
@@ -0,0 +1,70 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHelmTemplateRender(t *testing.T) {
+	ram := testRAM()
+	resources := newKubernetesResources(ram)
+	web := ram.Components[0]
+	key := helmValuesKey(resources.Name(web))
+	if key != "webServer" {
+		t.Fatalf("unexpected values key %s", key)
+	}
+	tpl := newHelmTemplate(key)
+	var content string
+	for _, obj := range resources.ComponentObjects(web) {
+		body, err := tpl.render(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content += string(body)
+	}
+	for _, expect := range []string{
+		"replicas: {{ .Values.webServer.replicas }}",
+		"image: {{ .Values.webServer.image.repository }}:{{ .Values.webServer.image.tag }}",
+		"{{- toYaml .Values.webServer.resources | nindent 10 }}",
+		"{{- range $name, $value := .Values.webServer.env }}",
+		"port: {{ .Values.webServer.service.ports.port80 }}",
+		"type: {{ .Values.webServer.service.type }}",
+	} {
+		if !strings.Contains(content, expect) {
+			t.Errorf("template does not contain %q:\n%s", expect, content)
+		}
+	}
+
+	values := helmComponentValues(resources, web)
+	if values["replicas"] != 2 {
+		t.Errorf("unexpected replicas value %v", values["replicas"])
+	}
+	if image := values["image"].(map[string]string); image["repository"] != "nginx" || image["tag"] != "1.19" {
+		t.Errorf("unexpected image value %v", image)
+	}
+}
+
+func TestEscapeHelmTemplate(t *testing.T) {
+	got := string(escapeHelmTemplate([]byte("value: {{ .Name }}")))
+	if got != `value: {{ "{{" }} .Name {{ "}}" }}` {
+		t.Errorf("unexpected escaped content %s", got)
+	}
+}
@@ -0,0 +1,760 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
+	"github.com/sirupsen/logrus"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+type kubernetesExporter struct {
+	logger      *logrus.Logger
+	ram         v1alpha1.RainbondApplicationConfig
+	imageClient image.Client
+	mode        string
+	homePath    string
+	exportPath  string
+}
+
+func (k *kubernetesExporter) Export() (*Result, error) {
+	return k.ExportWithContext(context.Background())
+}
+
+func (k *kubernetesExporter) ExportWithContext(ctx context.Context) (re *Result, err error) {
+	packageName := packageFileName(ctx, fmt.Sprintf("%s-%s-k8s.tar.gz", k.ram.AppName, k.ram.AppVersion))
+	defer func() {
+		cleanupCanceledExport(ctx, k.logger, err, k.exportPath, path.Join(k.homePath, packageName))
+	}()
+	k.logger.Infof("start export app %s to kubernetes manifests", k.ram.AppName)
+	// Delete the old application group directory and then regenerate the application package
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePrepare, Message: "prepare export dir"})
+	if err := PrepareExportDir(k.exportPath); err != nil {
+		k.logger.Errorf("prepare export dir failure %s", err.Error())
+		return nil, err
+	}
+	k.logger.Infof("success prepare export dir")
+	if k.mode == "offline" && len(k.ram.Components) > 0 {
+		// Save components attachments
+		if err := SaveComponentsWithContext(ctx, k.ram, k.imageClient, k.exportPath, k.logger, []string{}); err != nil {
+			return nil, err
+		}
+		k.logger.Infof("success save components")
+	}
+	if err := k.writeManifests(); err != nil {
+		return nil, err
+	}
+	k.logger.Infof("success write kubernetes manifests")
+	if err := WritePackageManifest(ctx, k.exportPath, k.ram, k.logger); err != nil {
+		k.logger.Error(err)
+		return nil, err
+	}
+	// packaging
+	name, err := PackagingWithContext(ctx, packageName, k.homePath, k.exportPath)
+	if err != nil {
+		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
+		k.logger.Error(err)
+		return nil, err
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseDone, Message: "export success"})
+	k.logger.Infof("success export app " + k.ram.AppName)
+	return &Result{PackagePath: path.Join(k.homePath, name), PackageName: name}, nil
+}
+
+// writeManifests writes one multi-document yaml file per component, plus the
+// application level config groups and ingresses, into the manifests directory.
+func (k *kubernetesExporter) writeManifests() error {
+	manifestsPath := path.Join(k.exportPath, "manifests")
+	if err := os.MkdirAll(manifestsPath, 0755); err != nil {
+		return err
+	}
+	resources := newKubernetesResources(k.ram)
+	for _, com := range k.ram.Components {
+		objects := resources.ComponentObjects(com)
+		if err := writeYamlDocuments(path.Join(manifestsPath, resources.Name(com)+".yaml"), objects); err != nil {
+			k.logger.Errorf("write component %s manifests failure %s", com.ServiceCname, err.Error())
+			return err
+		}
+	}
+	if configMaps := resources.AppConfigGroups(); len(configMaps) > 0 {
+		var objects []interface{}
+		for _, cm := range configMaps {
+			objects = append(objects, cm)
+		}
+		if err := writeYamlDocuments(path.Join(manifestsPath, "app-config-groups.yaml"), objects); err != nil {
+			return err
+		}
+	}
+	if ingresses := resources.Ingresses(); len(ingresses) > 0 {
+		var objects []interface{}
+		for _, ing := range ingresses {
+			objects = append(objects, ing)
+		}
+		if err := writeYamlDocuments(path.Join(manifestsPath, "ingresses.yaml"), objects); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeYamlDocuments(filename string, objects []interface{}) error {
+	var docs []string
+	for _, obj := range objects {
+		body, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, string(body))
+	}
+	return ioutil.WriteFile(filename, []byte(strings.Join(docs, "---\n")), 0644)
+}
+
+const (
+	k8sNameLabel    = "app.kubernetes.io/name"
+	k8sPartOfLabel  = "app.kubernetes.io/part-of"
+	k8sVersionLabel = "app.kubernetes.io/version"
+)
+
+var invalidK8sNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// k8sName converts a display name into a valid RFC 1123 label
+func k8sName(name string, maxLength int) string {
+	name = strings.ToLower(composeName(name))
+	name = invalidK8sNameChars.ReplaceAllString(name, "-")
+	if len(name) > maxLength {
+		name = name[:maxLength]
+	}
+	return strings.Trim(name, "-")
+}
+
+// kubernetesResources builds plain kubernetes objects from a rainbond application template
+type kubernetesResources struct {
+	ram          v1alpha1.RainbondApplicationConfig
+	appName      string
+	names        map[string]string
+	connectEnvs  map[string][]core.EnvVar
+	volumeClaims map[string]string
+}
+
+func newKubernetesResources(ram v1alpha1.RainbondApplicationConfig) *kubernetesResources {
+	k := &kubernetesResources{
+		ram:          ram,
+		appName:      k8sName(ram.AppName, 40),
+		names:        make(map[string]string),
+		connectEnvs:  make(map[string][]core.EnvVar),
+		volumeClaims: make(map[string]string),
+	}
+	if k.appName == "" {
+		k.appName = "app"
+	}
+	set := make(map[string]struct{})
+	for _, com := range ram.Components {
+		name := k8sName(com.K8SComponentName, 40)
+		if name == "" {
+			name = k8sName(com.ServiceCname, 40)
+		}
+		if name == "" {
+			name = "component"
+		}
+		// make sure every name is unique, the suffix is stable so that exports are reproducible
+		for i, base := 2, name; ; i++ {
+			if _, exists := set[name]; !exists {
+				break
+			}
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		set[name] = struct{}{}
+		k.names[componentID(com)] = name
+		// The connection info is shared by the component and all the components that depend on it,
+		// so the random values must be generated only once.
+		for _, env := range com.ServiceConnectInfoMapList {
+			value := env.AttrValue
+			if value == "**None**" {
+				value = util.NewUUID()[:8]
+			}
+			k.connectEnvs[componentID(com)] = append(k.connectEnvs[componentID(com)], core.EnvVar{Name: env.AttrName, Value: value})
+		}
+	}
+	for _, com := range ram.Components {
+		for _, vol := range com.ServiceVolumeMapList {
+			if vol.VolumeType == v1alpha1.ConfigFileVolumeType || vol.VolumeType == v1alpha1.MemoryFSVolumeType {
+				continue
+			}
+			claim := k.volumeName(com, vol.VolumeName)
+			if isStateful(com) {
+				// the claims of a statefulset are created from its templates per pod,
+				// other components share the claim of the first pod
+				claim = fmt.Sprintf("%s-%s-0", claim, k.Name(com))
+			}
+			k.volumeClaims[com.ServiceShareID+vol.VolumeName] = claim
+		}
+	}
+	return k
+}
+
+func componentID(com *v1alpha1.Component) string {
+	if com.ServiceShareID != "" {
+		return com.ServiceShareID
+	}
+	return com.ComponentKey
+}
+
+// Name returns the kubernetes resource name of the component
+func (k *kubernetesResources) Name(com *v1alpha1.Component) string {
+	return k.names[componentID(com)]
+}
+
+func (k *kubernetesResources) findComponent(key string) *v1alpha1.Component {
+	for _, com := range k.ram.Components {
+		if com.ComponentKey == key || com.ServiceShareID == key {
+			return com
+		}
+	}
+	return nil
+}
+
+func (k *kubernetesResources) volumeName(com *v1alpha1.Component, volumeName string) string {
+	return k8sName(fmt.Sprintf("%s-%s", k.Name(com), volumeName), 63)
+}
+
+func (k *kubernetesResources) serviceName(com *v1alpha1.Component, port int) string {
+	return fmt.Sprintf("%s-%d", k.Name(com), port)
+}
+
+func (k *kubernetesResources) labels(com *v1alpha1.Component) map[string]string {
+	labels := map[string]string{
+		k8sNameLabel:   k.Name(com),
+		k8sPartOfLabel: k.appName,
+	}
+	if com.Version != "" && len(com.Version) <= 63 {
+		labels[k8sVersionLabel] = k8sName(com.Version, 63)
+	}
+	return labels
+}
+
+func (k *kubernetesResources) selector(com *v1alpha1.Component) map[string]string {
+	return map[string]string{
+		k8sNameLabel:   k.Name(com),
+		k8sPartOfLabel: k.appName,
+	}
+}
+
+func (k *kubernetesResources) objectMeta(com *v1alpha1.Component, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:   name,
+		Labels: k.labels(com),
+	}
+}
+
+// ComponentObjects returns the workload, services, volume claims and config maps of the component
+func (k *kubernetesResources) ComponentObjects(com *v1alpha1.Component) []interface{} {
+	var objects []interface{}
+	for _, cm := range k.ConfigMaps(com) {
+		objects = append(objects, cm)
+	}
+	if !isStateful(com) {
+		for _, pvc := range k.PersistentVolumeClaims(com) {
+			objects = append(objects, pvc)
+		}
+	}
+	for _, svc := range k.Services(com) {
+		objects = append(objects, svc)
+	}
+	objects = append(objects, k.Workload(com))
+	return objects
+}
+
+func isStateful(com *v1alpha1.Component) bool {
+	return com.DeployType == v1alpha1.StateSingletonDeployType || com.DeployType == v1alpha1.StateMultipleDeployType
+}
+
+// Workload returns a StatefulSet for state components and a Deployment for the others
+func (k *kubernetesResources) Workload(com *v1alpha1.Component) interface{} {
+	replicas := int32(com.ExtendMethodRule.MinNode)
+	if replicas < 1 {
+		replicas = 1
+	}
+	if isStateful(com) {
+		return &apps.StatefulSet{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+			ObjectMeta: k.objectMeta(com, k.Name(com)),
+			Spec: apps.StatefulSetSpec{
+				Replicas:             &replicas,
+				ServiceName:          k.Name(com),
+				Selector:             &metav1.LabelSelector{MatchLabels: k.selector(com)},
+				Template:             k.PodTemplate(com),
+				VolumeClaimTemplates: k.volumeClaimTemplates(com),
+				UpdateStrategy: apps.StatefulSetUpdateStrategy{
+					Type: apps.RollingUpdateStatefulSetStrategyType,
+				},
+			},
+		}
+	}
+	return &apps.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: k.objectMeta(com, k.Name(com)),
+		Spec: apps.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: k.selector(com)},
+			Template: k.PodTemplate(com),
+		},
+	}
+}
+
+// PodTemplate returns the pod template of the component workload
+func (k *kubernetesResources) PodTemplate(com *v1alpha1.Component) core.PodTemplateSpec {
+	volumes, mounts := k.volumes(com)
+	container := core.Container{
+		Name:           k.Name(com),
+		Image:          com.ShareImage,
+		Args:           strings.Fields(com.Cmd),
+		Env:            k.Env(com),
+		EnvFrom:        k.envFrom(com),
+		Ports:          k.containerPorts(com),
+		Resources:      k.Resources(com.Memory, com.CPU),
+		VolumeMounts:   mounts,
+		LivenessProbe:  k.probe(com, "liveness"),
+		ReadinessProbe: k.probe(com, "readiness"),
+	}
+	return core.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: k.labels(com),
+		},
+		Spec: core.PodSpec{
+			Containers:    []core.Container{container},
+			Volumes:       volumes,
+			RestartPolicy: core.RestartPolicyAlways,
+		},
+	}
+}
+
+// Env returns the environment variables of the component, including the
+// connection information of the components it depends on.
+func (k *kubernetesResources) Env(com *v1alpha1.Component) []core.EnvVar {
+	var envs []core.EnvVar
+	var index = make(map[string]int)
+	add := func(env core.EnvVar) {
+		if i, ok := index[env.Name]; ok {
+			envs[i] = env
+			return
+		}
+		index[env.Name] = len(envs)
+		envs = append(envs, env)
+	}
+	if len(com.Ports) > 0 {
+		add(core.EnvVar{Name: "PORT", Value: fmt.Sprintf("%d", com.Ports[0].ContainerPort)})
+	}
+	for _, dep := range com.DepServiceMapList {
+		depCom := k.findComponent(dep.DepServiceKey)
+		if depCom == nil {
+			logrus.Warningf("[kubernetes] dependent component %s not found", dep.DepServiceKey)
+			continue
+		}
+		for _, env := range k.connectEnvs[componentID(depCom)] {
+			add(env)
+		}
+	}
+	for _, env := range com.Envs {
+		add(core.EnvVar{Name: env.AttrName, Value: env.AttrValue})
+	}
+	for _, env := range k.connectEnvs[componentID(com)] {
+		add(env)
+	}
+	return envs
+}
+
+func (k *kubernetesResources) envFrom(com *v1alpha1.Component) []core.EnvFromSource {
+	var sources []core.EnvFromSource
+	for _, group := range k.ram.AppConfigGroups {
+		for _, key := range group.ComponentKeys {
+			if key == com.ComponentKey || key == com.ServiceShareID {
+				sources = append(sources, core.EnvFromSource{
+					ConfigMapRef: &core.ConfigMapEnvSource{
+						LocalObjectReference: core.LocalObjectReference{Name: k.configGroupName(group)},
+					},
+				})
+				break
+			}
+		}
+	}
+	return sources
+}
+
+func (k *kubernetesResources) containerPorts(com *v1alpha1.Component) []core.ContainerPort {
+	var ports []core.ContainerPort
+	for _, port := range com.Ports {
+		ports = append(ports, core.ContainerPort{
+			Name:          fmt.Sprintf("port-%d", port.ContainerPort),
+			ContainerPort: int32(port.ContainerPort),
+			Protocol:      protocol(port.Protocol),
+		})
+	}
+	return ports
+}
+
+func protocol(p string) core.Protocol {
+	switch strings.ToLower(p) {
+	case "udp":
+		return core.ProtocolUDP
+	case "sctp":
+		return core.ProtocolSCTP
+	default:
+		return core.ProtocolTCP
+	}
+}
+
+// Resources returns the container resource limits, memory unit MB and cpu unit millicore
+func (k *kubernetesResources) Resources(memory, cpu int) core.ResourceRequirements {
+	var requirements core.ResourceRequirements
+	limits := core.ResourceList{}
+	if memory > 0 {
+		limits[core.ResourceMemory] = resource.MustParse(fmt.Sprintf("%dMi", memory))
+	}
+	if cpu > 0 {
+		limits[core.ResourceCPU] = resource.MustParse(fmt.Sprintf("%dm", cpu))
+	}
+	if len(limits) > 0 {
+		requirements.Limits = limits
+	}
+	return requirements
+}
+
+func (k *kubernetesResources) probe(com *v1alpha1.Component, mode string) *core.Probe {
+	for _, probe := range com.Probes {
+		if !probe.IsUsed || probe.Mode != mode {
+			continue
+		}
+		p := &core.Probe{
+			InitialDelaySeconds: int32(probe.InitialDelaySecond),
+			TimeoutSeconds:      int32(probe.TimeoutSecond),
+			PeriodSeconds:       int32(probe.PeriodSecond),
+			SuccessThreshold:    int32(probe.SuccessThreshold),
+			FailureThreshold:    int32(probe.FailureThreshold),
+		}
+		switch {
+		case probe.Cmd != "":
+			p.Exec = &core.ExecAction{Command: []string{"/bin/sh", "-c", probe.Cmd}}
+		case strings.ToLower(probe.Scheme) == "http":
+			p.HTTPGet = &core.HTTPGetAction{
+				Path:        probe.Path,
+				Port:        intstr.FromInt(probe.Port),
+				HTTPHeaders: httpHeaders(probe.HTTPHeader),
+			}
+		default:
+			p.TCPSocket = &core.TCPSocketAction{Port: intstr.FromInt(probe.Port)}
+		}
+		return p
+	}
+	return nil
+}
+
+func httpHeaders(header string) []core.HTTPHeader {
+	if header == "" {
+		return nil
+	}
+	var headers []core.HTTPHeader
+	for _, hd := range strings.Split(header, ",") {
+		kv := strings.SplitN(hd, "=", 2)
+		h := core.HTTPHeader{Name: kv[0]}
+		if len(kv) == 2 {
+			h.Value = kv[1]
+		}
+		headers = append(headers, h)
+	}
+	return headers
+}
+
+func accessMode(mode v1alpha1.AccessMode) core.PersistentVolumeAccessMode {
+	switch mode {
+	case v1alpha1.RWXAccessMode:
+		return core.ReadWriteMany
+	case v1alpha1.ROXAccessMode:
+		return core.ReadOnlyMany
+	default:
+		return core.ReadWriteOnce
+	}
+}
+
+func (k *kubernetesResources) volumeClaimSpec(vol v1alpha1.ComponentVolume) core.PersistentVolumeClaimSpec {
+	capacity := vol.VolumeCapacity
+	if capacity <= 0 {
+		capacity = 1
+	}
+	mode := vol.AccessMode
+	if mode == "" && vol.VolumeType == v1alpha1.ShareFileVolumeType {
+		mode = v1alpha1.RWXAccessMode
+	}
+	return core.PersistentVolumeClaimSpec{
+		AccessModes: []core.PersistentVolumeAccessMode{accessMode(mode)},
+		Resources: core.ResourceRequirements{
+			Requests: core.ResourceList{
+				core.ResourceStorage: resource.MustParse(fmt.Sprintf("%dGi", capacity)),
+			},
+		},
+	}
+}
+
+// PersistentVolumeClaims returns a volume claim for every storage volume of the component
+func (k *kubernetesResources) PersistentVolumeClaims(com *v1alpha1.Component) []*core.PersistentVolumeClaim {
+	var claims []*core.PersistentVolumeClaim
+	for _, vol := range com.ServiceVolumeMapList {
+		if vol.VolumeType == v1alpha1.ConfigFileVolumeType || vol.VolumeType == v1alpha1.MemoryFSVolumeType {
+			continue
+		}
+		claims = append(claims, &core.PersistentVolumeClaim{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+			ObjectMeta: k.objectMeta(com, k.volumeName(com, vol.VolumeName)),
+			Spec:       k.volumeClaimSpec(vol),
+		})
+	}
+	return claims
+}
+
+func (k *kubernetesResources) volumeClaimTemplates(com *v1alpha1.Component) []core.PersistentVolumeClaim {
+	var claims []core.PersistentVolumeClaim
+	for _, pvc := range k.PersistentVolumeClaims(com) {
+		claim := *pvc
+		claim.TypeMeta = metav1.TypeMeta{}
+		claims = append(claims, claim)
+	}
+	return claims
+}
+
+// ConfigMaps returns a config map for every config file volume of the component
+func (k *kubernetesResources) ConfigMaps(com *v1alpha1.Component) []*core.ConfigMap {
+	var configMaps []*core.ConfigMap
+	for _, vol := range com.ServiceVolumeMapList {
+		if vol.VolumeType != v1alpha1.ConfigFileVolumeType {
+			continue
+		}
+		configMaps = append(configMaps, &core.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: k.objectMeta(com, k.volumeName(com, vol.VolumeName)),
+			Data: map[string]string{
+				configFileKey(vol.VolumeMountPath): vol.FileConent,
+			},
+		})
+	}
+	return configMaps
+}
+
+func configFileKey(mountPath string) string {
+	key := path.Base(mountPath)
+	if key == "/" || key == "." {
+		return "config"
+	}
+	return key
+}
+
+func (k *kubernetesResources) volumes(com *v1alpha1.Component) ([]core.Volume, []core.VolumeMount) {
+	var volumes []core.Volume
+	var mounts []core.VolumeMount
+	for _, vol := range com.ServiceVolumeMapList {
+		name := k.volumeName(com, vol.VolumeName)
+		switch vol.VolumeType {
+		case v1alpha1.ConfigFileVolumeType:
+			configMap := &core.ConfigMapVolumeSource{
+				LocalObjectReference: core.LocalObjectReference{Name: name},
+			}
+			if vol.Mode != nil {
+				mode := int32(*vol.Mode)
+				configMap.DefaultMode = &mode
+			}
+			volumes = append(volumes, core.Volume{Name: name, VolumeSource: core.VolumeSource{ConfigMap: configMap}})
+			mounts = append(mounts, core.VolumeMount{
+				Name:      name,
+				MountPath: vol.VolumeMountPath,
+				SubPath:   configFileKey(vol.VolumeMountPath),
+			})
+			continue
+		case v1alpha1.MemoryFSVolumeType:
+			volumes = append(volumes, core.Volume{Name: name, VolumeSource: core.VolumeSource{
+				EmptyDir: &core.EmptyDirVolumeSource{Medium: core.StorageMediumMemory},
+			}})
+		default:
+			// the volume claim templates of a statefulset are mounted by name
+			if !isStateful(com) {
+				volumes = append(volumes, core.Volume{Name: name, VolumeSource: core.VolumeSource{
+					PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{ClaimName: name},
+				}})
+			}
+		}
+		mounts = append(mounts, core.VolumeMount{Name: name, MountPath: vol.VolumeMountPath})
+	}
+	for _, share := range com.MntReleationList {
+		claim, ok := k.volumeClaims[share.ShareServiceUUID+share.VolumeName]
+		if !ok {
+			logrus.Warningf("[kubernetes] dependent volume(%s/%s) not found", share.ShareServiceUUID, share.VolumeName)
+			continue
+		}
+		name := k8sName(fmt.Sprintf("%s-mnt-%s", k.Name(com), share.VolumeName), 63)
+		volumes = append(volumes, core.Volume{Name: name, VolumeSource: core.VolumeSource{
+			PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		}})
+		mounts = append(mounts, core.VolumeMount{Name: name, MountPath: share.VolumeMountDir})
+	}
+	return volumes, mounts
+}
+
+// Services returns a service for every port of the component, and a headless
+// service named after the component for state components.
+func (k *kubernetesResources) Services(com *v1alpha1.Component) []*core.Service {
+	var services []*core.Service
+	if isStateful(com) {
+		headless := &core.Service{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+			ObjectMeta: k.objectMeta(com, k.Name(com)),
+			Spec: core.ServiceSpec{
+				ClusterIP: core.ClusterIPNone,
+				Selector:  k.selector(com),
+			},
+		}
+		for _, port := range com.Ports {
+			headless.Spec.Ports = append(headless.Spec.Ports, k.servicePort(port))
+		}
+		services = append(services, headless)
+	}
+	for _, port := range com.Ports {
+		services = append(services, &core.Service{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+			ObjectMeta: k.objectMeta(com, k.serviceName(com, port.ContainerPort)),
+			Spec: core.ServiceSpec{
+				Selector: k.selector(com),
+				Ports:    []core.ServicePort{k.servicePort(port)},
+			},
+		})
+	}
+	return services
+}
+
+func (k *kubernetesResources) servicePort(port v1alpha1.ComponentPort) core.ServicePort {
+	return core.ServicePort{
+		Name:       fmt.Sprintf("port-%d", port.ContainerPort),
+		Protocol:   protocol(port.Protocol),
+		Port:       int32(port.ContainerPort),
+		TargetPort: intstr.FromInt(port.ContainerPort),
+	}
+}
+
+func (k *kubernetesResources) configGroupName(group *v1alpha1.AppConfigGroup) string {
+	return k8sName(fmt.Sprintf("%s-%s", k.appName, group.Name), 63)
+}
+
+// AppConfigGroups returns a config map for every application config group
+func (k *kubernetesResources) AppConfigGroups() []*core.ConfigMap {
+	var configMaps []*core.ConfigMap
+	for _, group := range k.ram.AppConfigGroups {
+		configMaps = append(configMaps, &core.ConfigMap{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   k.configGroupName(group),
+				Labels: map[string]string{k8sPartOfLabel: k.appName},
+			},
+			Data: group.ConfigItems,
+		})
+	}
+	return configMaps
+}
+
+// Ingresses returns an ingress for every http route of the application. The routes of the
+// app template have no domain, so the rules have no host and match all hosts, the hosts are
+// expected to be set at install time.
+func (k *kubernetesResources) Ingresses() []*networking.Ingress {
+	var ingresses []*networking.Ingress
+	pathType := networking.PathTypePrefix
+	for i, route := range k.ram.IngressHTTPRoutes {
+		com := k.findComponent(route.ComponentKey)
+		if com == nil {
+			logrus.Warningf("[kubernetes] ingress target component %s not found", route.ComponentKey)
+			continue
+		}
+		location := route.Location
+		if location == "" {
+			location = "/"
+		}
+		ingresses = append(ingresses, &networking.Ingress{
+			TypeMeta: metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("%s-%d", k.serviceName(com, int(route.Port)), i),
+				Labels:      k.labels(com),
+				Annotations: ingressAnnotations(route),
+			},
+			Spec: networking.IngressSpec{
+				Rules: []networking.IngressRule{{
+					IngressRuleValue: networking.IngressRuleValue{
+						HTTP: &networking.HTTPIngressRuleValue{
+							Paths: []networking.HTTPIngressPath{{
+								Path:     location,
+								PathType: &pathType,
+								Backend: networking.IngressBackend{
+									Service: &networking.IngressServiceBackend{
+										Name: k.serviceName(com, int(route.Port)),
+										Port: networking.ServiceBackendPort{Number: int32(route.Port)},
+									},
+								},
+							}},
+						},
+					},
+				}},
+			},
+		})
+	}
+	return ingresses
+}
+
+// ingressAnnotations converts the rainbond gateway settings into ingress-nginx annotations
+func ingressAnnotations(route *v1alpha1.IngressHTTPRoute) map[string]string {
+	annotations := make(map[string]string)
+	if route.ConnectionTimeout > 0 {
+		annotations["nginx.ingress.kubernetes.io/proxy-connect-timeout"] = fmt.Sprintf("%d", route.ConnectionTimeout)
+	}
+	if route.RequestTimeout > 0 {
+		annotations["nginx.ingress.kubernetes.io/proxy-send-timeout"] = fmt.Sprintf("%d", route.RequestTimeout)
+	}
+	if route.ResponseTimeout > 0 {
+		annotations["nginx.ingress.kubernetes.io/proxy-read-timeout"] = fmt.Sprintf("%d", route.ResponseTimeout)
+	}
+	if route.RequestBodySizeLimit > 0 {
+		annotations["nginx.ingress.kubernetes.io/proxy-body-size"] = fmt.Sprintf("%dm", route.RequestBodySizeLimit)
+	}
+	if route.LoadBalancing == "cookie-session" {
+		annotations["nginx.ingress.kubernetes.io/affinity"] = "cookie"
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
@@ -0,0 +1,149 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	apps "k8s.io/api/apps/v1"
+)
+
+func testRAM() v1alpha1.RainbondApplicationConfig {
+	return v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{
+			{
+				ServiceCname:   "Web Server",
+				ComponentKey:   "web",
+				ServiceShareID: "web",
+				DeployType:     v1alpha1.StatelessMultipleDeployType,
+				ShareImage:     "nginx:1.19",
+				Memory:         512,
+				CPU:            250,
+				Ports:          []v1alpha1.ComponentPort{{ContainerPort: 80, Protocol: "http"}},
+				Envs:           []v1alpha1.ComponentEnv{{AttrName: "DEBUG", AttrValue: "1"}},
+				ServiceVolumeMapList: v1alpha1.ComponentVolumeList{
+					{VolumeName: "conf", VolumeMountPath: "/etc/nginx/nginx.conf", VolumeType: v1alpha1.ConfigFileVolumeType, FileConent: "worker_processes 1;"},
+				},
+				DepServiceMapList: []v1alpha1.ComponentDep{{DepServiceKey: "db"}},
+				ExtendMethodRule:  v1alpha1.ComponentExtendMethodRule{MinNode: 2},
+			},
+			{
+				ServiceCname:              "mysql",
+				ComponentKey:              "db",
+				ServiceShareID:            "db",
+				DeployType:                v1alpha1.StateSingletonDeployType,
+				ShareImage:                "mysql:5.7",
+				Ports:                     []v1alpha1.ComponentPort{{ContainerPort: 3306, Protocol: "mysql"}},
+				ServiceConnectInfoMapList: []v1alpha1.ComponentEnv{{AttrName: "MYSQL_PASSWORD", AttrValue: "**None**"}},
+				ServiceVolumeMapList: v1alpha1.ComponentVolumeList{
+					{VolumeName: "data", VolumeMountPath: "/var/lib/mysql", VolumeType: v1alpha1.LocalVolumeType, VolumeCapacity: 10},
+				},
+			},
+		},
+		AppConfigGroups: []*v1alpha1.AppConfigGroup{
+			{Name: "common", ConfigItems: map[string]string{"TZ": "UTC"}, ComponentKeys: []string{"web"}},
+		},
+		IngressHTTPRoutes: []*v1alpha1.IngressHTTPRoute{
+			{Location: "/", TargetComponent: v1alpha1.TargetComponent{ComponentKey: "web", Port: 80}},
+		},
+	}
+}
+
+func TestKubernetesResources(t *testing.T) {
+	ram := testRAM()
+	k := newKubernetesResources(ram)
+	web, db := ram.Components[0], ram.Components[1]
+	if k.Name(web) != "web-server" {
+		t.Fatalf("unexpected component name %s", k.Name(web))
+	}
+
+	deploy, ok := k.Workload(web).(*apps.Deployment)
+	if !ok {
+		t.Fatalf("stateless component should be a deployment")
+	}
+	if *deploy.Spec.Replicas != 2 {
+		t.Errorf("expected 2 replicas, got %d", *deploy.Spec.Replicas)
+	}
+	container := deploy.Spec.Template.Spec.Containers[0]
+	if container.Resources.Limits.Memory().String() != "512Mi" || container.Resources.Limits.Cpu().String() != "250m" {
+		t.Errorf("unexpected resources %v", container.Resources.Limits)
+	}
+	var password string
+	for _, env := range container.Env {
+		if env.Name == "MYSQL_PASSWORD" {
+			password = env.Value
+		}
+	}
+	if password == "" || password == "**None**" {
+		t.Errorf("dependent connection info is not injected: %v", container.Env)
+	}
+	if len(container.EnvFrom) != 1 || len(k.AppConfigGroups()) != 1 {
+		t.Errorf("app config group is not injected")
+	}
+	if len(k.ConfigMaps(web)) != 1 || container.VolumeMounts[0].SubPath != "nginx.conf" {
+		t.Errorf("config file volume is not mounted")
+	}
+
+	sts, ok := k.Workload(db).(*apps.StatefulSet)
+	if !ok {
+		t.Fatalf("state component should be a statefulset")
+	}
+	if sts.Spec.ServiceName != "mysql" || len(sts.Spec.VolumeClaimTemplates) != 1 {
+		t.Errorf("unexpected statefulset spec %+v", sts.Spec)
+	}
+	if got := sts.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests.Storage().String(); got != "10Gi" {
+		t.Errorf("unexpected volume capacity %s", got)
+	}
+	if dbPassword := k.Env(db)[1].Value; dbPassword != password {
+		t.Errorf("connection info differs between provider(%s) and consumer(%s)", dbPassword, password)
+	}
+	if len(k.Services(db)) != 2 {
+		t.Errorf("expected headless and port service for state component")
+	}
+
+	ingresses := k.Ingresses()
+	if len(ingresses) != 1 || ingresses[0].Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name != "web-server-80" {
+		t.Errorf("unexpected ingresses %+v", ingresses)
+	}
+}
+
+func TestKubernetesSharedNames(t *testing.T) {
+	ram := testRAM()
+	backup := &v1alpha1.Component{
+		ServiceCname:     "mysql",
+		ComponentKey:     "backup",
+		ServiceShareID:   "backup",
+		DeployType:       v1alpha1.StatelessSingletionDeployType,
+		MntReleationList: []v1alpha1.ComponentShareVolume{{ShareServiceUUID: "db", VolumeName: "data", VolumeMountDir: "/backup"}},
+	}
+	ram.Components = append(ram.Components, backup)
+	for i := 0; i < 2; i++ {
+		k := newKubernetesResources(ram)
+		if k.Name(backup) != "mysql-2" {
+			t.Fatalf("unexpected name %s of the duplicated component", k.Name(backup))
+		}
+		volumes, _ := k.volumes(backup)
+		if len(volumes) != 1 || volumes[0].PersistentVolumeClaim.ClaimName != "mysql-data-mysql-0" {
+			t.Errorf("the volume of the state component should be shared by the claim of its first pod: %+v", volumes)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/sirupsen/logrus"
+)
+
+// ImagesFileName the oci image layout archive of all the images of the package
+const ImagesFileName = "images.tar"
+
+// imageLayout merge the image archives into one oci image layout
+type imageLayout struct {
+	// cacheDir the blob cache reused across exports, empty means no cache
+	cacheDir string
+	// cacheLimit the max size of the blob cache, 0 means no limit
+	cacheLimit int64
+}
+
+type imageLayoutKey struct{}
+
+func withImageLayout(ctx context.Context, layout *imageLayout) context.Context {
+	return context.WithValue(ctx, imageLayoutKey{}, layout)
+}
+
+func imageLayoutFromContext(ctx context.Context) *imageLayout {
+	layout, _ := ctx.Value(imageLayoutKey{}).(*imageLayout)
+	return layout
+}
+
+// imageMerger merge the component and plugin image archives of the export dir into
+// images.tar, the layers shared by the images are stored once. An archive is removed as
+// soon as its blobs are stored, so that the disk holds at most one archive besides the blobs.
+type imageMerger struct {
+	exportPath string
+	config     *imageLayout
+	layout     *image.Layout
+	logger     *logrus.Logger
+}
+
+// newImageMerger return nil if the image layout is not enabled
+func newImageMerger(ctx context.Context, tmpDir, exportPath string, logger *logrus.Logger) (*imageMerger, error) {
+	layout := imageLayoutFromContext(ctx)
+	if layout == nil {
+		return nil, nil
+	}
+	l, err := image.NewLayout(tmpDir, layout.cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("create image layout failure %s", err.Error())
+	}
+	return &imageMerger{exportPath: exportPath, config: layout, layout: l, logger: logger}, nil
+}
+
+// merge the image archive of the export dir and remove it
+func (m *imageMerger) merge(ctx context.Context, name string) error {
+	if m == nil {
+		return nil
+	}
+	file := path.Join(m.exportPath, name)
+	if _, err := os.Stat(file); err != nil {
+		return nil
+	}
+	if err := m.layout.Add(ctx, file); err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil {
+		m.logger.Warningf("remove image archive %s failure %s", file, err.Error())
+	}
+	return nil
+}
+
+// write images.tar if any image archive is merged
+func (m *imageMerger) write(ctx context.Context) error {
+	if m == nil || m.layout.Empty() {
+		return nil
+	}
+	if err := m.layout.Write(ctx, path.Join(m.exportPath, ImagesFileName)); err != nil {
+		return fmt.Errorf("write image layout failure %s", err.Error())
+	}
+	if m.config.cacheDir != "" && m.config.cacheLimit > 0 {
+		if err := image.PruneBlobCache(m.config.cacheDir, m.config.cacheLimit); err != nil {
+			m.logger.Warningf("prune blob cache %s failure %s", m.config.cacheDir, err.Error())
+		}
+	}
+	m.logger.Infof("success merge image archives")
+	return nil
+}
+
+func (m *imageMerger) close() {
+	if m != nil {
+		m.layout.Close()
+	}
+}
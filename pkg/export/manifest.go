@@ -0,0 +1,368 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
+	"github.com/goodrain/rainbond-oam/pkg/util/sign"
+	"github.com/sirupsen/logrus"
+)
+
+// ManifestFileName the name of the package manifest in the export dir
+const ManifestFileName = "package-manifest.json"
+
+// SignatureFileName the name of the detached signature of the package manifest
+const SignatureFileName = ManifestFileName + ".sig"
+
+const modulePath = "github.com/goodrain/rainbond-oam"
+
+type signerKey struct{}
+
+// withSigner return a context whose package manifest is signed by the key
+func withSigner(ctx context.Context, signer crypto.Signer) context.Context {
+	return context.WithValue(ctx, signerKey{}, signer)
+}
+
+// PackageManifest lists the files of a package and the images they contain, so that
+// a truncated or corrupted package is found before it is imported
+type PackageManifest struct {
+	ExporterVersion string         `json:"exporter_version"`
+	AppName         string         `json:"app_name,omitempty"`
+	AppVersion      string         `json:"app_version,omitempty"`
+	TemplateVersion string         `json:"template_version"`
+	Files           []PackageFile  `json:"files"`
+	Images          []PackageImage `json:"images,omitempty"`
+	// Delta the files and image layers taken from the base package if it's a delta package
+	Delta *PackageDelta `json:"delta,omitempty"`
+}
+
+// PackageFile a file of the package, the path is relative to the export dir
+type PackageFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Entries the entries of the file if it's an image archive
+	Entries []PackageEntry `json:"entries,omitempty"`
+}
+
+// PackageEntry an entry of an image archive, the path is relative to the archive
+type PackageEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// PackageImage an image saved in an image archive of the package
+type PackageImage struct {
+	Name string `json:"name"`
+	// Digest the digest of the manifest of the image, or the image id for the archives
+	// written by docker
+	Digest  string `json:"digest,omitempty"`
+	Archive string `json:"archive"`
+}
+
+// IntegrityError a file of the package that does not match the manifest
+type IntegrityError struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("package file %s is corrupted: %s", e.Path, e.Reason)
+}
+
+// WritePackageManifest write the manifest of all the files in the export dir, and its
+// signature if the context has a signing key
+func WritePackageManifest(ctx context.Context, exportPath string, ram v1alpha1.RainbondApplicationConfig, logger *logrus.Logger) error {
+	return writePackageManifest(ctx, exportPath, ram, logger, nil)
+}
+
+func writePackageManifest(ctx context.Context, exportPath string, ram v1alpha1.RainbondApplicationConfig, logger *logrus.Logger, delta *PackageDelta) error {
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePackage, Message: "write " + ManifestFileName})
+	manifest := PackageManifest{
+		ExporterVersion: exporterVersion(),
+		AppName:         ram.AppName,
+		AppVersion:      ram.AppVersion,
+		TemplateVersion: ram.TempleteVersion,
+		Delta:           delta,
+	}
+	err := filepath.Walk(exportPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(exportPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ManifestFileName || rel == SignatureFileName {
+			return nil
+		}
+		if path.Ext(rel) != ".tar" {
+			file, err := hashFile(p)
+			if err != nil {
+				return err
+			}
+			file.Path = rel
+			manifest.Files = append(manifest.Files, file)
+			return nil
+		}
+		file, err := hashArchive(p, logger)
+		if err != nil {
+			return err
+		}
+		file.Path = rel
+		manifest.Files = append(manifest.Files, file)
+		images, err := image.ReadArchiveFile(p)
+		if err != nil {
+			logger.Warningf("read images of %s failure %s", rel, err.Error())
+			return nil
+		}
+		for _, img := range images {
+			manifest.Images = append(manifest.Images, PackageImage{Name: img.Name, Digest: img.Digest.String(), Archive: rel})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("create package manifest failure %s", err.Error())
+	}
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal package manifest failure %s", err.Error())
+	}
+	if err := ioutil.WriteFile(path.Join(exportPath, ManifestFileName), body, 0644); err != nil {
+		return fmt.Errorf("write package manifest failure %s", err.Error())
+	}
+	signer, ok := ctx.Value(signerKey{}).(crypto.Signer)
+	if !ok {
+		return nil
+	}
+	signature, err := sign.Sign(signer, body)
+	if err != nil {
+		return fmt.Errorf("sign package manifest failure %s", err.Error())
+	}
+	if err := ioutil.WriteFile(path.Join(exportPath, SignatureFileName), signature, 0644); err != nil {
+		return fmt.Errorf("write package manifest signature failure %s", err.Error())
+	}
+	logger.Infof("package manifest is signed by key %s", sign.KeyID(signer.Public()))
+	return nil
+}
+
+// ReadPackageManifest read the manifest of the extracted package, it returns nil if the
+// package is exported before the manifest is introduced
+func ReadPackageManifest(dir string) (*PackageManifest, error) {
+	body, err := ioutil.ReadFile(path.Join(dir, ManifestFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest PackageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("read package manifest failure %s", err.Error())
+	}
+	return &manifest, nil
+}
+
+// Verify check the files in the dir against the manifest, it returns the IntegrityError
+// of the first file that is missing, mismatched or not in the manifest
+func (m *PackageManifest) Verify(ctx context.Context, dir string) error {
+	listed := make(map[string]bool, len(m.Files))
+	for _, file := range m.Files {
+		listed[path.Clean(file.Path)] = true
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name, err := packagePath(dir, file.Path)
+		if err != nil {
+			return err
+		}
+		actual, err := hashFile(name)
+		if os.IsNotExist(err) {
+			return &IntegrityError{Path: file.Path, Reason: "the file is missing"}
+		}
+		if err != nil {
+			return err
+		}
+		if err := file.Compare(actual.Size, actual.SHA256); err != nil {
+			return err
+		}
+	}
+	// a file added to the package after it's exported, eg. an image archive added to a signed
+	// package, must not be imported
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if listed[rel] || !IsListedFile(rel) {
+			return nil
+		}
+		return &IntegrityError{Path: rel, Reason: "the file is not in the manifest"}
+	})
+}
+
+// IsListedFile whether the file of the package is listed by the manifest, only the manifest
+// and its signature are not
+func IsListedFile(name string) bool {
+	return name != ManifestFileName && name != SignatureFileName
+}
+
+// Compare check the size and checksum of the file against the manifest
+func (f PackageFile) Compare(size int64, sha string) error {
+	if size != f.Size {
+		return &IntegrityError{Path: f.Path, Reason: fmt.Sprintf("the size is %d, expected %d", size, f.Size)}
+	}
+	if sha != f.SHA256 {
+		return &IntegrityError{Path: f.Path, Reason: fmt.Sprintf("the sha256 is %s, expected %s", sha, f.SHA256)}
+	}
+	return nil
+}
+
+func hashFile(name string) (PackageFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return PackageFile{}, err
+	}
+	defer f.Close()
+	d := NewDigester()
+	if _, err := io.Copy(d, f); err != nil {
+		return PackageFile{}, err
+	}
+	return PackageFile{Path: name, Size: d.Size(), SHA256: d.Sum()}, nil
+}
+
+// hashArchive hash the image archive and its entries in one pass, the entries are
+// left out if it's not a tar file
+func hashArchive(name string, logger *logrus.Logger) (PackageFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return PackageFile{}, err
+	}
+	defer f.Close()
+	d := NewDigester()
+	r := io.TeeReader(f, d)
+	entries, err := hashEntries(r)
+	if err != nil {
+		logger.Warningf("read entries of %s failure %s", name, err.Error())
+		entries = nil
+	}
+	// the padding after the last entry
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return PackageFile{}, err
+	}
+	return PackageFile{Path: name, Size: d.Size(), SHA256: d.Sum(), Entries: entries}, nil
+}
+
+func hashEntries(r io.Reader) ([]PackageEntry, error) {
+	var entries []PackageEntry
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		d := NewDigester()
+		if _, err := io.Copy(d, tr); err != nil {
+			return nil, err
+		}
+		entries = append(entries, PackageEntry{Path: path.Clean(hdr.Name), Size: d.Size(), SHA256: d.Sum()})
+	}
+}
+
+// Digester the size and sha256 of the bytes written, the files of the package are checked
+// against the manifest with it
+type Digester struct {
+	hash hash.Hash
+	size int64
+}
+
+// NewDigester create a sha256 digester
+func NewDigester() *Digester {
+	return &Digester{hash: sha256.New()}
+}
+
+func (d *Digester) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+// Size the number of the bytes written
+func (d *Digester) Size() int64 {
+	return d.size
+}
+
+// Sum the hex encoded sha256 of the bytes written
+func (d *Digester) Sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// exporterVersion the version of this module in the binary
+func exporterVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,115 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"archive/tar"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/sign"
+	"github.com/sirupsen/logrus"
+)
+
+func TestPackageManifest(t *testing.T) {
+	exportPath := t.TempDir()
+	if err := ioutil.WriteFile(path.Join(exportPath, "metadata.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := os.Create(path.Join(exportPath, "component-images.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(archive)
+	id := strings.Repeat("ab", 32)
+	manifest := []byte(`[{"Config":"` + id + `.json","RepoTags":["nginx:1.19"],"Layers":["layer.tar"]}]`)
+	tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest)), Typeflag: tar.TypeReg})
+	tw.Write(manifest)
+	tw.Close()
+	archive.Close()
+
+	ram := testRAM()
+	ram.TempleteVersion = "v2"
+	if err := WritePackageManifest(context.Background(), exportPath, ram, logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadPackageManifest(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TemplateVersion != "v2" || len(m.Files) != 2 || m.ExporterVersion == "" {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	if len(m.Images) != 1 || m.Images[0].Name != "nginx:1.19" || m.Images[0].Archive != "component-images.tar" ||
+		m.Images[0].Digest != "sha256:"+id {
+		t.Errorf("unexpected images %+v", m.Images)
+	}
+	if err := m.Verify(context.Background(), exportPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var integrityErr *IntegrityError
+	os.MkdirAll(path.Join(exportPath, "app"), 0755)
+	ioutil.WriteFile(path.Join(exportPath, "app", "evil.tar"), []byte("evil"), 0644)
+	if err := m.Verify(context.Background(), exportPath); !errors.As(err, &integrityErr) || integrityErr.Path != "app/evil.tar" || integrityErr.Reason != "the file is not in the manifest" {
+		t.Errorf("expected the file added to the package reported, got %v", err)
+	}
+	os.RemoveAll(path.Join(exportPath, "app"))
+	os.Remove(path.Join(exportPath, "metadata.json"))
+	if err := m.Verify(context.Background(), exportPath); !errors.As(err, &integrityErr) || integrityErr.Path != "metadata.json" {
+		t.Errorf("expected the missing file reported, got %v", err)
+	}
+	if err := os.Truncate(path.Join(exportPath, "component-images.tar"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Verify(context.Background(), exportPath); !errors.As(err, &integrityErr) || integrityErr.Path != "component-images.tar" {
+		t.Errorf("expected the truncated file reported, got %v", err)
+	}
+	if m, err := ReadPackageManifest(t.TempDir()); m != nil || err != nil {
+		t.Errorf("a package without manifest should not fail, got %v", err)
+	}
+}
+
+func TestSignPackageManifest(t *testing.T) {
+	exportPath := t.TempDir()
+	if err := ioutil.WriteFile(path.Join(exportPath, "metadata.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	ctx := withSigner(context.Background(), key)
+	if err := WritePackageManifest(ctx, exportPath, testRAM(), logrus.StandardLogger()); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := ioutil.ReadFile(path.Join(exportPath, ManifestFileName))
+	signature, err := ioutil.ReadFile(path.Join(exportPath, SignatureFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sign.Verify([]crypto.PublicKey{key.Public()}, manifest, signature); err != nil {
+		t.Errorf("the package manifest is not signed: %v", err)
+	}
+}
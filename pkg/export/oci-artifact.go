@@ -0,0 +1,141 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AppArtifactConfigMediaType the media type of the config of the app artifact, which
+	// is the metadata.json of the ram package
+	AppArtifactConfigMediaType = "application/vnd.goodrain.rainbond.app.config.v1+json"
+	// AppArtifactImagesMediaType the media type of the layer listing the images of the app
+	AppArtifactImagesMediaType = "application/vnd.goodrain.rainbond.app.images.v1+json"
+)
+
+// ArtifactRepository the repository the app artifact is pushed to, eg. registry/team/app,
+// the app version is the tag
+type ArtifactRepository struct {
+	Repository string
+	Username   string
+	Password   string
+	// Insecure the registry is served over plain http
+	Insecure bool
+}
+
+// ArtifactImage an image referenced by the app artifact, the images are not in the
+// artifact but stay in their registry
+type ArtifactImage struct {
+	// Name the component or plugin of the image
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// Digest the digest of the image when the artifact is pushed, empty if it's unknown
+	Digest digest.Digest `json:"digest,omitempty"`
+}
+
+// ociArtifactExporter push the app as an oci artifact, metadata.json is the config and
+// the images are referenced by a layer
+type ociArtifactExporter struct {
+	logger     *logrus.Logger
+	ram        v1alpha1.RainbondApplicationConfig
+	repository ArtifactRepository
+}
+
+func (o *ociArtifactExporter) Export() (*Result, error) {
+	return o.ExportWithContext(context.Background())
+}
+
+func (o *ociArtifactExporter) ExportWithContext(ctx context.Context) (*Result, error) {
+	reference := fmt.Sprintf("%s:%s", o.repository.Repository, o.ram.AppVersion)
+	o.logger.Infof("start export app %s to oci artifact %s", o.ram.AppName, reference)
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePrepare, Message: "prepare app artifact"})
+	// the components and plugins are shared with the caller, so the credentials are
+	// removed from a copy
+	var ram v1alpha1.RainbondApplicationConfig
+	body, err := json.Marshal(o.ram)
+	if err == nil {
+		err = json.Unmarshal(body, &ram)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("copy ram meta config failure %s", err.Error())
+	}
+	var images []ArtifactImage
+	for _, com := range ram.Components {
+		images = append(images, o.artifactImage(ctx, com.ServiceCname, com.ShareImage, com.AppImage))
+		// the credentials of the registry are never pushed
+		com.AppImage = v1alpha1.ImageInfo{}
+	}
+	for _, plugin := range ram.Plugins {
+		images = append(images, o.artifactImage(ctx, plugin.PluginName, plugin.ShareImage, plugin.PluginImage))
+		plugin.PluginImage = v1alpha1.ImageInfo{}
+	}
+	meta, err := json.Marshal(ram)
+	if err != nil {
+		return nil, fmt.Errorf("marshal ram meta config failure %s", err.Error())
+	}
+	imagesBody, err := json.Marshal(images)
+	if err != nil {
+		return nil, err
+	}
+	artifact := &image.Artifact{
+		ConfigMediaType: AppArtifactConfigMediaType,
+		Config:          meta,
+		Layers:          []image.ArtifactLayer{{MediaType: AppArtifactImagesMediaType, Data: imagesBody}},
+		Annotations: map[string]string{
+			ocispec.AnnotationTitle:   o.ram.AppName,
+			ocispec.AnnotationVersion: o.ram.AppVersion,
+			ocispec.AnnotationCreated: time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	if o.repository.Insecure {
+		ctx = image.WithInsecure(ctx)
+	}
+	desc, err := image.PushArtifact(ctx, reference, o.repository.Username, o.repository.Password, artifact)
+	if err != nil {
+		o.logger.Errorf("push app artifact %s failure %s", reference, err.Error())
+		return nil, err
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseDone, Message: "export success"})
+	o.logger.Infof("success export app %s to %s@%s", o.ram.AppName, reference, desc.Digest)
+	return &Result{PackagePath: reference, PackageName: reference, PackageFormat: string(OCIArtifact), ArtifactDigest: desc.Digest.String()}, nil
+}
+
+// artifactImage the image of the component or plugin, its digest is resolved so that the
+// importer can tell whether the target registry already has it
+func (o *ociArtifactExporter) artifactImage(ctx context.Context, name, source string, hub v1alpha1.ImageInfo) ArtifactImage {
+	img := ArtifactImage{Name: name, Image: source}
+	dgst, err := image.RemoteDigest(ctx, source, hub.HubUser, hub.HubPassword)
+	if err != nil {
+		o.logger.Warningf("resolve the digest of image %s failure %s", source, err.Error())
+		return img
+	}
+	img.Digest = dgst
+	return img
+}
@@ -19,10 +19,12 @@
 package export
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"path"
@@ -38,40 +40,83 @@ type ramExporter struct {
 }
 
 func (r *ramExporter) Export() (*Result, error) {
+	return r.ExportWithContext(context.Background())
+}
+
+func (r *ramExporter) ExportWithContext(ctx context.Context) (re *Result, err error) {
+	packageName := packageFileName(ctx, fmt.Sprintf("%s-%s-ram.tar.gz", r.ram.AppName, r.ram.AppVersion))
+	base := deltaBaseFromContext(ctx)
+	if base != nil {
+		packageName = packageFileName(ctx, fmt.Sprintf("%s-%s-ram-delta.tar.gz", r.ram.AppName, r.ram.AppVersion))
+	}
+	defer func() {
+		cleanupCanceledExport(ctx, r.logger, err, r.exportPath, path.Join(r.homePath, packageName))
+	}()
 	r.logger.Infof("start export app %s to ram app spec", r.ram.AppName)
 	// Delete the old application group directory and then regenerate the application package
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePrepare, Message: "prepare export dir"})
 	if err := PrepareExportDir(r.exportPath); err != nil {
 		r.logger.Errorf("prepare export dir failure %s", err.Error())
 		return nil, err
 	}
 	r.logger.Infof("success prepare export dir")
 	if r.mode == "offline" {
+		merger, err := newImageMerger(ctx, r.homePath, r.exportPath, r.logger)
+		if err != nil {
+			r.logger.Error(err)
+			return nil, err
+		}
+		defer merger.close()
 		// Save components attachments
 		if len(r.ram.Components) > 0 {
-			if err := SaveComponents(r.ram, r.imageClient, r.exportPath, r.logger, []string{}); err != nil {
+			if err := SaveComponentsWithContext(ctx, r.ram, r.imageClient, r.exportPath, r.logger, []string{}); err != nil {
 				return nil, err
 			}
 			r.logger.Infof("success save components")
+			if err := merger.merge(ctx, "component-images.tar"); err != nil {
+				r.logger.Error(err)
+				return nil, err
+			}
 		}
 		if len(r.ram.Plugins) > 0 {
-			if err := SavePlugins(r.ram, r.imageClient, r.exportPath, r.logger); err != nil {
+			if err := SavePluginsWithContext(ctx, r.ram, r.imageClient, r.exportPath, r.logger); err != nil {
 				return nil, err
 			}
 			r.logger.Infof("success save plugins")
+			if err := merger.merge(ctx, "plugin-images.tar"); err != nil {
+				r.logger.Error(err)
+				return nil, err
+			}
+		}
+		if err := merger.write(ctx); err != nil {
+			r.logger.Error(err)
+			return nil, err
 		}
 	}
 	if err := r.writeMetaFile(); err != nil {
 		return nil, err
 	}
 	r.logger.Infof("success write ram spec file")
+	var delta *PackageDelta
+	if base != nil {
+		if delta, err = makeDelta(ctx, r.exportPath, base); err != nil {
+			r.logger.Error(err)
+			return nil, err
+		}
+		r.logger.Infof("the delta of version %s takes %d files and %d image layers from it", delta.BaseAppVersion, len(delta.Files), len(delta.Entries))
+	}
+	if err := writePackageManifest(ctx, r.exportPath, r.ram, r.logger, delta); err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
 	// packaging
-	packageName := fmt.Sprintf("%s-%s-ram.tar.gz", r.ram.AppName, r.ram.AppVersion)
-	name, err := Packaging(packageName, r.homePath, r.exportPath)
+	name, err := PackagingWithContext(ctx, packageName, r.homePath, r.exportPath)
 	if err != nil {
 		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
 		r.logger.Error(err)
 		return nil, err
 	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseDone, Message: "export success"})
 	r.logger.Infof("success export app " + r.ram.AppName)
 	return &Result{PackagePath: path.Join(r.homePath, name), PackageName: name}, nil
 }
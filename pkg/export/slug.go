@@ -19,11 +19,13 @@
 package export
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
 	"github.com/goodrain/rainbond-oam/pkg/util"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
@@ -43,8 +45,17 @@ type slugExporter struct {
 }
 
 func (s *slugExporter) Export() (*Result, error) {
+	return s.ExportWithContext(context.Background())
+}
+
+func (s *slugExporter) ExportWithContext(ctx context.Context) (re *Result, err error) {
+	packageName := packageFileName(ctx, fmt.Sprintf("%s-%s-slug.tar.gz", s.ram.AppName, s.ram.AppVersion))
+	defer func() {
+		cleanupCanceledExport(ctx, s.logger, err, s.exportPath, path.Join(s.homePath, packageName))
+	}()
 	s.logger.Infof("start export app %s to ram app spec", s.ram.AppName)
 	// Delete the old application group directory and then regenerate the application package
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePrepare, Message: "prepare export dir"})
 	if err := PrepareExportDir(s.exportPath); err != nil {
 		s.logger.Errorf("prepare export dir failure %s", err.Error())
 		return nil, err
@@ -52,7 +63,7 @@ func (s *slugExporter) Export() (*Result, error) {
 	s.logger.Infof("success prepare export dir")
 	if s.mode == "offline" {
 		// Save components attachments
-		if err := SaveComponents(s.ram, s.imageClient, s.exportPath, s.logger, []string{}); err != nil {
+		if err := SaveComponentsWithContext(ctx, s.ram, s.imageClient, s.exportPath, s.logger, []string{}); err != nil {
 			return nil, err
 		}
 		s.logger.Infof("success save components")
@@ -60,7 +71,7 @@ func (s *slugExporter) Export() (*Result, error) {
 	// UnTar component-images
 	ciTarPath := fmt.Sprintf("%s/component-images.tar", s.exportPath)
 	ciFilePath := fmt.Sprintf("%s/component-images", s.exportPath)
-	err := os.Mkdir(ciFilePath, 0755)
+	err = os.Mkdir(ciFilePath, 0755)
 	if err != nil {
 		s.logger.Error("mkdir component-image error", err)
 	}
@@ -138,14 +149,18 @@ func (s *slugExporter) Export() (*Result, error) {
 	if err := s.writeAppScript(s.exportPath, s.ram.AppName); err != nil {
 		return nil, err
 	}
+	if err := WritePackageManifest(ctx, s.exportPath, s.ram, s.logger); err != nil {
+		s.logger.Error(err)
+		return nil, err
+	}
 	// packaging
-	packageName := fmt.Sprintf("%s-%s-slug.tar.gz", s.ram.AppName, s.ram.AppVersion)
-	name, err := Packaging(packageName, s.homePath, s.exportPath)
+	name, err := PackagingWithContext(ctx, packageName, s.homePath, s.exportPath)
 	if err != nil {
 		err = fmt.Errorf("Failed to package app %s: %s ", packageName, err.Error())
 		s.logger.Error(err)
 		return nil, err
 	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseDone, Message: "export success"})
 	s.logger.Infof("success export app " + s.ram.AppName)
 	return &Result{PackagePath: path.Join(s.homePath, name), PackageName: name}, nil
 }
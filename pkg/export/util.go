@@ -19,14 +19,17 @@
 package export
 
 import (
+	"context"
 	"fmt"
+	"github.com/containerd/containerd/platforms"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
 	"github.com/mozillazg/go-pinyin"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"regexp"
 	"strconv"
@@ -113,6 +116,20 @@ var memoryLabels = map[int]string{
 	65536: "64xlarge",
 }
 
+//cleanupCanceledExport remove the half-written export dir and package when the export
+//fails because the context is canceled
+func cleanupCanceledExport(ctx context.Context, logger *logrus.Logger, err error, paths ...string) {
+	if err == nil || ctx.Err() == nil {
+		return
+	}
+	for _, p := range paths {
+		if rerr := os.RemoveAll(p); rerr != nil {
+			logger.Warningf("remove canceled export %s failure %s", p, rerr.Error())
+		}
+	}
+	logger.Infof("export is canceled, cleaned up %v", paths)
+}
+
 //PrepareExportDir -
 func PrepareExportDir(exportPath string) error {
 	os.RemoveAll(exportPath)
@@ -127,28 +144,137 @@ func exportComponentConfigFile(serviceDir string, v v1alpha1.ComponentVolume) er
 	return ioutil.WriteFile(filename, []byte(v.FileConent), 0644)
 }
 
+//componentPlatforms the platforms to export the component image for, the platforms requested
+//for the whole package by image.WithPlatforms take precedence over the arch of the component
+func componentPlatforms(ctx context.Context, component *v1alpha1.Component, logger *logrus.Logger) []string {
+	if ps := image.Platforms(ctx); len(ps) > 0 {
+		return ps
+	}
+	ps, err := image.ParsePlatforms(component.Arch)
+	if err != nil {
+		logger.Warningf("ignore the arch of component %s: %s", component.ServiceCname, err.Error())
+		return nil
+	}
+	return ps
+}
+
+//platformSet the platforms of all the images saved in one archive
+type platformSet []string
+
+func (p *platformSet) add(ps ...string) {
+	if len(ps) == 0 {
+		ps = []string{platforms.DefaultString()}
+	}
+	for _, platform := range ps {
+		var exist bool
+		for _, s := range *p {
+			exist = exist || s == platform
+		}
+		if !exist {
+			*p = append(*p, platform)
+		}
+	}
+}
+
+//context the context to save the images with
+func (p platformSet) context(ctx context.Context) context.Context {
+	if len(p) == 0 {
+		return ctx
+	}
+	return image.WithPlatforms(ctx, p...)
+}
+
+//imagePull an image to pull for the components or plugins that share it
+type imagePull struct {
+	component, user, password string
+	explicit                  bool
+}
+
+//imagePulls the images to pull, an image shared by several components is pulled once.
+//The images are saved in one archive for the platforms of all of them, so every image is
+//pulled for all of them too, otherwise the save walks manifests that are never fetched.
+type imagePulls struct {
+	order []string
+	pulls map[string]*imagePull
+	// the platforms of all the images, the images are saved with them
+	platforms platformSet
+}
+
+func (p *imagePulls) add(component, name, user, password string, ps ...string) {
+	if p.pulls == nil {
+		p.pulls = make(map[string]*imagePull)
+	}
+	pull, ok := p.pulls[name]
+	if !ok {
+		pull = &imagePull{component: component, user: user, password: password}
+		p.pulls[name] = pull
+		p.order = append(p.order, name)
+	}
+	pull.explicit = pull.explicit || len(ps) > 0
+	p.platforms.add(ps...)
+}
+
+//images the names of the images to save
+func (p *imagePulls) images() []string {
+	return p.order
+}
+
+//pull pulls all the images in the pool of the context
+func (p *imagePulls) pull(ctx context.Context, imageClient image.Client, logger *logrus.Logger) error {
+	// the default platform is pulled as usual if no image requests a platform
+	var explicit bool
+	for _, pull := range p.pulls {
+		explicit = explicit || pull.explicit
+	}
+	var jobs []image.Job
+	for _, name := range p.order {
+		name, pull := name, p.pulls[name]
+		pullCtx := progress.WithComponent(ctx, pull.component)
+		if explicit {
+			pullCtx = p.platforms.context(pullCtx)
+		}
+		jobs = append(jobs, image.Job{Image: name, Run: func(context.Context) error {
+			if _, err := image.ImagePullWithContext(pullCtx, imageClient, name, pull.user, pull.password, 30); err != nil {
+				return err
+			}
+			logger.Infof("pull %s image %s success", pull.component, name)
+			return nil
+		}})
+	}
+	if err := image.PoolFromContext(ctx).Run(ctx, jobs...); err != nil {
+		logger.Errorf("pull images failure %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+//SaveComponents pull and save the component images and the dependent images
 func SaveComponents(ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, exportPath string, logger *logrus.Logger, dependentImages []string) error {
-	var componentImageNames []string
+	return SaveComponentsWithContext(context.Background(), ram, imageClient, exportPath, logger, dependentImages)
+}
+
+//SaveComponentsWithContext stops pulling and saving the images when the context is canceled
+func SaveComponentsWithContext(ctx context.Context, ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, exportPath string, logger *logrus.Logger, dependentImages []string) error {
+	var pulls imagePulls
 	for _, component := range ram.Components {
-		componentName := unicode2zh(component.ServiceCname)
 		if component.ShareImage != "" {
 			// app is image type
-			_, err := imageClient.ImagePull(component.ShareImage, component.AppImage.HubUser, component.AppImage.HubPassword, 30)
-			if err != nil {
-				return err
-			}
-			logger.Infof("pull component %s image success", componentName)
-			componentImageNames = append(componentImageNames, component.ShareImage)
+			ps := componentPlatforms(ctx, component, logger)
+			pulls.add(unicode2zh(component.ServiceCname), component.ShareImage, component.AppImage.HubUser, component.AppImage.HubPassword, ps...)
 		}
 	}
+	if err := pulls.pull(ctx, imageClient, logger); err != nil {
+		return err
+	}
 	start := time.Now()
+	componentImageNames := pulls.images()
 	for _, dependentImage := range dependentImages {
 		if dependentImage == "" {
 			continue
 		}
 		componentImageNames = append(componentImageNames, dependentImage)
 	}
-	err := imageClient.ImageSave(fmt.Sprintf("%s/component-images.tar", exportPath), componentImageNames)
+	err := image.ImageSaveWithContext(pulls.platforms.context(ctx), imageClient, fmt.Sprintf("%s/component-images.tar", exportPath), componentImageNames)
 	if err != nil {
 		logrus.Errorf("Failed to save image(%v) : %s", componentImageNames, err)
 		return err
@@ -157,21 +283,26 @@ func SaveComponents(ram v1alpha1.RainbondApplicationConfig, imageClient image.Cl
 	return nil
 }
 
+//SavePlugins pull and save the plugin images
 func SavePlugins(ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, exportPath string, logger *logrus.Logger) error {
-	var pluginImageNames []string
+	return SavePluginsWithContext(context.Background(), ram, imageClient, exportPath, logger)
+}
+
+//SavePluginsWithContext stops pulling and saving the images when the context is canceled
+func SavePluginsWithContext(ctx context.Context, ram v1alpha1.RainbondApplicationConfig, imageClient image.Client, exportPath string, logger *logrus.Logger) error {
+	var pulls imagePulls
 	for _, plugin := range ram.Plugins {
 		if plugin.ShareImage != "" {
 			// app is image type
-			_, err := imageClient.ImagePull(plugin.ShareImage, plugin.PluginImage.HubUser, plugin.PluginImage.HubPassword, 30)
-			if err != nil {
-				return err
-			}
-			logger.Infof("pull plugin %s image success", plugin.PluginName)
-			pluginImageNames = append(pluginImageNames, plugin.ShareImage)
+			pulls.add(plugin.PluginName, plugin.ShareImage, plugin.PluginImage.HubUser, plugin.PluginImage.HubPassword)
 		}
 	}
+	if err := pulls.pull(ctx, imageClient, logger); err != nil {
+		return err
+	}
 	start := time.Now()
-	err := imageClient.ImageSave(fmt.Sprintf("%s/plugin-images.tar", exportPath), pluginImageNames)
+	pluginImageNames := pulls.images()
+	err := image.ImageSaveWithContext(ctx, imageClient, fmt.Sprintf("%s/plugin-images.tar", exportPath), pluginImageNames)
 	if err != nil {
 		logrus.Errorf("Failed to save image(%v) : %s", pluginImageNames, err)
 		return err
@@ -180,11 +311,60 @@ func SavePlugins(ram v1alpha1.RainbondApplicationConfig, imageClient image.Clien
 	return nil
 }
 
+//packaging how the export dir is packaged
+type packaging struct {
+	compression archive.Compression
+	concurrency int
+	// stream remove the files of the export dir as soon as they are packaged, the image
+	// archives are saved to the export dir first
+	stream bool
+}
+
+type packagingKey struct{}
+
+func withPackaging(ctx context.Context, p *packaging) context.Context {
+	return context.WithValue(ctx, packagingKey{}, p)
+}
+
+func packagingFromContext(ctx context.Context) *packaging {
+	if p, ok := ctx.Value(packagingKey{}).(*packaging); ok {
+		return p
+	}
+	return &packaging{compression: archive.Gzip}
+}
+
+//Packaging create the gzip package homePath/packageName that contains the export dir
 func Packaging(packageName, homePath, exportPath string) (string, error) {
-	cmd := exec.Command("tar", "-czf", path.Join(homePath, packageName), path.Base(exportPath))
-	cmd.Dir = homePath
-	if err := cmd.Run(); err != nil {
+	return PackagingWithContext(context.Background(), packageName, homePath, exportPath)
+}
+
+//packageFileName replace the .tar.gz extension of packageName by the extension of the
+//compression of the context
+func packageFileName(ctx context.Context, packageName string) string {
+	extension := packagingFromContext(ctx).compression.Extension()
+	if strings.HasSuffix(packageName, extension) {
+		return packageName
+	}
+	return strings.TrimSuffix(packageName, ".tar.gz") + extension
+}
+
+//PackagingWithContext create the package homePath/packageName that contains the export dir.
+//The compression is the one of the context, the .tar.gz extension of packageName is replaced
+//by the extension of the compression, and the name of the package is returned.
+func PackagingWithContext(ctx context.Context, packageName, homePath, exportPath string) (string, error) {
+	p := packagingFromContext(ctx)
+	packageName = packageFileName(ctx, packageName)
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePackage, Message: "packaging " + packageName})
+	opts := archive.PackOptions{Compression: p.compression, Concurrency: p.concurrency, RemoveFiles: p.stream}
+	if err := archive.CreateTarball(ctx, path.Join(homePath, packageName), exportPath, opts); err != nil {
 		return "", err
 	}
+	if p.stream {
+		// only the empty dirs are left
+		os.RemoveAll(exportPath)
+	}
+	if info, err := os.Stat(path.Join(homePath, packageName)); err == nil {
+		progress.Publish(ctx, progress.Event{Phase: progress.PhasePackage, Current: info.Size(), Total: info.Size(), Message: "packaged " + packageName})
+	}
 	return packageName, nil
 }
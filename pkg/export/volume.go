@@ -0,0 +1,255 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
+)
+
+// VolumeIndexSuffix the suffix of the index file of a split package
+const VolumeIndexSuffix = ".volumes.json"
+
+// volumePattern the suffix of the parts of a split package, eg. .001
+var volumePattern = regexp.MustCompile(`\.[0-9]{3,}$`)
+
+// VolumeIndex the parts of a package split for transfer, the paths of the parts are
+// relative to the dir of the index
+type VolumeIndex struct {
+	PackageName string        `json:"package_name"`
+	Size        int64         `json:"size"`
+	SHA256      string        `json:"sha256"`
+	VolumeSize  int64         `json:"volume_size"`
+	Volumes     []PackageFile `json:"volumes"`
+}
+
+// SplitPackage split the package into parts of volumeSize bytes named <package>.001,
+// <package>.002... and write their index <package>.volumes.json next to them. The package
+// is removed once it's split. It returns the path of the index.
+func SplitPackage(ctx context.Context, packagePath string, volumeSize int64) (indexPath string, err error) {
+	if volumeSize <= 0 {
+		return "", fmt.Errorf("invalid volume size %d", volumeSize)
+	}
+	f, err := os.Open(packagePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	index := VolumeIndex{PackageName: path.Base(packagePath), VolumeSize: volumeSize}
+	defer func() {
+		if err != nil {
+			for _, volume := range index.Volumes {
+				os.Remove(path.Join(path.Dir(packagePath), volume.Path))
+			}
+		}
+	}()
+	total := NewDigester()
+	r := io.TeeReader(f, total)
+	for i := 1; total.Size() < info.Size() || i == 1; i++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		name := fmt.Sprintf("%s.%03d", index.PackageName, i)
+		progress.Publish(ctx, progress.Event{Phase: progress.PhasePackage, Current: total.Size(), Total: info.Size(), Message: "split " + name})
+		volume, err := writeVolume(path.Join(path.Dir(packagePath), name), io.LimitReader(r, volumeSize))
+		if err != nil {
+			return "", err
+		}
+		volume.Path = name
+		index.Volumes = append(index.Volumes, volume)
+	}
+	index.Size, index.SHA256 = total.Size(), total.Sum()
+	body, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	indexPath = packagePath + VolumeIndexSuffix
+	if err := ioutil.WriteFile(indexPath, body, 0644); err != nil {
+		return "", err
+	}
+	f.Close()
+	return indexPath, os.Remove(packagePath)
+}
+
+func writeVolume(name string, r io.Reader) (PackageFile, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return PackageFile{}, err
+	}
+	defer f.Close()
+	d := NewDigester()
+	if _, err := io.Copy(io.MultiWriter(f, d), r); err != nil {
+		return PackageFile{}, err
+	}
+	return PackageFile{Size: d.Size(), SHA256: d.Sum()}, f.Close()
+}
+
+// IsVolumeFile whether the file is the index or a part of a split package, a part is
+// recognized only if its index is next to it
+func IsVolumeFile(file string) bool {
+	if strings.HasSuffix(file, VolumeIndexSuffix) {
+		return true
+	}
+	if !volumePattern.MatchString(file) {
+		return false
+	}
+	_, err := os.Stat(volumePattern.ReplaceAllString(file, "") + VolumeIndexSuffix)
+	return err == nil
+}
+
+// ReadVolumeIndex read the index of the split package, file is the index or any part
+// of the package. It returns the index and its path.
+func ReadVolumeIndex(file string) (*VolumeIndex, string, error) {
+	indexPath := file
+	if !strings.HasSuffix(file, VolumeIndexSuffix) {
+		indexPath = volumePattern.ReplaceAllString(file, "") + VolumeIndexSuffix
+	}
+	body, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("read the volume index of %s failure %s", file, err.Error())
+	}
+	var index VolumeIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, "", fmt.Errorf("read the volume index %s failure %s", indexPath, err.Error())
+	}
+	if len(index.Volumes) == 0 {
+		return nil, "", fmt.Errorf("the volume index %s has no volume", indexPath)
+	}
+	for _, volume := range index.Volumes {
+		if _, err := packagePath(filepath.Dir(indexPath), volume.Path); err != nil {
+			return nil, "", err
+		}
+	}
+	return &index, indexPath, nil
+}
+
+// OpenVolumes return the stream of the package reassembled from its parts, file is the
+// index or any part of the package. The checksum of every part is verified when it's
+// read to the end, the read fails with an IntegrityError if it does not match.
+func OpenVolumes(ctx context.Context, file string) (io.ReadCloser, *VolumeIndex, error) {
+	index, indexPath, err := ReadVolumeIndex(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &volumeReader{ctx: ctx, dir: filepath.Dir(indexPath), index: index, total: NewDigester()}, index, nil
+}
+
+// JoinVolumes reassemble the split package into dst, file is the index or any part of the
+// package
+func JoinVolumes(ctx context.Context, file, dst string) (err error) {
+	r, _, err := OpenVolumes(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// volumeReader reads the parts of a split package one after another
+type volumeReader struct {
+	ctx   context.Context
+	dir   string
+	index *VolumeIndex
+	// next the index of the next part
+	next    int
+	current *os.File
+	digest  *Digester
+	total   *Digester
+}
+
+func (v *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if err := v.ctx.Err(); err != nil {
+			return 0, err
+		}
+		if v.current == nil {
+			if v.next >= len(v.index.Volumes) {
+				return 0, v.verifyPackage()
+			}
+			name, err := packagePath(v.dir, v.index.Volumes[v.next].Path)
+			if err != nil {
+				return 0, err
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				return 0, fmt.Errorf("open volume %d of %s failure %s", v.next+1, v.index.PackageName, err.Error())
+			}
+			v.current, v.digest = f, NewDigester()
+			v.next++
+		}
+		n, err := v.current.Read(p)
+		v.digest.Write(p[:n])
+		v.total.Write(p[:n])
+		if err == io.EOF {
+			v.current.Close()
+			v.current = nil
+			volume := v.index.Volumes[v.next-1]
+			if err := volume.Compare(v.digest.Size(), v.digest.Sum()); err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (v *volumeReader) verifyPackage() error {
+	whole := PackageFile{Path: v.index.PackageName, Size: v.index.Size, SHA256: v.index.SHA256}
+	if err := whole.Compare(v.total.Size(), v.total.Sum()); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (v *volumeReader) Close() error {
+	if v.current != nil {
+		return v.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSplitPackage(t *testing.T) {
+	home := t.TempDir()
+	packagePath := path.Join(home, "demo-1.0-ram.tar.gz")
+	body := bytes.Repeat([]byte("package"), 100)
+	if err := ioutil.WriteFile(packagePath, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+	indexPath, err := SplitPackage(context.Background(), packagePath, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, _, err := ReadVolumeIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Volumes) != 3 || index.Volumes[2].Size != 100 || index.Size != int64(len(body)) {
+		t.Fatalf("unexpected volumes %+v", index)
+	}
+	if _, err := os.Stat(packagePath); !os.IsNotExist(err) {
+		t.Errorf("the split package should be removed")
+	}
+
+	first := path.Join(home, "demo-1.0-ram.tar.gz.001")
+	if !IsVolumeFile(first) || IsVolumeFile(path.Join(home, "demo-1.0.001")) {
+		t.Errorf("only the parts with an index are volumes")
+	}
+	joined := path.Join(t.TempDir(), "joined.tar.gz")
+	if err := JoinVolumes(context.Background(), first, joined); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(joined); !bytes.Equal(got, body) {
+		t.Errorf("the package is not reassembled")
+	}
+
+	// a part is damaged in transfer
+	if err := ioutil.WriteFile(path.Join(home, "demo-1.0-ram.tar.gz.002"), bytes.Repeat([]byte("x"), 300), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var integrityErr *IntegrityError
+	if err := JoinVolumes(context.Background(), indexPath, joined); !errors.As(err, &integrityErr) || integrityErr.Path != "demo-1.0-ram.tar.gz.002" {
+		t.Errorf("expected the damaged part reported, got %v", err)
+	}
+	if _, err := os.Stat(joined); !os.IsNotExist(err) {
+		t.Errorf("the half joined package should be removed")
+	}
+
+	// the parts must be next to the index
+	for _, name := range []string{"/etc/passwd", "../demo-1.0-ram.tar.gz.001"} {
+		index.Volumes[0].Path = name
+		body, _ := json.Marshal(index)
+		ioutil.WriteFile(indexPath, body, 0644)
+		if _, _, err := ReadVolumeIndex(indexPath); !errors.As(err, &integrityErr) || integrityErr.Path != path.Clean(name) {
+			t.Errorf("expected the part %s outside of the package dir rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestExportVolumes(t *testing.T) {
+	homePath := t.TempDir()
+	exporter := &optionExporter{AppLocalExport: &ramExporter{
+		logger:      logrus.StandardLogger(),
+		ram:         testRAM(),
+		imageClient: &fakeImageClient{},
+		mode:        "offline",
+		homePath:    homePath,
+		exportPath:  path.Join(homePath, "demo-1.0-ram"),
+	}}
+	WithVolumeSize(1024)(&exporter.options)
+	re, err := exporter.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re.PackagePath != re.VolumeIndex || len(re.Volumes) == 0 {
+		t.Fatalf("expected the package path is the volume index, got %+v", re)
+	}
+	if _, err := os.Stat(re.PackagePath); err != nil {
+		t.Errorf("the package path should exist: %v", err)
+	}
+}
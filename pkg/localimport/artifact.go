@@ -0,0 +1,197 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+	"github.com/goodrain/rainbond-oam/pkg/export"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/docker"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
+)
+
+// ArtifactImport import the app pushed to a registry as an oci artifact by the
+// oci-artifact export format. The importer created by New implements it.
+//
+// The artifact holds the metadata and the references of the images only, the images are
+// pulled from their registries, so they must be reachable from the importing site. App
+// artifacts are not signed, they are rejected if the signature verification is required.
+type ArtifactImport interface {
+	// ImportArtifact pull the app artifact of the reference, eg. registry/team/app:1.0, and
+	// copy its images to the hub. The username and password are the credentials of the
+	// registry of the artifact, they are used to pull the images of that registry only, the
+	// images of the other registries are pulled with the credentials of WithRegistryCredentials.
+	ImportArtifact(ctx context.Context, reference, username, password string, hubInfo v1alpha1.ImageInfo) (*v1alpha1.RainbondApplicationConfig, error)
+}
+
+// RegistryCredential the credential of a registry the images of app artifacts are pulled from
+type RegistryCredential struct {
+	// Registry the host of the registry, eg. docker.io
+	Registry string `json:"registry"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// WithRegistryCredentials pull the images of app artifacts with the credentials of their
+// registries, the images of the registries without credentials are pulled anonymously
+func WithRegistryCredentials(credentials ...RegistryCredential) Option {
+	return func(r *ramImport) {
+		r.credentials = append(r.credentials, credentials...)
+	}
+}
+
+func (r *ramImport) ImportArtifact(ctx context.Context, artifactRef, username, password string, hubInfo v1alpha1.ImageInfo) (*v1alpha1.RainbondApplicationConfig, error) {
+	if hubInfo.HubURL == "" && len(r.rewriteRules) == 0 {
+		return nil, fmt.Errorf("must define hub url")
+	}
+	if r.verifySignature() {
+		if err := r.enforceSignature(&SignatureError{Reason: "app artifacts are not signed"}); err != nil {
+			return nil, err
+		}
+	}
+	rewriter, err := docker.NewRewriter(hubInfo, r.rewriteRules...)
+	if err != nil {
+		return nil, err
+	}
+	r.logger.Infof("start import app by app artifact %s", artifactRef)
+	artifact, _, err := image.PullArtifact(ctx, artifactRef, username, password, export.AppArtifactConfigMediaType)
+	if err != nil {
+		r.logger.Errorf("pull app artifact %s failure %s", artifactRef, err.Error())
+		return nil, err
+	}
+	var ram v1alpha1.RainbondApplicationConfig
+	if err := json.Unmarshal(artifact.Config, &ram); err != nil {
+		return nil, fmt.Errorf("Failed to read meta file : %v", err)
+	}
+	digests := make(map[string]export.ArtifactImage)
+	for _, layer := range artifact.Layers {
+		if layer.MediaType != export.AppArtifactImagesMediaType {
+			continue
+		}
+		var images []export.ArtifactImage
+		if err := json.Unmarshal(layer.Data, &images); err != nil {
+			return nil, fmt.Errorf("read the images of app artifact %s failure %s", artifactRef, err.Error())
+		}
+		for _, img := range images {
+			digests[img.Image] = img
+		}
+	}
+	// the components and plugins sharing an image copy it once
+	var (
+		mu   sync.Mutex
+		jobs []image.Job
+		done = make(map[string]republished)
+	)
+	addJob := func(name, source string) {
+		jobs = append(jobs, image.Job{Image: source, Run: func(ctx context.Context) error {
+			ctx = progress.WithComponent(ctx, name)
+			user, pass := r.pullCredential(artifactRef, username, password, source)
+			copied, err := r.copyArtifactImage(ctx, rewriter, digests[source], source, user, pass)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			done[source] = copied
+			mu.Unlock()
+			return nil
+		}})
+	}
+	for _, com := range ram.Components {
+		addJob(com.ServiceCname, com.ShareImage)
+	}
+	for _, plugin := range ram.Plugins {
+		addJob(plugin.PluginName, plugin.ShareImage)
+	}
+	if r.pool != nil {
+		ctx = image.WithPool(ctx, *r.pool)
+	}
+	if err := image.PoolFromContext(ctx).Run(ctx, jobs...); err != nil {
+		r.logger.Errorf("copy images failure %s", err.Error())
+		return nil, err
+	}
+	assignImages(&ram, done)
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseDone, Message: "import success"})
+	return &ram, nil
+}
+
+// copyArtifactImage copy the image referenced by the app artifact to the hub, it's skipped
+// if the image is already there
+func (r *ramImport) copyArtifactImage(ctx context.Context, rewriter *docker.Rewriter, referenced export.ArtifactImage, source, username, password string) (republished, error) {
+	newImageName, target, err := rewriter.Rewrite(source)
+	if err != nil {
+		r.logger.Errorf("parse image failure %s", err.Error())
+		return republished{}, err
+	}
+	if normalizeName(newImageName) == normalizeName(source) {
+		return republished{name: newImageName, target: target}, nil
+	}
+	if referenced.Digest != "" {
+		targetCtx := ctx
+		if target.Insecure {
+			targetCtx = image.WithInsecure(ctx)
+		}
+		present, err := image.RemoteHasImage(targetCtx, newImageName, target.HubUser, target.HubPassword, referenced.Digest)
+		if err != nil {
+			r.logger.Warningf("check image %s in the target registry failure %s", newImageName, err.Error())
+		}
+		if present {
+			r.logger.Infof("skip the image %s that the target registry already has", newImageName)
+			return republished{name: newImageName, target: target}, nil
+		}
+	}
+	r.logger.Infof("start pull image %s", source)
+	if _, err := image.ImagePullWithContext(ctx, r.imageClient, source, username, password, 20); err != nil {
+		r.logger.Errorf("pull image %s failure %s", source, err.Error())
+		return republished{}, err
+	}
+	newImageName, target, err = r.republish(ctx, rewriter, source)
+	if err != nil {
+		return republished{}, err
+	}
+	return republished{name: newImageName, target: target}, nil
+}
+
+// pullCredential the credential to pull the image referenced by the app artifact, the
+// credential of the artifact registry is used for the images of that registry only
+func (r *ramImport) pullCredential(artifactRef, username, password, source string) (string, string) {
+	registry := registryOf(source)
+	for _, c := range r.credentials {
+		if c.Registry == registry {
+			return c.Username, c.Password
+		}
+	}
+	if registry != "" && registry == registryOf(artifactRef) {
+		return username, password
+	}
+	return "", ""
+}
+
+func registryOf(name string) string {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return ""
+	}
+	return reference.Domain(named)
+}
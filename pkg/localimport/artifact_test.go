@@ -0,0 +1,196 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/export"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// testRegistry a minimal in-process stand-in of the distribution registry api
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[digest.Digest][]byte
+	manifests map[string][]byte
+}
+
+func (t *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := req.URL.Path
+	serve := func(mediaType string, body []byte, ok bool) {
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(body).String())
+		if req.Method != http.MethodHead {
+			w.Write(body)
+		}
+	}
+	switch {
+	case p == "/v2/":
+	case strings.Contains(p, "/manifests/"):
+		i := strings.Index(p, "/manifests/")
+		repository, ref := p[len("/v2/"):i], p[i+len("/manifests/"):]
+		if req.Method == http.MethodPut {
+			body, _ := ioutil.ReadAll(req.Body)
+			t.manifests[repository+"@"+ref] = body
+			t.manifests[repository+"@"+digest.FromBytes(body).String()] = body
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(body).String())
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		body, ok := t.manifests[repository+"@"+ref]
+		var m struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(body, &m)
+		serve(m.MediaType, body, ok)
+	case strings.Contains(p, "/blobs/uploads/"):
+		if req.Method == http.MethodPost {
+			w.Header().Set("Location", p+"upload")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		t.blobs[digest.Digest(req.URL.Query().Get("digest"))] = body
+		w.Header().Set("Docker-Content-Digest", req.URL.Query().Get("digest"))
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/blobs/"):
+		body, ok := t.blobs[digest.Digest(path.Base(p))]
+		serve("application/octet-stream", body, ok)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// putImage put the manifest of an image, it returns the digest of the manifest
+func (t *testRegistry) putImage(repository, tag string) digest.Digest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	config := []byte(`{"os":"linux","architecture":"amd64"}`)
+	t.blobs[digest.FromBytes(config)] = config
+	manifest, _ := json.Marshal(ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:    []ocispec.Descriptor{},
+	})
+	t.manifests[repository+"@"+tag] = manifest
+	t.manifests[repository+"@"+digest.FromBytes(manifest).String()] = manifest
+	return digest.FromBytes(manifest)
+}
+
+func TestImportArtifact(t *testing.T) {
+	registry := &testRegistry{blobs: make(map[digest.Digest][]byte), manifests: make(map[string][]byte)}
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+	registry.putImage("team/web", "1.0")
+	registry.putImage("team/api", "1.0")
+	// the hub already has the web image
+	registry.putImage("demo/web", "1.0")
+
+	ram := v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{
+			{ServiceCname: "web", ComponentKey: "web", ServiceShareID: "web", ShareImage: host + "/team/web:1.0", AppImage: v1alpha1.ImageInfo{HubUser: "admin", HubPassword: "secret"}},
+			{ServiceCname: "api", ComponentKey: "api", ServiceShareID: "api", ShareImage: host + "/team/api:1.0"},
+		},
+	}
+	exporter, err := export.New(export.OCIArtifact, t.TempDir(), ram, nil, nil, logrus.StandardLogger(),
+		export.WithArtifactRepository(export.ArtifactRepository{Repository: host + "/apps/demo"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := exporter.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PackagePath != host+"/apps/demo:1.0" || result.ArtifactDigest == "" {
+		t.Errorf("unexpected export result %+v", result)
+	}
+	if strings.Contains(string(registry.blobs[digest.Digest(registry.manifestConfig(t, "apps/demo@1.0"))]), "secret") {
+		t.Errorf("the credentials of the images should not be pushed")
+	}
+	if ram.Components[0].AppImage.HubPassword != "secret" {
+		t.Errorf("the credentials of the caller's app should be kept, got %+v", ram.Components[0].AppImage)
+	}
+
+	client := &fakeImageClient{}
+	r := &ramImport{logger: logrus.StandardLogger(), imageClient: client, homeDir: t.TempDir(), signatureMode: SignatureRequired}
+	var sigErr *SignatureError
+	if _, err := r.ImportArtifact(context.Background(), result.PackagePath, "", "", v1alpha1.ImageInfo{HubURL: host, Namespace: "demo"}); !errors.As(err, &sigErr) {
+		t.Fatalf("the unsigned app artifact should be rejected if the signature is required, got %v", err)
+	}
+	r.signatureMode = SignatureWarn
+	imported, err := r.ImportArtifact(context.Background(), result.PackagePath, "robot", "", v1alpha1.ImageInfo{HubURL: host, Namespace: "demo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.AppName != "demo" || len(imported.Components) != 2 || imported.Components[0].ShareImage != host+"/demo/web:1.0" {
+		t.Fatalf("unexpected app %+v", imported)
+	}
+	if len(client.pulls) != 1 || client.pulls[0] != host+"/team/api:1.0" || len(client.pushes) != 1 {
+		t.Errorf("only the image missing in the hub should be copied, pulled %v pushed %v", client.pulls, client.pushes)
+	}
+	if user := client.pullUsers[host+"/team/api:1.0"]; user != "robot" {
+		t.Errorf("the image of the artifact registry should be pulled with its credential, got user %q", user)
+	}
+}
+
+func TestArtifactPullCredential(t *testing.T) {
+	r := &ramImport{credentials: []RegistryCredential{{Registry: "docker.io", Username: "hub", Password: "hub-pass"}}}
+	for _, c := range []struct {
+		image, user string
+	}{
+		{"registry.example.com/team/web:1.0", "robot"},
+		{"nginx:1.19", "hub"},
+		{"quay.io/team/api:1.0", ""},
+	} {
+		if user, _ := r.pullCredential("registry.example.com/apps/demo:1.0", "robot", "pass", c.image); user != c.user {
+			t.Errorf("expected the image %s pulled by %q, got %q", c.image, c.user, user)
+		}
+	}
+}
+
+// manifestConfig the digest of the config of the manifest
+func (t *testRegistry) manifestConfig(tb testing.TB, key string) string {
+	var m ocispec.Manifest
+	if err := json.Unmarshal(t.manifests[key], &m); err != nil {
+		tb.Fatal(err)
+	}
+	return m.Config.Digest.String()
+}
@@ -0,0 +1,185 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// CheckpointFileName the checkpoint of the import in the import dir
+const CheckpointFileName = ".import-checkpoint.json"
+
+// ImageStatus the status of an image of the import
+type ImageStatus string
+
+const (
+	//ImageLoaded the image is loaded from the package
+	ImageLoaded ImageStatus = "loaded"
+	//ImagePushed the image is pushed to the target registry
+	ImagePushed ImageStatus = "pushed"
+)
+
+// Checkpoint records the progress of an import, so that a failed import can be resumed
+// without extracting the package and pushing the images again
+type Checkpoint struct {
+	// Package the package file and its size and modification time, the checkpoint is
+	// used only for the same package
+	Package        string    `json:"package"`
+	PackageSize    int64     `json:"package_size"`
+	PackageModTime time.Time `json:"package_mod_time"`
+	Extracted      bool      `json:"extracted"`
+	// Archives the image archives that are loaded, by their path in the import dir
+	Archives map[string]bool `json:"archives"`
+	// Images the images of the package by their name in the package
+	Images map[string]*ImageCheckpoint `json:"images"`
+
+	mu   sync.Mutex
+	file string
+}
+
+// ImageCheckpoint the status of an image of the import
+type ImageCheckpoint struct {
+	// Digest the digest of the image in the package
+	Digest digest.Digest `json:"digest,omitempty"`
+	Status ImageStatus   `json:"status"`
+	// Target the image pushed to the target registry
+	Target string `json:"target,omitempty"`
+}
+
+// newCheckpoint create the checkpoint of the package in the import dir
+func newCheckpoint(homeDir, packageFile string) (*Checkpoint, error) {
+	abs, err := filepath.Abs(packageFile)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(packageFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkpoint{
+		Package:        abs,
+		PackageSize:    info.Size(),
+		PackageModTime: info.ModTime().UTC(),
+		Archives:       make(map[string]bool),
+		Images:         make(map[string]*ImageCheckpoint),
+		file:           path.Join(homeDir, CheckpointFileName),
+	}, nil
+}
+
+// readCheckpoint read the checkpoint of the import dir, it returns nil if there is no
+// checkpoint or it's the checkpoint of another package
+func readCheckpoint(homeDir, packageFile string) (*Checkpoint, error) {
+	current, err := newCheckpoint(homeDir, packageFile)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(current.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(body, &cp); err != nil {
+		// a checkpoint cut off by a crash is ignored
+		return nil, nil
+	}
+	if cp.Package != current.Package || cp.PackageSize != current.PackageSize || !cp.PackageModTime.Equal(current.PackageModTime) {
+		return nil, nil
+	}
+	if cp.Archives == nil {
+		cp.Archives = make(map[string]bool)
+	}
+	if cp.Images == nil {
+		cp.Images = make(map[string]*ImageCheckpoint)
+	}
+	cp.file = current.file
+	return &cp, nil
+}
+
+// save write the checkpoint, it's replaced atomically so that a crash never leaves a
+// half-written checkpoint
+func (c *Checkpoint) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+// loaded whether the image archive is loaded
+func (c *Checkpoint) loaded(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Archives[name]
+}
+
+// archiveLoaded record the images of the loaded archive
+func (c *Checkpoint) archiveLoaded(name string, images []image.ArchiveImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Archives[name] = true
+	for _, img := range images {
+		key := normalizeName(img.Name)
+		if cp, ok := c.Images[key]; ok && cp.Status == ImagePushed && cp.Digest == img.Digest {
+			continue
+		}
+		c.Images[key] = &ImageCheckpoint{Digest: img.Digest, Status: ImageLoaded}
+	}
+}
+
+// image the checkpoint of the image in the package, nil if it's not loaded
+func (c *Checkpoint) image(name string) *ImageCheckpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cp, ok := c.Images[normalizeName(name)]; ok {
+		copied := *cp
+		return &copied
+	}
+	return nil
+}
+
+// pushed record the image pushed to the target
+func (c *Checkpoint) pushed(name, target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := normalizeName(name)
+	cp, ok := c.Images[key]
+	if !ok {
+		cp = &ImageCheckpoint{}
+		c.Images[key] = cp
+	}
+	cp.Status, cp.Target = ImagePushed, target
+}
@@ -0,0 +1,145 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeImageClient records the loads, pulls and pushes, the pushes of failPush fail
+type fakeImageClient struct {
+	mu    sync.Mutex
+	loads int
+	pulls []string
+	// pullUsers the users the images are pulled with
+	pullUsers map[string]string
+	pushes    []string
+	failPush  string
+}
+
+func (f *fakeImageClient) ImageSave(destination string, images []string) error { return nil }
+func (f *fakeImageClient) ImageLoad(tarFile string) error {
+	return f.ImageLoadWithContext(context.Background(), tarFile)
+}
+func (f *fakeImageClient) ImagePull(image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	return nil, nil
+}
+func (f *fakeImageClient) ImagePush(image, user, pass string, timeout int) error {
+	return f.ImagePushWithContext(context.Background(), image, user, pass, timeout)
+}
+func (f *fakeImageClient) ImageTag(source, target string, timeout int) error { return nil }
+func (f *fakeImageClient) ImageSaveWithContext(ctx context.Context, destination string, images []string) error {
+	return nil
+}
+func (f *fakeImageClient) ImageLoadWithContext(ctx context.Context, tarFile string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loads++
+	return nil
+}
+func (f *fakeImageClient) ImagePullWithContext(ctx context.Context, image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pulls = append(f.pulls, image)
+	if f.pullUsers == nil {
+		f.pullUsers = make(map[string]string)
+	}
+	f.pullUsers[image] = username
+	return nil, nil
+}
+func (f *fakeImageClient) ImagePushWithContext(ctx context.Context, image, user, pass string, timeout int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failPush != "" && strings.Contains(image, f.failPush) {
+		return errors.New("connection reset by peer")
+	}
+	f.pushes = append(f.pushes, image)
+	return nil
+}
+func (f *fakeImageClient) ImageTagWithContext(ctx context.Context, source, target string, timeout int) error {
+	return nil
+}
+
+func TestResumeImport(t *testing.T) {
+	ram := v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{
+			{ServiceCname: "web", ComponentKey: "web", ServiceShareID: "web", ShareImage: "goodrain.me/web:1.0"},
+			{ServiceCname: "api", ComponentKey: "api", ServiceShareID: "api", ShareImage: "goodrain.me/api:1.0"},
+		},
+	}
+	metadata, _ := json.Marshal(ram)
+	manifest := []byte(`[{"Config":"web.json","RepoTags":["goodrain.me/web:1.0"],"Layers":[]},{"Config":"api.json","RepoTags":["goodrain.me/api:1.0"],"Layers":[]}]`)
+	var images bytes.Buffer
+	tw := tar.NewWriter(&images)
+	writeTar(t, tw, map[string][]byte{"web.json": []byte("{}"), "api.json": []byte("{}"), "manifest.json": manifest})
+	tw.Close()
+	packageFile := writePackage(t, map[string][]byte{
+		"demo-1.0-ram/metadata.json":        metadata,
+		"demo-1.0-ram/component-images.tar": images.Bytes(),
+	})
+
+	client := &fakeImageClient{failPush: "api"}
+	r := &ramImport{logger: logrus.StandardLogger(), imageClient: client, homeDir: t.TempDir(), resume: true}
+	r.imagePool().Retries = 0
+	// the target registry is unreachable, so the images are checked against the checkpoint only
+	hub := v1alpha1.ImageInfo{HubURL: "127.0.0.1:1", Namespace: "demo"}
+	if _, err := r.ImportWithContext(context.Background(), packageFile, hub); err == nil {
+		t.Fatal("expected the import failed by the push of the api image")
+	}
+	cp, err := readCheckpoint(r.homeDir, packageFile)
+	if err != nil || cp == nil {
+		t.Fatalf("expected the checkpoint of the package, got %v", err)
+	}
+	if !cp.Extracted || cp.image("goodrain.me/web:1.0").Status != ImagePushed || cp.image("goodrain.me/api:1.0").Status != ImageLoaded {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+
+	client.failPush = ""
+	result, err := r.ImportWithContext(context.Background(), packageFile, hub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.loads != 1 {
+		t.Errorf("the loaded images should not be loaded again, got %d loads", client.loads)
+	}
+	if len(client.pushes) != 2 || !strings.Contains(client.pushes[1], "api") {
+		t.Errorf("only the api image should be pushed again, got %v", client.pushes)
+	}
+	for _, com := range result.Components {
+		if !strings.HasPrefix(com.ShareImage, "127.0.0.1:1/demo/") {
+			t.Errorf("unexpected image of the resumed component %s", com.ShareImage)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// NewDockerComposeImport new importer that converts a docker compose file into app template.
+// The images referenced by the compose file are kept as they are, so hubInfo is not required.
+func NewDockerComposeImport(logger *logrus.Logger) AppLocalImport {
+	return &composeImport{logger: logger}
+}
+
+type composeImport struct {
+	logger *logrus.Logger
+}
+
+func (c *composeImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alpha1.RainbondApplicationConfig, error) {
+	return c.ImportWithContext(context.Background(), filePath, hubInfo)
+}
+
+// ImportWithContext the compose file is only parsed, no image is loaded
+func (c *composeImport) ImportWithContext(ctx context.Context, filePath string, hubInfo v1alpha1.ImageInfo) (*v1alpha1.RainbondApplicationConfig, error) {
+	c.logger.Infof("start import app by docker compose file %s", filePath)
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("read docker compose file %s failure %s", filePath, err.Error())
+	}
+	workDir := filepath.Dir(filePath)
+	// the compose project name is the name of the directory that contains the file
+	appName := filepath.Base(workDir)
+	ram, err := ParseDockerCompose(content, appName, workDir)
+	if err != nil {
+		return nil, err
+	}
+	if hubInfo.HubURL != "" {
+		for _, com := range ram.Components {
+			com.AppImage = hubInfo
+		}
+	}
+	c.logger.Infof("success import %d components from docker compose file", len(ram.Components))
+	return ram, nil
+}
+
+// composeFile is the subset of the compose file (version 2 and 3) that can be converted
+// into app template. Fields with several syntaxes are parsed into interface{}.
+type composeFile struct {
+	Version  string                     `yaml:"version"`
+	Services map[string]*composeService `yaml:"services"`
+	Volumes  map[string]interface{}     `yaml:"volumes"`
+}
+
+type composeService struct {
+	Image       string        `yaml:"image"`
+	Command     interface{}   `yaml:"command"`
+	Environment interface{}   `yaml:"environment"`
+	Ports       []interface{} `yaml:"ports"`
+	Expose      []interface{} `yaml:"expose"`
+	Volumes     []interface{} `yaml:"volumes"`
+	DependsOn   interface{}   `yaml:"depends_on"`
+	MemLimit    interface{}   `yaml:"mem_limit"`
+	CPUs        interface{}   `yaml:"cpus"`
+	Deploy      struct {
+		Replicas  *int `yaml:"replicas"`
+		Resources struct {
+			Limits struct {
+				CPUs   interface{} `yaml:"cpus"`
+				Memory interface{} `yaml:"memory"`
+			} `yaml:"limits"`
+		} `yaml:"resources"`
+	} `yaml:"deploy"`
+}
+
+// ParseDockerCompose convert docker compose file content to app template.
+// workDir is the directory relative bind mounts are resolved in, bind mounted files
+// become config file volumes and bind mounted directories are ignored.
+func ParseDockerCompose(content []byte, appName, workDir string) (*v1alpha1.RainbondApplicationConfig, error) {
+	var file composeFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("parse docker compose file failure %s", err.Error())
+	}
+	if len(file.Services) == 0 {
+		return nil, fmt.Errorf("docker compose file does not define any service")
+	}
+	ram := &v1alpha1.RainbondApplicationConfig{
+		AppKeyID:   util.NewUUID(),
+		AppName:    appName,
+		AppVersion: "1.0",
+	}
+	var names []string
+	for name := range file.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	components := make(map[string]*v1alpha1.Component, len(names))
+	// the first service that mounts a named volume owns it, the others share it
+	namedVolumes := make(map[string]*v1alpha1.Component)
+	for _, name := range names {
+		svc := file.Services[name]
+		if svc == nil || svc.Image == "" {
+			return nil, fmt.Errorf("service %s: only services with image are supported", name)
+		}
+		com, err := newComposeComponent(name, svc)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %s", name, err.Error())
+		}
+		if err := parseComposeVolumes(com, svc.Volumes, namedVolumes, workDir); err != nil {
+			return nil, fmt.Errorf("service %s: %s", name, err.Error())
+		}
+		components[name] = com
+		ram.Components = append(ram.Components, com)
+	}
+	for _, name := range names {
+		deps, err := stringsOrKeys(file.Services[name].DependsOn)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: depends_on %s", name, err.Error())
+		}
+		for _, dep := range deps {
+			depCom, ok := components[dep]
+			if !ok {
+				return nil, fmt.Errorf("service %s: depends on undefined service %s", name, dep)
+			}
+			components[name].DepServiceMapList = append(components[name].DepServiceMapList, v1alpha1.ComponentDep{
+				DepServiceKey: depCom.ComponentKey,
+			})
+		}
+	}
+	ram.HandleNullValue()
+	return ram, nil
+}
+
+func newComposeComponent(name string, svc *composeService) (*v1alpha1.Component, error) {
+	key := util.NewUUID()
+	com := &v1alpha1.Component{
+		ComponentKey:     key,
+		ServiceShareID:   key,
+		ServiceCname:     name,
+		ServiceName:      name,
+		ServiceAlias:     name,
+		K8SComponentName: name,
+		ServiceSource:    "docker_image",
+		ServiceType:      v1alpha1.ApplicationServiceType,
+		DeployType:       v1alpha1.StatelessMultipleDeployType,
+		Image:            svc.Image,
+		ShareImage:       svc.Image,
+		Version:          "latest",
+		ExtendMethodRule: v1alpha1.DefaultExtendMethodRule(),
+	}
+	if _, tag := splitImageTag(svc.Image); tag != "" {
+		com.Version = tag
+	}
+	cmd, err := commandString(svc.Command)
+	if err != nil {
+		return nil, fmt.Errorf("command %s", err.Error())
+	}
+	com.Cmd = cmd
+	envs, err := parseComposeEnvironment(svc.Environment)
+	if err != nil {
+		return nil, fmt.Errorf("environment %s", err.Error())
+	}
+	com.Envs = envs
+	ports, err := parseComposePorts(name, svc.Ports, svc.Expose)
+	if err != nil {
+		return nil, err
+	}
+	com.Ports = ports
+
+	memory := svc.Deploy.Resources.Limits.Memory
+	if memory == nil {
+		memory = svc.MemLimit
+	}
+	if com.Memory, err = parseMemory(memory); err != nil {
+		return nil, fmt.Errorf("memory limit %s", err.Error())
+	}
+	cpus := svc.Deploy.Resources.Limits.CPUs
+	if cpus == nil {
+		cpus = svc.CPUs
+	}
+	if com.CPU, err = parseCPU(cpus); err != nil {
+		return nil, fmt.Errorf("cpus limit %s", err.Error())
+	}
+	if com.Memory > 0 {
+		com.ExtendMethodRule.InitMemory = com.Memory
+	}
+	if svc.Deploy.Replicas != nil && *svc.Deploy.Replicas > 0 {
+		com.ExtendMethodRule.MinNode = *svc.Deploy.Replicas
+	}
+	return com, nil
+}
+
+func splitImageTag(image string) (string, string) {
+	i := strings.LastIndex(image, ":")
+	if i <= strings.LastIndex(image, "/") {
+		return image, ""
+	}
+	return image[:i], image[i+1:]
+}
+
+func commandString(command interface{}) (string, error) {
+	switch cmd := command.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return cmd, nil
+	case []interface{}:
+		var args []string
+		for _, arg := range cmd {
+			s := scalarString(arg)
+			if strings.ContainsAny(s, " \t\"'") {
+				s = strconv.Quote(s)
+			}
+			args = append(args, s)
+		}
+		return strings.Join(args, " "), nil
+	default:
+		return "", fmt.Errorf("unsupported syntax %v", command)
+	}
+}
+
+func scalarString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// parseComposeEnvironment supports both the list (KEY=value) and the mapping syntax
+func parseComposeEnvironment(environment interface{}) ([]v1alpha1.ComponentEnv, error) {
+	var envs []v1alpha1.ComponentEnv
+	switch env := environment.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range env {
+			kv := strings.SplitN(scalarString(item), "=", 2)
+			e := v1alpha1.ComponentEnv{AttrName: kv[0], Name: kv[0], IsChange: true}
+			if len(kv) == 2 {
+				e.AttrValue = kv[1]
+			}
+			envs = append(envs, e)
+		}
+	case map[interface{}]interface{}:
+		var keys []string
+		for k := range env {
+			keys = append(keys, scalarString(k))
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			envs = append(envs, v1alpha1.ComponentEnv{
+				AttrName:  k,
+				Name:      k,
+				IsChange:  true,
+				AttrValue: scalarString(env[k]),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported syntax %v", environment)
+	}
+	return envs, nil
+}
+
+// parseComposePorts supports the short syntax ([ip:][host:]container[/protocol], ranges
+// included) and the long syntax of ports. Published ports are outer ports.
+func parseComposePorts(serviceName string, ports, expose []interface{}) ([]v1alpha1.ComponentPort, error) {
+	var result []v1alpha1.ComponentPort
+	index := make(map[int]int)
+	add := func(containerPort int, protocol string, outer bool) {
+		if i, ok := index[containerPort]; ok {
+			result[i].IsOuter = result[i].IsOuter || outer
+			return
+		}
+		index[containerPort] = len(result)
+		result = append(result, v1alpha1.ComponentPort{
+			PortAlias:     strings.ToUpper(strings.Replace(serviceName, "-", "_", -1)) + strconv.Itoa(containerPort),
+			Protocol:      protocol,
+			ContainerPort: containerPort,
+			IsOuter:       outer,
+			IsInner:       true,
+		})
+	}
+	for _, p := range ports {
+		switch port := p.(type) {
+		case map[interface{}]interface{}:
+			target, err := strconv.Atoi(scalarString(port["target"]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid port target %v", port["target"])
+			}
+			protocol := scalarString(port["protocol"])
+			if protocol == "" {
+				protocol = "tcp"
+			}
+			add(target, protocol, port["published"] != nil)
+		default:
+			containerPorts, protocol, published, err := parsePortSpec(scalarString(port))
+			if err != nil {
+				return nil, err
+			}
+			for _, containerPort := range containerPorts {
+				add(containerPort, protocol, published)
+			}
+		}
+	}
+	for _, p := range expose {
+		containerPorts, protocol, _, err := parsePortSpec(scalarString(p))
+		if err != nil {
+			return nil, err
+		}
+		for _, containerPort := range containerPorts {
+			add(containerPort, protocol, false)
+		}
+	}
+	return result, nil
+}
+
+func parsePortSpec(spec string) (ports []int, protocol string, published bool, err error) {
+	protocol = "tcp"
+	if i := strings.Index(spec, "/"); i >= 0 {
+		protocol = spec[i+1:]
+		spec = spec[:i]
+	}
+	parts := strings.Split(spec, ":")
+	containerPart := parts[len(parts)-1]
+	published = len(parts) > 1
+	start, end := containerPart, containerPart
+	if i := strings.Index(containerPart, "-"); i >= 0 {
+		start, end = containerPart[:i], containerPart[i+1:]
+	}
+	from, err := strconv.Atoi(start)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("invalid port %s", spec)
+	}
+	to, err := strconv.Atoi(end)
+	if err != nil || to < from {
+		return nil, "", false, fmt.Errorf("invalid port range %s", spec)
+	}
+	for port := from; port <= to; port++ {
+		ports = append(ports, port)
+	}
+	return ports, protocol, published, nil
+}
+
+// parseComposeVolumes converts named and anonymous volumes into component volumes,
+// tmpfs into memory volumes and bind mounted files into config file volumes.
+func parseComposeVolumes(com *v1alpha1.Component, volumes []interface{}, namedVolumes map[string]*v1alpha1.Component, workDir string) error {
+	for i, v := range volumes {
+		var volumeType, source, target string
+		switch volume := v.(type) {
+		case map[interface{}]interface{}:
+			volumeType = scalarString(volume["type"])
+			source = scalarString(volume["source"])
+			target = scalarString(volume["target"])
+		default:
+			parts := strings.Split(scalarString(volume), ":")
+			switch len(parts) {
+			case 1:
+				target = parts[0]
+			default:
+				source, target = parts[0], parts[1]
+			}
+			switch {
+			case source == "":
+				volumeType = "volume"
+			case strings.HasPrefix(source, ".") || strings.HasPrefix(source, "/") || strings.HasPrefix(source, "~"):
+				volumeType = "bind"
+			default:
+				volumeType = "volume"
+			}
+		}
+		if target == "" {
+			return fmt.Errorf("volume %v has no target path", v)
+		}
+		switch volumeType {
+		case "volume", "":
+			if source == "" {
+				// the anonymous volumes are never shared by the services
+				source = fmt.Sprintf("volume%d", i)
+			} else if owner, ok := namedVolumes[source]; ok && owner != com {
+				com.MntReleationList = append(com.MntReleationList, v1alpha1.ComponentShareVolume{
+					VolumeName:       source,
+					VolumeMountDir:   target,
+					ShareServiceUUID: owner.ServiceShareID,
+				})
+				continue
+			} else {
+				namedVolumes[source] = com
+			}
+			com.ServiceVolumeMapList.Add(v1alpha1.ComponentVolume{
+				VolumeName:      source,
+				VolumeMountPath: target,
+				VolumeType:      v1alpha1.ShareFileVolumeType,
+				AccessMode:      v1alpha1.RWXAccessMode,
+			})
+		case "tmpfs":
+			com.ServiceVolumeMapList.Add(v1alpha1.ComponentVolume{
+				VolumeName:      fmt.Sprintf("tmpfs%d", i),
+				VolumeMountPath: target,
+				VolumeType:      v1alpha1.MemoryFSVolumeType,
+			})
+		case "bind":
+			content, ok := readBindFile(workDir, source)
+			if !ok {
+				logrus.Warningf("[compose] bind mount %s of service %s is not a file, ignore it", source, com.ServiceCname)
+				continue
+			}
+			com.ServiceVolumeMapList.Add(v1alpha1.ComponentVolume{
+				VolumeName:      strings.Trim(strings.Replace(filepath.Base(target), ".", "-", -1), "-"),
+				VolumeMountPath: target,
+				VolumeType:      v1alpha1.ConfigFileVolumeType,
+				FileConent:      content,
+			})
+		default:
+			logrus.Warningf("[compose] volume type %s of service %s is not supported, ignore it", volumeType, com.ServiceCname)
+		}
+	}
+	return nil
+}
+
+func readBindFile(workDir, source string) (string, bool) {
+	if workDir == "" || strings.HasPrefix(source, "~") {
+		return "", false
+	}
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(workDir, source)
+	}
+	info, err := os.Stat(source)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	content, err := ioutil.ReadFile(source)
+	if err != nil {
+		return "", false
+	}
+	return string(content), true
+}
+
+// stringsOrKeys supports the list and the mapping (with conditions) syntax of depends_on
+func stringsOrKeys(v interface{}) ([]string, error) {
+	var result []string
+	switch value := v.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range value {
+			result = append(result, scalarString(item))
+		}
+	case map[interface{}]interface{}:
+		for k := range value {
+			result = append(result, scalarString(k))
+		}
+		sort.Strings(result)
+	default:
+		return nil, fmt.Errorf("unsupported syntax %v", v)
+	}
+	return result, nil
+}
+
+var memoryUnits = map[string]float64{
+	"":   1.0 / (1024 * 1024),
+	"b":  1.0 / (1024 * 1024),
+	"k":  1.0 / 1024,
+	"kb": 1.0 / 1024,
+	"m":  1,
+	"mb": 1,
+	"g":  1024,
+	"gb": 1024,
+}
+
+// parseMemory parses a compose byte value into MB
+func parseMemory(v interface{}) (int, error) {
+	s := strings.ToLower(strings.TrimSpace(scalarString(v)))
+	if s == "" {
+		return 0, nil
+	}
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	number, unit := s, ""
+	if i >= 0 {
+		number, unit = s[:i], s[i:]
+	}
+	factor, ok := memoryUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid value %s", s)
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", s)
+	}
+	return int(n * factor), nil
+}
+
+// parseCPU parses a compose cpus value into millicores
+func parseCPU(v interface{}) (int, error) {
+	s := strings.TrimSpace(scalarString(v))
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", s)
+	}
+	return int(n * 1000), nil
+}
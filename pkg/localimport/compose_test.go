@@ -0,0 +1,180 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+var composeV3 = `
+version: "3.8"
+services:
+  web:
+    image: nginx:1.19
+    command: ["nginx", "-g", "daemon off;"]
+    environment:
+      - DEBUG=1
+      - EMPTY
+    ports:
+      - "8080:80"
+      - target: 443
+        published: 8443
+    volumes:
+      - ./nginx.conf:/etc/nginx/nginx.conf:ro
+      - static:/usr/share/nginx/html
+    depends_on:
+      db:
+        condition: service_healthy
+    deploy:
+      replicas: 2
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 256M
+  db:
+    image: mysql:5.7
+    environment:
+      MYSQL_ROOT_PASSWORD: secret
+      MYSQL_PORT: 3306
+    expose:
+      - "3306"
+    volumes:
+      - type: volume
+        source: data
+        target: /var/lib/mysql
+      - static:/static
+volumes:
+  data:
+  static:
+`
+
+var composeV2 = `
+version: "2.1"
+services:
+  app:
+    image: example/app
+    mem_limit: 1g
+    ports:
+      - "9000-9001/udp"
+    depends_on:
+      - cache
+  cache:
+    image: redis
+`
+
+func findComponent(ram *v1alpha1.RainbondApplicationConfig, name string) *v1alpha1.Component {
+	for _, com := range ram.Components {
+		if com.ServiceCname == name {
+			return com
+		}
+	}
+	return nil
+}
+
+func TestParseDockerComposeV3(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "nginx.conf"), []byte("worker_processes 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ram, err := ParseDockerCompose([]byte(composeV3), "demo", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web, db := findComponent(ram, "web"), findComponent(ram, "db")
+	if web == nil || db == nil {
+		t.Fatalf("components not found: %+v", ram.Components)
+	}
+	if web.Cmd != `nginx -g "daemon off;"` {
+		t.Errorf("unexpected cmd %s", web.Cmd)
+	}
+	if len(web.Envs) != 2 || web.Envs[0].AttrValue != "1" || web.Envs[1].AttrName != "EMPTY" {
+		t.Errorf("unexpected envs %+v", web.Envs)
+	}
+	if len(web.Ports) != 2 || web.Ports[0].ContainerPort != 80 || !web.Ports[0].IsOuter || web.Ports[1].ContainerPort != 443 {
+		t.Errorf("unexpected ports %+v", web.Ports)
+	}
+	if web.Memory != 256 || web.CPU != 500 || web.ExtendMethodRule.MinNode != 2 {
+		t.Errorf("unexpected resources memory=%d cpu=%d replicas=%d", web.Memory, web.CPU, web.ExtendMethodRule.MinNode)
+	}
+	if len(web.DepServiceMapList) != 1 || web.DepServiceMapList[0].DepServiceKey != db.ComponentKey {
+		t.Errorf("unexpected dependencies %+v", web.DepServiceMapList)
+	}
+	if len(web.ServiceVolumeMapList) != 1 || web.ServiceVolumeMapList[0].VolumeType != v1alpha1.ConfigFileVolumeType ||
+		web.ServiceVolumeMapList[0].FileConent != "worker_processes 1;" {
+		t.Errorf("unexpected web volumes %+v", web.ServiceVolumeMapList)
+	}
+	if len(db.Ports) != 1 || db.Ports[0].IsOuter {
+		t.Errorf("unexpected db ports %+v", db.Ports)
+	}
+	if len(db.Envs) != 2 || db.Envs[1].AttrName != "MYSQL_ROOT_PASSWORD" {
+		t.Errorf("unexpected db envs %+v", db.Envs)
+	}
+	// db is parsed before web, so it owns the shared named volume
+	if len(db.ServiceVolumeMapList) != 2 || len(web.MntReleationList) != 1 || web.MntReleationList[0].ShareServiceUUID != db.ServiceShareID {
+		t.Errorf("unexpected volume owner db=%+v web=%+v", db.ServiceVolumeMapList, web.MntReleationList)
+	}
+}
+
+func TestParseDockerComposeV2(t *testing.T) {
+	ram, err := ParseDockerCompose([]byte(composeV2), "demo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, cache := findComponent(ram, "app"), findComponent(ram, "cache")
+	if app.Memory != 1024 {
+		t.Errorf("unexpected memory %d", app.Memory)
+	}
+	if len(app.Ports) != 2 || app.Ports[1].ContainerPort != 9001 || app.Ports[1].Protocol != "udp" {
+		t.Errorf("unexpected ports %+v", app.Ports)
+	}
+	if len(app.DepServiceMapList) != 1 || app.DepServiceMapList[0].DepServiceKey != cache.ComponentKey {
+		t.Errorf("unexpected dependencies %+v", app.DepServiceMapList)
+	}
+	if _, err := ParseDockerCompose([]byte("services:\n  a:\n    image: a\n    depends_on: [b]\n"), "demo", ""); err == nil {
+		t.Errorf("expected error for undefined dependency")
+	}
+}
+
+func TestParseDockerComposeAnonymousVolumes(t *testing.T) {
+	compose := `
+services:
+  a:
+    image: a
+    volumes:
+      - /data
+  b:
+    image: b
+    volumes:
+      - /cache
+`
+	ram, err := ParseDockerCompose([]byte(compose), "demo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		com := findComponent(ram, name)
+		if len(com.ServiceVolumeMapList) != 1 || len(com.MntReleationList) != 0 {
+			t.Errorf("the anonymous volume of %s should not be shared, volumes %+v shares %+v", name, com.ServiceVolumeMapList, com.MntReleationList)
+		}
+	}
+}
@@ -19,6 +19,10 @@
 package localimport
 
 import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/containerd/containerd"
@@ -28,66 +32,210 @@ import (
 	"github.com/goodrain/rainbond-oam/pkg/util"
 	"github.com/goodrain/rainbond-oam/pkg/util/docker"
 	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
+	"github.com/goodrain/rainbond-oam/pkg/util/sign"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 //AppLocalImport import
 type AppLocalImport interface {
 	Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alpha1.RainbondApplicationConfig, error)
+	// ImportWithContext stops loading, tagging and pushing images when the context is canceled
+	ImportWithContext(ctx context.Context, filePath string, hubInfo v1alpha1.ImageInfo) (*v1alpha1.RainbondApplicationConfig, error)
+}
+
+//Option import option
+type Option func(*ramImport)
+
+//WithRewriteRules rewrite the component and plugin images by the first matched rule
+//instead of the hub info, the images that match no rule are pushed to the hub
+func WithRewriteRules(rules ...docker.RewriteRule) Option {
+	return func(r *ramImport) {
+		r.rewriteRules = append(r.rewriteRules, rules...)
+	}
+}
+
+//WithConcurrency push at most n images at the same time
+func WithConcurrency(n int) Option {
+	return func(r *ramImport) {
+		r.imagePool().Concurrency = n
+	}
+}
+
+//WithRetry retry a failed image push the given times, the backoff doubles on every retry
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(r *ramImport) {
+		r.imagePool().Retries = retries
+		r.imagePool().Backoff = backoff
+	}
+}
+
+//SignatureMode how the signature of the package manifest is verified
+type SignatureMode string
+
+const (
+	//SignatureOff the signature is not verified
+	SignatureOff SignatureMode = "off"
+	//SignatureWarn the packages that are unsigned or not signed by a trusted key are
+	//imported with a warning
+	SignatureWarn SignatureMode = "warn"
+	//SignatureRequired only the packages signed by a trusted key are imported
+	SignatureRequired SignatureMode = "required"
+)
+
+//SignatureError the package is unsigned or not signed by a trusted key
+type SignatureError struct {
+	Reason string
+}
+
+func (e *SignatureError) Error() string {
+	return "verify package signature failure: " + e.Reason
+}
+
+//WithSignatureVerification verify the signature of the package manifest with the trusted
+//keys, the PEM files of ed25519 or ECDSA P-256 public keys such as cosign.pub
+func WithSignatureVerification(mode SignatureMode, trustedKeys ...string) Option {
+	return func(r *ramImport) {
+		r.signatureMode = mode
+		r.trustedKeyFiles = append(r.trustedKeyFiles, trustedKeys...)
+	}
+}
+
+//WithBasePackage the base package of the delta packages, the files and image layers
+//the delta takes from it are restored before the images are loaded
+func WithBasePackage(file string) Option {
+	return func(r *ramImport) {
+		r.basePackage = file
+	}
+}
+
+//WithResume resume the import from the checkpoint in the import dir if it's the checkpoint
+//of the same package, the package is not extracted again, and the images that are loaded,
+//pushed or already in the target registry are skipped
+func WithResume() Option {
+	return func(r *ramImport) {
+		r.resume = true
+	}
+}
+
+//WithRegistryClient load and push the images with the daemonless registry client instead
+//of containerd or docker, the images are stored under root
+func WithRegistryClient(root string) Option {
+	return func(r *ramImport) {
+		r.registryRoot = root
+	}
 }
 
 //New new
-func New(logger *logrus.Logger, containerdCli *containerd.Client, dockerCli *dockercli.Client, homeDir string) (AppLocalImport, error) {
-	imageClient, err := image.NewClient(containerdCli, dockerCli)
+func New(logger *logrus.Logger, containerdCli *containerd.Client, dockerCli *dockercli.Client, homeDir string, opts ...Option) (AppLocalImport, error) {
+	r := &ramImport{
+		logger:  logger,
+		homeDir: homeDir,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	var err error
+	if r.registryRoot != "" {
+		r.imageClient, err = image.NewRegistryClient(r.registryRoot)
+	} else {
+		r.imageClient, err = image.NewClient(containerdCli, dockerCli)
+	}
 	if err != nil {
 		logger.Errorf("create image client error: %v", err)
 		return nil, err
 	}
-	return &ramImport{
-		logger:      logger,
-		imageClient: imageClient,
-		homeDir:     homeDir,
-	}, nil
+	for _, file := range r.trustedKeyFiles {
+		key, err := sign.LoadPublicKey(file)
+		if err != nil {
+			logger.Errorf("load trusted key error: %v", err)
+			return nil, err
+		}
+		r.trustedKeys = append(r.trustedKeys, key)
+	}
+	if r.signatureMode == SignatureRequired && len(r.trustedKeys) == 0 {
+		return nil, fmt.Errorf("signature verification is required but no trusted key is defined")
+	}
+	return r, nil
 }
 
 type ramImport struct {
-	logger      *logrus.Logger
-	imageClient image.Client
-	homeDir     string
+	logger       *logrus.Logger
+	imageClient  image.Client
+	homeDir      string
+	rewriteRules []docker.RewriteRule
+	pool         *image.Pool
+	// signatureMode how the signature of the package is verified, off if empty
+	signatureMode   SignatureMode
+	trustedKeyFiles []string
+	trustedKeys     []crypto.PublicKey
+	basePackage     string
+	resume          bool
+	// credentials the credentials of the registries the artifact images are pulled from
+	credentials []RegistryCredential
+	// registryRoot the local store of the daemonless registry client
+	registryRoot string
+}
+
+func (r *ramImport) imagePool() *image.Pool {
+	if r.pool == nil {
+		pool := image.DefaultPool
+		r.pool = &pool
+	}
+	return r.pool
+}
+
+//republished the name and target of a republished image
+type republished struct {
+	name   string
+	target docker.RewriteTarget
 }
 
 func (r *ramImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alpha1.RainbondApplicationConfig, error) {
-	if hubInfo.HubURL == "" {
+	return r.ImportWithContext(context.Background(), filePath, hubInfo)
+}
+
+func (r *ramImport) ImportWithContext(ctx context.Context, filePath string, hubInfo v1alpha1.ImageInfo) (re *v1alpha1.RainbondApplicationConfig, err error) {
+	defer func() { r.cleanupCanceledImport(ctx, err) }()
+	if hubInfo.HubURL == "" && len(r.rewriteRules) == 0 {
 		return nil, fmt.Errorf("must define hub url")
 	}
-	r.logger.Infof("start import app by app file %s", filePath)
-	if err := export.PrepareExportDir(r.homeDir); err != nil {
-		r.logger.Errorf("prepare import dir failure %s", err.Error())
+	rewriter, err := docker.NewRewriter(hubInfo, r.rewriteRules...)
+	if err != nil {
 		return nil, err
 	}
-	ext := path.Ext(filePath)
-	if ext == ".zip" {
-		if err := util.Unzip(filePath, r.homeDir); err != nil {
-			r.logger.Errorf("unzip file %s faile %s", filePath, err.Error())
+	r.logger.Infof("start import app by app file %s", filePath)
+	var cp *Checkpoint
+	if r.resume {
+		if cp, err = readCheckpoint(r.homeDir, filePath); err != nil {
+			r.logger.Errorf("read import checkpoint failure %s", err.Error())
 			return nil, err
 		}
+	}
+	if cp != nil && cp.Extracted {
+		r.logger.Infof("resume the import of %s from its checkpoint", filePath)
 	} else {
-		if err := util.Untar(filePath, r.homeDir); err != nil {
-			r.logger.Errorf("untar file %s faile %s", filePath, err.Error())
+		if err := r.extractPackage(ctx, filePath); err != nil {
+			return nil, err
+		}
+		if cp, err = newCheckpoint(r.homeDir, filePath); err != nil {
 			return nil, err
 		}
+		cp.Extracted = true
+		r.saveCheckpoint(cp)
 	}
-	r.logger.Infof("prepare app meta file success")
-	// read app meta config
-	files, _ := ioutil.ReadDir(r.homeDir)
-	if len(files) < 1 {
-		return nil, fmt.Errorf("Failed to read files in tmp dir %s", r.homeDir)
+	dir, err := packageDir(r.homeDir)
+	if err != nil {
+		return nil, err
 	}
-	metaFile, err := os.Open(path.Join(r.homeDir, files[0].Name(), "metadata.json"))
+	metaFile, err := os.Open(path.Join(dir, "metadata.json"))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to read files in tmp dir %s: %v", r.homeDir, err)
 	}
@@ -98,19 +246,24 @@ func (r *ramImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alph
 	}
 	// load all component images and plugin images
 	//after v5.3 package
-	l1, err := util.GetFileList(path.Join(r.homeDir, files[0].Name()), 1)
+	l1, err := util.GetFileList(dir, 1)
 	if err != nil {
 		return nil, err
 	}
 	//before v5.3 package
-	l2, err := util.GetFileList(path.Join(r.homeDir, files[0].Name()), 2)
+	l2, err := util.GetFileList(dir, 2)
 	if err != nil {
 		return nil, err
 	}
 	allfiles := append(l1, l2...)
 	for _, f := range allfiles {
 		if strings.HasSuffix(f, ".tar") {
-			err = r.imageClient.ImageLoad(f)
+			name, _ := filepath.Rel(r.homeDir, f)
+			if cp.loaded(name) {
+				r.logger.Infof("skip the loaded image file %s", f)
+				continue
+			}
+			err = image.ImageLoadWithContext(ctx, r.imageClient, f)
 			if err != nil {
 				if err.Error() != "unrecognized image format" {
 					return nil, err
@@ -118,71 +271,353 @@ func (r *ramImport) Import(filePath string, hubInfo v1alpha1.ImageInfo) (*v1alph
 				logrus.Warningf("docker image tar is empty，so unrecognized image format")
 			}
 			r.logger.Infof("load image from file %s success", f)
+			archived, err := image.ReadArchiveFile(f)
+			if err != nil {
+				r.logger.Warningf("read the images of file %s failure %s", f, err.Error())
+			}
+			cp.archiveLoaded(name, archived)
+			r.saveCheckpoint(cp)
 		}
 	}
-	for _, com := range ram.Components {
-		// new hub info
-		newImageName, err := docker.NewImageName(com.ShareImage, hubInfo)
-		if err != nil {
-			r.logger.Errorf("parse image failure %s", err.Error())
-			return nil, err
-		}
-		err = r.imageClient.ImageTag(com.ShareImage, newImageName, 2)
-		if err != nil {
-			//Compatibility History Version
-			if strings.Contains(err.Error(), "No such image") {
-				var saveImage string
-				saveImage, err = docker.GetOldSaveImageName(com.ShareImage, false)
-				if err != nil {
-					return nil, err
-				}
-				err = r.imageClient.ImageTag(saveImage, newImageName, 2)
-			}
+	// the components and plugins sharing an image push it once
+	var (
+		mu   sync.Mutex
+		jobs []image.Job
+		done = make(map[string]republished)
+	)
+	addJob := func(name, source string) {
+		jobs = append(jobs, image.Job{Image: source, Run: func(ctx context.Context) error {
+			ctx = progress.WithComponent(ctx, name)
+			newImageName, target, err := r.resumeImage(ctx, rewriter, cp, source)
 			if err != nil {
-				logrus.Errorf("change image %s tag to %s failure %s", com.ShareImage, newImageName, err.Error())
-				return nil, err
+				return err
 			}
-		}
-		r.logger.Infof("start push image %s", newImageName)
-		if err := r.imageClient.ImagePush(newImageName, hubInfo.HubUser, hubInfo.HubPassword, 20); err != nil {
-			logrus.Errorf("push image %s failure %s", newImageName, err.Error())
-			return nil, err
-		}
-		r.logger.Infof("push image %s success", newImageName)
-		com.AppImage = hubInfo
-		com.ShareImage = newImageName
+			if newImageName == "" {
+				if newImageName, target, err = r.republish(ctx, rewriter, source); err != nil {
+					return err
+				}
+				cp.pushed(source, newImageName)
+				r.saveCheckpoint(cp)
+			}
+			mu.Lock()
+			done[source] = republished{name: newImageName, target: target}
+			mu.Unlock()
+			return nil
+		}})
+	}
+	for _, com := range ram.Components {
+		addJob(com.ServiceCname, com.ShareImage)
+	}
+	for _, plugin := range ram.Plugins {
+		addJob(plugin.PluginName, plugin.ShareImage)
+	}
+	if r.pool != nil {
+		ctx = image.WithPool(ctx, *r.pool)
+	}
+	if err := image.PoolFromContext(ctx).Run(ctx, jobs...); err != nil {
+		r.logger.Errorf("push images failure %s", err.Error())
+		return nil, err
+	}
+	assignImages(&ram, done)
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseDone, Message: "import success"})
+	return &ram, nil
+}
+
+//cleanupCanceledImport remove the half-extracted import dir when the import fails because the
+//context is canceled, like the canceled exports. The dir is kept if the import can be resumed.
+func (r *ramImport) cleanupCanceledImport(ctx context.Context, err error) {
+	if err == nil || ctx.Err() == nil || r.resume {
+		return
+	}
+	if rerr := os.RemoveAll(r.homeDir); rerr != nil {
+		r.logger.Warningf("remove canceled import %s failure %s", r.homeDir, rerr.Error())
+		return
+	}
+	r.logger.Infof("import is canceled, cleaned up %s", r.homeDir)
+}
+
+//assignImages point the components and plugins to their republished images
+func assignImages(ram *v1alpha1.RainbondApplicationConfig, done map[string]republished) {
+	for _, com := range ram.Components {
+		com.AppImage = done[com.ShareImage].target.ImageInfo
+		com.ShareImage = done[com.ShareImage].name
 	}
 	for i, plugin := range ram.Plugins {
-		// new hub info
-		newImageName, err := docker.NewImageName(plugin.ShareImage, hubInfo)
+		ram.Plugins[i].PluginImage = done[plugin.ShareImage].target.ImageInfo
+		ram.Plugins[i].ShareImage = done[plugin.ShareImage].name
+	}
+}
+
+//extractPackage extract the package into the import dir, and verify and assemble it
+func (r *ramImport) extractPackage(ctx context.Context, filePath string) error {
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePrepare, Message: "prepare import dir"})
+	if err := export.PrepareExportDir(r.homeDir); err != nil {
+		r.logger.Errorf("prepare import dir failure %s", err.Error())
+		return err
+	}
+	packageFile, err := r.joinVolumes(ctx, filePath, r.homeDir+".package")
+	if err != nil {
+		return err
+	}
+	if packageFile != filePath {
+		defer os.Remove(packageFile)
+	}
+	var size int64
+	if info, err := os.Stat(packageFile); err == nil {
+		size = info.Size()
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseExtract, Total: size, Message: "extract " + filePath})
+	if err := util.Extract(packageFile, r.homeDir); err != nil {
+		r.logger.Errorf("extract file %s failure %s", filePath, err.Error())
+		return err
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseExtract, Current: size, Total: size, Message: "extracted " + filePath})
+	r.logger.Infof("prepare app meta file success")
+	dir, err := packageDir(r.homeDir)
+	if err != nil {
+		return err
+	}
+	if err := r.verifyPackage(ctx, dir); err != nil {
+		return err
+	}
+	return r.assembleDelta(ctx, dir)
+
+}
+
+//packageDir the top dir of the extracted package
+func packageDir(homeDir string) (string, error) {
+	files, _ := ioutil.ReadDir(homeDir)
+	for _, file := range files {
+		if file.IsDir() {
+			return path.Join(homeDir, file.Name()), nil
+		}
+	}
+	return "", fmt.Errorf("Failed to read files in tmp dir %s", homeDir)
+}
+
+//resumeImage skip the image if the checkpoint records it's pushed, or the target registry
+//already has it, it returns an empty name if the image should be pushed
+func (r *ramImport) resumeImage(ctx context.Context, rewriter *docker.Rewriter, cp *Checkpoint, source string) (string, docker.RewriteTarget, error) {
+	if !r.resume {
+		return "", docker.RewriteTarget{}, nil
+	}
+	newImageName, target, err := rewriter.Rewrite(source)
+	if err != nil {
+		r.logger.Errorf("parse image failure %s", err.Error())
+		return "", target, err
+	}
+	img := cp.image(source)
+	if img == nil {
+		return "", target, nil
+	}
+	if img.Status == ImagePushed && img.Target == newImageName {
+		r.logger.Infof("skip the pushed image %s", newImageName)
+		return newImageName, target, nil
+	}
+	if img.Digest == "" {
+		return "", target, nil
+	}
+	if target.Insecure {
+		ctx = image.WithInsecure(ctx)
+	}
+	present, err := image.RemoteHasImage(ctx, newImageName, target.HubUser, target.HubPassword, img.Digest)
+	if err != nil {
+		r.logger.Warningf("check image %s in the target registry failure %s", newImageName, err.Error())
+		return "", target, nil
+	}
+	if !present {
+		return "", target, nil
+	}
+	r.logger.Infof("skip the image %s that the target registry already has", newImageName)
+	cp.pushed(source, newImageName)
+	r.saveCheckpoint(cp)
+	return newImageName, target, nil
+}
+
+//saveCheckpoint the import goes on if the checkpoint is not saved, it just can not be resumed
+func (r *ramImport) saveCheckpoint(cp *Checkpoint) {
+	if err := cp.save(); err != nil {
+		r.logger.Warningf("save import checkpoint failure %s", err.Error())
+	}
+}
+
+//verifyPackage check the extracted files against the package manifest
+func (r *ramImport) verifyPackage(ctx context.Context, dir string) error {
+	if r.verifySignature() {
+		manifest, err := readOptionalFile(path.Join(dir, export.ManifestFileName))
 		if err != nil {
-			r.logger.Errorf("parse image failure %s", err.Error())
-			return nil, err
+			return err
 		}
-		err = r.imageClient.ImageTag(plugin.ShareImage, newImageName, 2)
+		signature, err := readOptionalFile(path.Join(dir, export.SignatureFileName))
 		if err != nil {
-			//Compatibility History Version
-			if strings.Contains(err.Error(), "No such image") {
-				var saveImage string
-				saveImage, err = docker.GetOldSaveImageName(plugin.ShareImage, false)
-				if err != nil {
-					return nil, err
-				}
-				err = r.imageClient.ImageTag(saveImage, newImageName, 2)
-			}
+			return err
+		}
+		if err := r.enforceSignature(r.checkSignature(manifest, signature)); err != nil {
+			return err
+		}
+	}
+	manifest, err := export.ReadPackageManifest(dir)
+	if err != nil {
+		r.logger.Errorf("read package manifest failure %s", err.Error())
+		return err
+	}
+	if manifest == nil {
+		r.logger.Warningf("the package has no %s, skip the integrity check", export.ManifestFileName)
+		return nil
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseVerify, Total: int64(len(manifest.Files)), Message: "verify package files"})
+	if err := manifest.Verify(ctx, dir); err != nil {
+		r.logger.Errorf("verify package failure %s", err.Error())
+		return err
+	}
+	r.logger.Infof("verify %d package files success", len(manifest.Files))
+	return nil
+}
+
+//assembleDelta restore the files and image layers the delta package takes from the base package
+func (r *ramImport) assembleDelta(ctx context.Context, dir string) error {
+	manifest, err := export.ReadPackageManifest(dir)
+	if err != nil || manifest == nil || manifest.Delta == nil {
+		return err
+	}
+	if r.basePackage == "" {
+		return fmt.Errorf("the package is a delta of version %s, the base package is required", manifest.Delta.BaseAppVersion)
+	}
+	baseHome := r.homeDir + "-base"
+	if err := export.PrepareExportDir(baseHome); err != nil {
+		r.logger.Errorf("prepare base package dir failure %s", err.Error())
+		return err
+	}
+	defer os.RemoveAll(baseHome)
+	basePackage, err := r.joinVolumes(ctx, r.basePackage, baseHome+".package")
+	if err != nil {
+		return err
+	}
+	if basePackage != r.basePackage {
+		defer os.Remove(basePackage)
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseExtract, Message: "extract base package " + r.basePackage})
+	if err := util.Extract(basePackage, baseHome); err != nil {
+		r.logger.Errorf("extract base package %s failure %s", r.basePackage, err.Error())
+		return err
+	}
+	files, _ := ioutil.ReadDir(baseHome)
+	if len(files) < 1 {
+		return fmt.Errorf("base package %s is empty", r.basePackage)
+	}
+	baseDir := path.Join(baseHome, files[0].Name())
+	body, err := readOptionalFile(path.Join(baseDir, export.ManifestFileName))
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(body); body == nil || hex.EncodeToString(sum[:]) != manifest.Delta.BaseManifest {
+		return fmt.Errorf("base package %s is not the version %s the delta is made of", r.basePackage, manifest.Delta.BaseAppVersion)
+	}
+	base, err := export.ReadPackageManifest(baseDir)
+	if err != nil {
+		return err
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseVerify, Total: int64(len(base.Files)), Message: "verify base package files"})
+	if err := base.Verify(ctx, baseDir); err != nil {
+		r.logger.Errorf("verify base package failure %s", err.Error())
+		return err
+	}
+	if err := export.AssembleDelta(ctx, dir, baseDir, manifest, r.logger); err != nil {
+		r.logger.Errorf("assemble delta package failure %s", err.Error())
+		return err
+	}
+	r.logger.Infof("restore %d files and %d image layers from the base package", len(manifest.Delta.Files), len(manifest.Delta.Entries))
+	return nil
+}
+
+func (r *ramImport) verifySignature() bool {
+	return r.signatureMode == SignatureWarn || r.signatureMode == SignatureRequired
+}
+
+//checkSignature check the signature of the package manifest against the trusted keys
+func (r *ramImport) checkSignature(manifest, signature []byte) error {
+	if len(manifest) == 0 {
+		return &SignatureError{Reason: "the package has no " + export.ManifestFileName}
+	}
+	if len(signature) == 0 {
+		return &SignatureError{Reason: "the package is not signed"}
+	}
+	key, err := sign.Verify(r.trustedKeys, manifest, signature)
+	if err != nil {
+		return &SignatureError{Reason: "the package is not signed by a trusted key"}
+	}
+	r.logger.Infof("the package is signed by trusted key %s", sign.KeyID(key))
+	return nil
+}
+
+//enforceSignature return the signature error if the signature is required
+func (r *ramImport) enforceSignature(err error) error {
+	if err == nil {
+		return nil
+	}
+	if r.signatureMode == SignatureRequired {
+		r.logger.Error(err)
+		return err
+	}
+	r.logger.Warning(err)
+	return nil
+}
+
+// joinVolumes reassemble the package into dst if file is the index or a part of a split
+// package, the checksum of every part is verified. It returns the package to extract.
+func (r *ramImport) joinVolumes(ctx context.Context, file, dst string) (string, error) {
+	if !export.IsVolumeFile(file) {
+		return file, nil
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseVerify, Message: "join the volumes of " + file})
+	if err := export.JoinVolumes(ctx, file, dst); err != nil {
+		r.logger.Errorf("join the volumes of %s failure %s", file, err.Error())
+		return "", err
+	}
+	r.logger.Infof("joined the volumes of %s", file)
+	return dst, nil
+}
+
+func readOptionalFile(file string) ([]byte, error) {
+	body, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return body, err
+}
+
+//republish tag the loaded image with the name rewritten by the rules and push it
+func (r *ramImport) republish(ctx context.Context, rewriter *docker.Rewriter, source string) (string, docker.RewriteTarget, error) {
+	newImageName, target, err := rewriter.Rewrite(source)
+	if err != nil {
+		r.logger.Errorf("parse image failure %s", err.Error())
+		return "", target, err
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhaseTag, Image: newImageName, Message: "tag " + source})
+	err = image.ImageTagWithContext(ctx, r.imageClient, source, newImageName, 2)
+	if err != nil {
+		//Compatibility History Version
+		if strings.Contains(err.Error(), "No such image") {
+			var saveImage string
+			saveImage, err = docker.GetOldSaveImageName(source, false)
 			if err != nil {
-				logrus.Errorf("change image %s tag to %s failure %s", plugin.ShareImage, newImageName, err.Error())
-				return nil, err
+				return "", target, err
 			}
+			err = image.ImageTagWithContext(ctx, r.imageClient, saveImage, newImageName, 2)
 		}
-		r.logger.Infof("start push image %s", newImageName)
-		if err := r.imageClient.ImagePush(newImageName, hubInfo.HubUser, hubInfo.HubPassword, 20); err != nil {
-			logrus.Errorf("push image %s failure %s", newImageName, err.Error())
-			return nil, err
+		if err != nil {
+			logrus.Errorf("change image %s tag to %s failure %s", source, newImageName, err.Error())
+			return "", target, err
 		}
-		r.logger.Infof("push image %s success", newImageName)
-		ram.Plugins[i].PluginImage = hubInfo
-		ram.Plugins[i].ShareImage = newImageName
 	}
-	return &ram, nil
+	if target.Insecure {
+		ctx = image.WithInsecure(ctx)
+	}
+	r.logger.Infof("start push image %s", newImageName)
+	if err := image.ImagePushWithContext(ctx, r.imageClient, newImageName, target.HubUser, target.HubPassword, 20); err != nil {
+		logrus.Errorf("push image %s failure %s", newImageName, err.Error())
+		return "", target, err
+	}
+	r.logger.Infof("push image %s success", newImageName)
+	return newImageName, target, nil
 }
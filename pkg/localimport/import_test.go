@@ -19,18 +19,40 @@
 package localimport
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker/client"
+	"github.com/goodrain/rainbond-oam/pkg/export"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/sign"
 	"github.com/sirupsen/logrus"
 )
 
 func TestImport(t *testing.T) {
+	packagePath := "/Users/barnett/Downloads/默认应用-1.0-ram.tar.gz"
+	if _, err := os.Stat(packagePath); err != nil {
+		t.Skipf("ram package %s not found", packagePath)
+	}
 	c, _ := client.NewEnvClient()
-	im := New(logrus.StandardLogger(), c, "/tmp/ram/default")
-	info, err := im.Import("/Users/barnett/Downloads/默认应用-1.0-ram.tar.gz", v1alpha1.ImageInfo{
+	im, err := New(logrus.StandardLogger(), nil, c, "/tmp/ram/default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := im.Import(packagePath, v1alpha1.ImageInfo{
 		HubPassword: os.Getenv("PASS"),
 		Namespace:   "test",
 		HubURL:      "image.goodrain.com",
@@ -41,3 +63,151 @@ func TestImport(t *testing.T) {
 	}
 	t.Logf("%+v", info)
 }
+
+// writeTrustedKey write the public key of a new ed25519 key pair, it returns the private key
+func writeTrustedKey(t *testing.T, file string) ed25519.PrivateKey {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+func TestVerifyPackageSignature(t *testing.T) {
+	keyFile := path.Join(t.TempDir(), "cosign.pub")
+	trusted := writeTrustedKey(t, keyFile)
+	_, untrusted, _ := ed25519.GenerateKey(rand.Reader)
+	dir := t.TempDir()
+	manifest := []byte(`{"files":[]}`)
+	ioutil.WriteFile(path.Join(dir, export.ManifestFileName), manifest, 0644)
+
+	im, err := New(logrus.StandardLogger(), nil, nil, t.TempDir(), WithRegistryClient(t.TempDir()), WithSignatureVerification(SignatureRequired, keyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := im.(*ramImport)
+	var sigErr *SignatureError
+	if err := r.verifyPackage(context.Background(), dir); !errors.As(err, &sigErr) {
+		t.Errorf("an unsigned package should be rejected, got %v", err)
+	}
+	for _, c := range []struct {
+		key  ed25519.PrivateKey
+		pass bool
+	}{{trusted, true}, {untrusted, false}} {
+		signature, _ := sign.Sign(c.key, manifest)
+		ioutil.WriteFile(path.Join(dir, export.SignatureFileName), signature, 0644)
+		if err := r.verifyPackage(context.Background(), dir); (err == nil) != c.pass {
+			t.Errorf("expected the package verified %v, got %v", c.pass, err)
+		}
+	}
+	r.signatureMode = SignatureWarn
+	if err := r.verifyPackage(context.Background(), dir); err != nil {
+		t.Errorf("an untrusted package should be imported with a warning, got %v", err)
+	}
+
+	signature, _ := sign.Sign(trusted, manifest)
+	ioutil.WriteFile(path.Join(dir, export.SignatureFileName), signature, 0644)
+	ioutil.WriteFile(path.Join(dir, "evil.tar"), []byte("evil"), 0644)
+	r.signatureMode = SignatureRequired
+	var integrityErr *export.IntegrityError
+	if err := r.verifyPackage(context.Background(), dir); !errors.As(err, &integrityErr) || integrityErr.Path != "evil.tar" {
+		t.Errorf("an image archive added after signing should be rejected, got %v", err)
+	}
+
+	if _, err := New(logrus.StandardLogger(), nil, nil, t.TempDir(), WithRegistryClient(t.TempDir()), WithSignatureVerification(SignatureRequired)); err == nil {
+		t.Errorf("the required verification without trusted keys should be rejected")
+	}
+}
+
+func TestAssembleDeltaRequiresBase(t *testing.T) {
+	dir := t.TempDir()
+	manifest, _ := json.Marshal(export.PackageManifest{Delta: &export.PackageDelta{BaseAppVersion: "1.0"}})
+	ioutil.WriteFile(path.Join(dir, export.ManifestFileName), manifest, 0644)
+	r := &ramImport{logger: logrus.StandardLogger(), homeDir: t.TempDir()}
+	if err := r.assembleDelta(context.Background(), dir); err == nil {
+		t.Errorf("a delta package without the base package should be rejected")
+	}
+	r.basePackage = path.Join(t.TempDir(), "missing.tar.gz")
+	if err := r.assembleDelta(context.Background(), dir); err == nil {
+		t.Errorf("a missing base package should be rejected")
+	}
+}
+
+func TestImportCanceled(t *testing.T) {
+	metadata, _ := json.Marshal(v1alpha1.RainbondApplicationConfig{AppName: "demo", AppVersion: "1.0"})
+	packageFile := writePackage(t, map[string][]byte{
+		"demo-1.0-ram/metadata.json":        metadata,
+		"demo-1.0-ram/component-images.tar": nil,
+	})
+	homeDir := path.Join(t.TempDir(), "import")
+	r := &ramImport{logger: logrus.StandardLogger(), imageClient: &fakeImageClient{}, homeDir: homeDir}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.ImportWithContext(ctx, packageFile, v1alpha1.ImageInfo{HubURL: "goodrain.me"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if _, err := os.Stat(homeDir); !os.IsNotExist(err) {
+		t.Errorf("the import dir is not removed")
+	}
+
+	// the dir of a resumable import is kept
+	r.resume = true
+	if _, err := r.ImportWithContext(ctx, packageFile, v1alpha1.ImageInfo{HubURL: "goodrain.me"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if _, err := os.Stat(path.Join(homeDir, CheckpointFileName)); err != nil {
+		t.Errorf("the checkpoint of the resumable import is removed: %v", err)
+	}
+}
+
+func TestImportImageLayout(t *testing.T) {
+	ram := v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{{ServiceCname: "web", ComponentKey: "web", ServiceShareID: "web", ShareImage: "goodrain.me/web:1.0"}},
+		Plugins:    []*v1alpha1.Plugin{{PluginName: "mesh", PluginKey: "mesh", ShareImage: "goodrain.me/mesh:1.0"}},
+	}
+	metadata, _ := json.Marshal(ram)
+	// the images of the package are merged like the export with the image layout does
+	dir := t.TempDir()
+	var archives []string
+	for _, name := range []string{"goodrain.me/web:1.0", "goodrain.me/mesh:1.0"} {
+		var archive bytes.Buffer
+		tw := tar.NewWriter(&archive)
+		manifest, _ := json.Marshal([]map[string]interface{}{{"Config": "config.json", "RepoTags": []string{name}, "Layers": []string{"base/layer.tar"}}})
+		writeTar(t, tw, map[string][]byte{"config.json": []byte(`{"os":"linux","architecture":"amd64","env":["IMAGE=` + name + `"]}`), "base/layer.tar": []byte("base-layer"), "manifest.json": manifest})
+		tw.Close()
+		file := path.Join(dir, path.Base(name)+".tar")
+		ioutil.WriteFile(file, archive.Bytes(), 0644)
+		archives = append(archives, file)
+	}
+	merged := path.Join(dir, export.ImagesFileName)
+	if err := image.MergeArchives(context.Background(), merged, "", archives...); err != nil {
+		t.Fatal(err)
+	}
+	images, _ := ioutil.ReadFile(merged)
+	packageFile := writePackage(t, map[string][]byte{
+		"demo-1.0-ram/metadata.json": metadata,
+		"demo-1.0-ram/images.tar":    images,
+	})
+
+	client := &fakeImageClient{}
+	r := &ramImport{logger: logrus.StandardLogger(), imageClient: client, homeDir: t.TempDir()}
+	result, err := r.ImportWithContext(context.Background(), packageFile, v1alpha1.ImageInfo{HubURL: "hub.example.com", Namespace: "demo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.loads != 1 || len(client.pushes) != 2 {
+		t.Errorf("expected images.tar loaded once and both images pushed, got %d loads and pushes %v", client.loads, client.pushes)
+	}
+	if com := result.Components[0]; !strings.HasPrefix(com.ShareImage, "hub.example.com/demo/web") {
+		t.Errorf("unexpected image of the component %s", com.ShareImage)
+	}
+	if plugin := result.Plugins[0]; !strings.HasPrefix(plugin.ShareImage, "hub.example.com/demo/mesh") {
+		t.Errorf("unexpected image of the plugin %s", plugin.ShareImage)
+	}
+}
@@ -0,0 +1,402 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/goodrain/rainbond-oam/pkg/export"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
+	"github.com/goodrain/rainbond-oam/pkg/util/docker"
+	"github.com/goodrain/rainbond-oam/pkg/util/image"
+	"github.com/goodrain/rainbond-oam/pkg/util/zip"
+)
+
+// maxManifestSize the max size of the metadata read into memory
+const maxManifestSize = 8 << 20
+
+// DryRunImport check an app package without changing the home dir or the registry.
+// The importer created by New implements it.
+type DryRunImport interface {
+	DryRun(ctx context.Context, filePath string, hubInfo v1alpha1.ImageInfo) (*PreflightReport, error)
+}
+
+// PreflightReport the report of checking an app package before importing it
+type PreflightReport struct {
+	AppName         string           `json:"app_name"`
+	AppVersion      string           `json:"app_version"`
+	TemplateVersion string           `json:"template_version"`
+	Components      []PreflightImage `json:"components"`
+	Plugins         []PreflightImage `json:"plugins"`
+	// MissingImages the images referenced by the template but not found in the package
+	MissingImages []string `json:"missing_images,omitempty"`
+	// TotalBytes the size of the configs and layers of the images to push, the blobs
+	// shared by several images are counted once
+	TotalBytes int64                     `json:"total_bytes"`
+	Problems   v1alpha1.ValidationErrors `json:"problems,omitempty"`
+	// Verified whether the package has a manifest and all the files match it
+	Verified        bool                     `json:"verified"`
+	IntegrityErrors []*export.IntegrityError `json:"integrity_errors,omitempty"`
+	// SignatureVerified whether the package is signed by a trusted key, SignatureProblem
+	// why it is not. They are empty if the signature verification is off.
+	SignatureVerified bool   `json:"signature_verified"`
+	SignatureRequired bool   `json:"signature_required"`
+	SignatureProblem  string `json:"signature_problem,omitempty"`
+	// BaseAppVersion the version of the base package if it's a delta package, the image
+	// layers taken from the base package are not counted in TotalBytes
+	BaseAppVersion string `json:"base_app_version,omitempty"`
+}
+
+// Ready whether the package can be imported
+func (r *PreflightReport) Ready() bool {
+	if r.SignatureRequired && !r.SignatureVerified {
+		return false
+	}
+	return len(r.MissingImages) == 0 && len(r.Problems) == 0 && len(r.IntegrityErrors) == 0
+}
+
+// PreflightImage the image of a component or plugin
+type PreflightImage struct {
+	Name string `json:"name"`
+	// Image the image in the package, Target the image it is pushed as
+	Image   string `json:"image"`
+	Target  string `json:"target,omitempty"`
+	Present bool   `json:"present"`
+	Size    int64  `json:"size"`
+}
+
+// DryRun read the metadata and the image manifests of the package, the images are
+// neither loaded nor pushed
+func (r *ramImport) DryRun(ctx context.Context, filePath string, hubInfo v1alpha1.ImageInfo) (*PreflightReport, error) {
+	if hubInfo.HubURL == "" && len(r.rewriteRules) == 0 {
+		return nil, fmt.Errorf("must define hub url")
+	}
+	rewriter, err := docker.NewRewriter(hubInfo, r.rewriteRules...)
+	if err != nil {
+		return nil, err
+	}
+	r.logger.Infof("start check app file %s", filePath)
+	scan, err := scanPackage(ctx, filePath)
+	if err != nil {
+		r.logger.Errorf("read app file %s failure %s", filePath, err.Error())
+		return nil, err
+	}
+	if scan.metadata == nil {
+		return nil, fmt.Errorf("metadata.json not found in app file %s", filePath)
+	}
+	var ram v1alpha1.RainbondApplicationConfig
+	if err := json.Unmarshal(scan.metadata, &ram); err != nil {
+		return nil, fmt.Errorf("Failed to read meta file : %v", err)
+	}
+	report := &PreflightReport{
+		AppName:         ram.AppName,
+		AppVersion:      ram.AppVersion,
+		TemplateVersion: ram.TempleteVersion,
+		Problems:        ram.Validate(),
+	}
+	if r.verifySignature() {
+		report.SignatureRequired = r.signatureMode == SignatureRequired
+		if err := r.checkSignature(scan.manifest, scan.signature); err != nil {
+			report.SignatureProblem = err.Error()
+		} else {
+			report.SignatureVerified = true
+		}
+	}
+	if scan.manifest != nil {
+		var manifest export.PackageManifest
+		if err := json.Unmarshal(scan.manifest, &manifest); err != nil {
+			report.IntegrityErrors = []*export.IntegrityError{{Path: export.ManifestFileName, Reason: err.Error()}}
+		} else {
+			report.IntegrityErrors = scan.verify(&manifest)
+		}
+		report.Verified = len(report.IntegrityErrors) == 0
+		if manifest.Delta != nil {
+			report.BaseAppVersion = manifest.Delta.BaseAppVersion
+		}
+	}
+	missing := make(map[string]bool)
+	counted := make(map[string]bool)
+	check := func(field, name, source string) PreflightImage {
+		img := PreflightImage{Name: name, Image: source}
+		if source == "" {
+			return img
+		}
+		if target, _, err := rewriter.Rewrite(source); err != nil {
+			report.Problems = append(report.Problems, v1alpha1.ValidationError{Field: field + ".share_image", Message: "invalid image: " + err.Error()})
+		} else {
+			img.Target = target
+		}
+		blobs := scan.lookup(source)
+		if blobs == nil {
+			if !missing[source] {
+				missing[source] = true
+				report.MissingImages = append(report.MissingImages, source)
+			}
+			return img
+		}
+		img.Present = true
+		for key, size := range blobs {
+			img.Size += size
+			if !counted[key] {
+				counted[key] = true
+				report.TotalBytes += size
+			}
+		}
+		return img
+	}
+	for i, com := range ram.Components {
+		report.Components = append(report.Components, check(fmt.Sprintf("apps[%d]", i), com.ServiceCname, com.ShareImage))
+	}
+	for i, plugin := range ram.Plugins {
+		report.Plugins = append(report.Plugins, check(fmt.Sprintf("plugins[%d]", i), plugin.PluginName, plugin.ShareImage))
+	}
+	r.logger.Infof("check app file %s success, %d images missing, %d problems, %d corrupted files", filePath, len(report.MissingImages), len(report.Problems), len(report.IntegrityErrors))
+	return report, nil
+}
+
+// packageScan the metadata and the images found in an app package
+type packageScan struct {
+	metadata  []byte
+	manifest  []byte
+	signature []byte
+	// files the size and checksum of the files by the path in the top dir
+	files map[string]export.PackageFile
+	// images the blobs of the images by the normalized name, the blobs are keyed by
+	// the archive and their path in it
+	images map[string]map[string]int64
+}
+
+// scanPackage stream the tarball or zip package, nothing is written to disk. A split
+// tarball package is read from its parts.
+func scanPackage(ctx context.Context, filePath string) (*packageScan, error) {
+	s := &packageScan{
+		images: make(map[string]map[string]int64),
+		files:  make(map[string]export.PackageFile),
+	}
+	isZip, err := archive.IsZipFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if isZip && !export.IsVolumeFile(filePath) {
+		reader, err := zip.OpenDirectReader(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening archive: %v", err)
+		}
+		defer reader.Close()
+		for _, file := range reader.File {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if file.Mode().IsDir() {
+				continue
+			}
+			rc, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = s.entry(file.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+	var file io.ReadCloser
+	if export.IsVolumeFile(filePath) {
+		// the parts of a split package are read one after another
+		file, _, err = export.OpenVolumes(ctx, filePath)
+	} else {
+		file, err = os.Open(filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := s.tarball(ctx, file); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// tarball read the entries of the tarball package
+func (s *packageScan) tarball(ctx context.Context, r io.Reader) error {
+	dr, err := archive.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar failure %s", err.Error())
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+		case tar.TypeDir:
+			continue
+		default:
+			// the links are never listed by the manifest
+			s.addFile(hdr.Name, export.PackageFile{Size: -1})
+			continue
+		}
+		if err := s.entry(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *packageScan) entry(name string, r io.Reader) error {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	d := export.NewDigester()
+	r = io.TeeReader(r, d)
+	// the metadata and manifest of the app are in the top dir of the package
+	topLevel := strings.Count(name, "/") == 1
+	switch {
+	case path.Base(name) == "metadata.json" && topLevel && s.metadata == nil:
+		metadata, err := ioutil.ReadAll(io.LimitReader(r, maxManifestSize))
+		if err != nil {
+			return fmt.Errorf("read %s failure %s", name, err.Error())
+		}
+		s.metadata = metadata
+	case path.Base(name) == export.ManifestFileName && topLevel && s.manifest == nil:
+		manifest, err := ioutil.ReadAll(io.LimitReader(r, maxManifestSize))
+		if err != nil {
+			return fmt.Errorf("read %s failure %s", name, err.Error())
+		}
+		s.manifest = manifest
+	case path.Base(name) == export.SignatureFileName && topLevel && s.signature == nil:
+		signature, err := ioutil.ReadAll(io.LimitReader(r, maxManifestSize))
+		if err != nil {
+			return fmt.Errorf("read %s failure %s", name, err.Error())
+		}
+		s.signature = signature
+	case strings.HasSuffix(name, ".tar"):
+		if err := s.imageArchive(name, r); err != nil {
+			return fmt.Errorf("read image archive %s failure %s", name, err.Error())
+		}
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return fmt.Errorf("read %s failure %s", name, err.Error())
+	}
+	s.addFile(name, export.PackageFile{Size: d.Size(), SHA256: d.Sum()})
+	return nil
+}
+
+// addFile record the file of the package, the path is relative to the top dir of the package
+func (s *packageScan) addFile(name string, file export.PackageFile) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if i := strings.Index(name, "/"); i >= 0 {
+		file.Path = name[i+1:]
+		s.files[file.Path] = file
+	}
+}
+
+// verify check the files against the package manifest
+func (s *packageScan) verify(manifest *export.PackageManifest) []*export.IntegrityError {
+	var errs []*export.IntegrityError
+	listed := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		listed[path.Clean(file.Path)] = true
+		actual, ok := s.files[path.Clean(file.Path)]
+		if !ok {
+			errs = append(errs, &export.IntegrityError{Path: file.Path, Reason: "the file is missing"})
+			continue
+		}
+		var integrityErr *export.IntegrityError
+		if errors.As(file.Compare(actual.Size, actual.SHA256), &integrityErr) {
+			errs = append(errs, integrityErr)
+		}
+	}
+	var unlisted []string
+	for name := range s.files {
+		if !listed[name] && export.IsListedFile(name) {
+			unlisted = append(unlisted, name)
+		}
+	}
+	sort.Strings(unlisted)
+	for _, name := range unlisted {
+		errs = append(errs, &export.IntegrityError{Path: name, Reason: "the file is not in the manifest"})
+	}
+	return errs
+}
+
+// imageArchive read the images of an archive written by docker save or containerd export
+func (s *packageScan) imageArchive(archive string, r io.Reader) error {
+	images, err := image.ReadArchive(r)
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		blobs := make(map[string]int64, len(img.Blobs))
+		for p, size := range img.Blobs {
+			blobs[archive+":"+p] = size
+		}
+		s.addImage(img.Name, blobs)
+	}
+	return nil
+}
+
+func (s *packageScan) addImage(name string, blobs map[string]int64) {
+	name = normalizeName(name)
+	if s.images[name] == nil {
+		s.images[name] = make(map[string]int64)
+	}
+	for key, size := range blobs {
+		s.images[name][key] = size
+	}
+}
+
+// lookup the blobs of the image, the images saved with the name before v5.3 are found too
+func (s *packageScan) lookup(source string) map[string]int64 {
+	if blobs, ok := s.images[normalizeName(source)]; ok {
+		return blobs
+	}
+	if old, err := docker.GetOldSaveImageName(source, false); err == nil {
+		return s.images[normalizeName(old)]
+	}
+	return nil
+}
+
+func normalizeName(name string) string {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return name
+	}
+	return reference.TagNameOnly(named).String()
+}
@@ -0,0 +1,156 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package localimport
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/export"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/sirupsen/logrus"
+)
+
+func writeTar(t *testing.T, w *tar.Writer, files map[string][]byte) {
+	for name, body := range files {
+		if err := w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writePackage write the files into a tar.gz package
+func writePackage(t *testing.T, files map[string][]byte) string {
+	packageFile := path.Join(t.TempDir(), "demo-1.0-ram.tar.gz")
+	file, err := os.Create(packageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gw := gzip.NewWriter(file)
+	tw := tar.NewWriter(gw)
+	writeTar(t, tw, files)
+	tw.Close()
+	gw.Close()
+	return packageFile
+}
+
+func TestDryRun(t *testing.T) {
+	ram := v1alpha1.RainbondApplicationConfig{
+		AppName:         "demo",
+		AppVersion:      "1.0",
+		TempleteVersion: "v2",
+		Components: []*v1alpha1.Component{
+			{ServiceCname: "web", ComponentKey: "web", ServiceShareID: "web", ShareImage: "goodrain.me/nginx:1.19"},
+			{ServiceCname: "api", ComponentKey: "api", ServiceShareID: "api", ShareImage: "goodrain.me/nginx:1.19"},
+		},
+		Plugins: []*v1alpha1.Plugin{{PluginName: "mesh", PluginKey: "mesh", ShareImage: "goodrain.me/mesh:1.0"}},
+	}
+	metadata, _ := json.Marshal(ram)
+	manifest := []byte(`[{"Config":"config.json","RepoTags":["goodrain.me/nginx:1.19"],"Layers":["layer/layer.tar"]}]`)
+	var images bytes.Buffer
+	tw := tar.NewWriter(&images)
+	writeTar(t, tw, map[string][]byte{
+		"config.json":     []byte("{}"),
+		"layer/layer.tar": bytes.Repeat([]byte("a"), 1024),
+		"manifest.json":   manifest,
+	})
+	tw.Close()
+
+	packageFile := writePackage(t, map[string][]byte{
+		"demo-1.0-ram/metadata.json":        metadata,
+		"demo-1.0-ram/component-images.tar": images.Bytes(),
+		"demo-1.0-ram/plugin-images.tar":    nil,
+	})
+
+	homeDir := path.Join(t.TempDir(), "home")
+	r := &ramImport{logger: logrus.StandardLogger(), homeDir: homeDir}
+	report, err := r.DryRun(context.Background(), packageFile, v1alpha1.ImageInfo{HubURL: "hub.example.com", Namespace: "demo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(homeDir); !os.IsNotExist(err) {
+		t.Errorf("the home dir should not be touched")
+	}
+	if report.TemplateVersion != "v2" || len(report.Components) != 2 || len(report.Plugins) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	web := report.Components[0]
+	if !web.Present || web.Target != "hub.example.com/demo/nginx:1.19" || web.Size != 1026 {
+		t.Errorf("unexpected component image %+v", web)
+	}
+	if report.TotalBytes != 1026 {
+		t.Errorf("the shared image should be counted once, got %d bytes", report.TotalBytes)
+	}
+	if report.Plugins[0].Present || len(report.MissingImages) != 1 || report.MissingImages[0] != "goodrain.me/mesh:1.0" {
+		t.Errorf("the plugin image should be missing: %+v", report)
+	}
+	if report.Ready() {
+		t.Errorf("the package with missing images should not be ready")
+	}
+
+	// the split package is read from its parts
+	indexPath, err := export.SplitPackage(context.Background(), packageFile, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	split, err := r.DryRun(context.Background(), indexPath, v1alpha1.ImageInfo{HubURL: "hub.example.com", Namespace: "demo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if split.TotalBytes != report.TotalBytes || len(split.MissingImages) != 1 {
+		t.Errorf("unexpected report of the split package %+v", split)
+	}
+}
+
+func TestDryRunIntegrity(t *testing.T) {
+	metadata, _ := json.Marshal(v1alpha1.RainbondApplicationConfig{AppName: "demo", AppVersion: "1.0"})
+	manifest, _ := json.Marshal(export.PackageManifest{Files: []export.PackageFile{
+		{Path: "metadata.json", Size: int64(len(metadata)), SHA256: fmt.Sprintf("%x", sha256.Sum256(metadata))},
+		{Path: "component-images.tar", Size: 2048, SHA256: fmt.Sprintf("%x", sha256.Sum256(nil))},
+	}})
+	packageFile := writePackage(t, map[string][]byte{
+		"demo-1.0-ram/metadata.json":         metadata,
+		"demo-1.0-ram/package-manifest.json": manifest,
+		"demo-1.0-ram/component-images.tar":  nil,
+		"demo-1.0-ram/evil.tar":              nil,
+	})
+	r := &ramImport{logger: logrus.StandardLogger(), homeDir: t.TempDir()}
+	report, err := r.DryRun(context.Background(), packageFile, v1alpha1.ImageInfo{HubURL: "hub.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Verified || len(report.IntegrityErrors) != 2 || report.IntegrityErrors[0].Path != "component-images.tar" {
+		t.Fatalf("expected the truncated image archive reported, got %+v", report.IntegrityErrors)
+	}
+	if unlisted := report.IntegrityErrors[1]; unlisted.Path != "evil.tar" || unlisted.Reason != "the file is not in the manifest" {
+		t.Errorf("expected the file that is not in the manifest reported, got %+v", unlisted)
+	}
+}
@@ -31,15 +31,21 @@ import (
 type containerWorkloadBuilder struct {
 	com     v1alpha1.Component
 	plugins []*v1alpha1.Plugin
+	deps    []*v1alpha1.Component
 	output  []v1alpha2.DataOutput
+	input   []v1alpha2.DataInput
 }
 
 func (c *containerWorkloadBuilder) Build() runtime.RawExtension {
 	oamOS := v1alpha2.OperatingSystemLinux
 	oamCPU := v1alpha2.CPUArchitectureAMD64
 	var cw = &v1alpha2.ContainerizedWorkload{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha2.SchemeGroupVersion.String(),
+			Kind:       v1alpha2.ContainerizedWorkloadKind,
+		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        c.com.ServiceCname,
+			Name:        ComponentName(c.com),
 			Labels:      map[string]string{},
 			Annotations: map[string]string{},
 		},
@@ -59,12 +65,16 @@ func (c *containerWorkloadBuilder) Output() []v1alpha2.DataOutput {
 	return c.output
 }
 
+func (c *containerWorkloadBuilder) Input() []v1alpha2.DataInput {
+	return c.input
+}
+
 func (c *containerWorkloadBuilder) buildContainers() []v1alpha2.Container {
 	com := c.com
 	var containers []v1alpha2.Container
 	mainContainer := v1alpha2.Container{
-		Name:  com.ServiceName,
-		Image: com.Image,
+		Name:  ComponentName(com),
+		Image: componentImage(com),
 		Resources: &v1alpha2.ContainerResources{
 			Memory: v1alpha2.MemoryResources{
 				Required: NewMemoryQuantity(com.Memory),
@@ -74,7 +84,7 @@ func (c *containerWorkloadBuilder) buildContainers() []v1alpha2.Container {
 			},
 			Volumes: c.buildVolumes(com.ServiceVolumeMapList, com.MntReleationList),
 		},
-		Command:         strings.Split(com.Cmd, " "),
+		Command:         strings.Fields(com.Cmd),
 		Environment:     c.buildEnv(com.Envs, com.ServiceConnectInfoMapList, true),
 		ConfigFiles:     c.buildConfigFile(com.ServiceVolumeMapList),
 		Ports:           c.buildPorts(com.Ports),
@@ -130,21 +140,42 @@ func (c *containerWorkloadBuilder) buildConfigFile(volumes v1alpha1.ComponentVol
 }
 
 func (c *containerWorkloadBuilder) buildEnv(envs, connect []v1alpha1.ComponentEnv, insetOutput bool) (re []v1alpha2.ContainerEnvVar) {
-	for _, env := range envs {
+	for i := range envs {
+		env := envs[i]
 		re = append(re, v1alpha2.ContainerEnvVar{
 			Name:  env.AttrName,
 			Value: &env.AttrValue,
 		})
 	}
-	for _, out := range connect {
+	for i := range connect {
+		out := connect[i]
 		re = append(re, v1alpha2.ContainerEnvVar{
 			Name:  out.AttrName,
 			Value: &out.AttrValue,
 		})
 		if insetOutput {
 			c.output = append(c.output, v1alpha2.DataOutput{
-				Name:      out.AttrName,
-				FieldPath: fmt.Sprintf("spec.container[0].env[%d].value", len(re)-1),
+				Name:      OutputName(c.com, out.AttrName),
+				FieldPath: fmt.Sprintf("spec.containers[0].env[%d].value", len(re)-1),
+			})
+		}
+	}
+	if !insetOutput {
+		return
+	}
+	// the connection information of the dependent components, the values are set by data inputs
+	for _, dep := range c.deps {
+		for i := range dep.ServiceConnectInfoMapList {
+			in := dep.ServiceConnectInfoMapList[i]
+			re = append(re, v1alpha2.ContainerEnvVar{
+				Name:  in.AttrName,
+				Value: &in.AttrValue,
+			})
+			c.input = append(c.input, v1alpha2.DataInput{
+				ValueFrom: v1alpha2.DataInputValueFrom{
+					DataOutputName: OutputName(*dep, in.AttrName),
+				},
+				ToFieldPaths: []string{fmt.Sprintf("spec.containers[0].env[%d].value", len(re)-1)},
 			})
 		}
 	}
@@ -165,13 +196,12 @@ func (c *containerWorkloadBuilder) buildPorts(ports []v1alpha1.ComponentPort) (r
 
 //TODO: build secret
 func (c *containerWorkloadBuilder) buildImagePullSecret(info v1alpha1.ImageInfo) *string {
-	var secret string
-	return &secret
+	return nil
 }
 
 func (c *containerWorkloadBuilder) buildLivenessProbe(probes []v1alpha1.ComponentProbe) *v1alpha2.ContainerHealthProbe {
 	for _, probe := range probes {
-		if probe.Mode == "livebess" {
+		if probe.Mode == "liveness" {
 			return createProbe(probe)
 		}
 	}
@@ -190,7 +220,7 @@ func (c *containerWorkloadBuilder) buildReadinessProbe(probes []v1alpha1.Compone
 func (c *containerWorkloadBuilder) buildPluginContainer(plugin v1alpha1.Plugin, pluginConfig v1alpha1.ComponentPluginConfig, com v1alpha1.Component) v1alpha2.Container {
 	return v1alpha2.Container{
 		Name:  plugin.PluginName,
-		Image: plugin.Image,
+		Image: pluginImage(plugin),
 		Resources: &v1alpha2.ContainerResources{
 			Memory: v1alpha2.MemoryResources{
 				Required: NewMemoryQuantity(pluginConfig.MemoryRequired),
@@ -200,7 +230,6 @@ func (c *containerWorkloadBuilder) buildPluginContainer(plugin v1alpha1.Plugin,
 			},
 			Volumes: c.buildVolumes(com.ServiceVolumeMapList, com.MntReleationList),
 		},
-		Command:         strings.Split(com.Cmd, " "),
 		Environment:     c.buildEnv(c.com.Envs, c.com.ServiceConnectInfoMapList, false),
 		ConfigFiles:     c.buildConfigFile(c.com.ServiceVolumeMapList),
 		ImagePullSecret: c.buildImagePullSecret(plugin.PluginImage),
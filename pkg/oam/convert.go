@@ -19,30 +19,69 @@
 package oam
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
 	"github.com/crossplane/oam-kubernetes-runtime/apis/core/v1alpha2"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
 )
 
 type builder struct {
-	oamApp *v1alpha2.ApplicationConfiguration
-	ram    v1alpha1.RainbondApplicationConfig
+	oamApp     *v1alpha2.ApplicationConfiguration
+	components []v1alpha2.Component
+	ram        v1alpha1.RainbondApplicationConfig
+}
+
+//Application oam application model, the application configuration and the components it references
+type Application struct {
+	Configuration *v1alpha2.ApplicationConfiguration
+	Components    []v1alpha2.Component
+}
+
+//YAML return the components and the application configuration as a multi-document yaml
+func (a *Application) YAML() ([]byte, error) {
+	var docs [][]byte
+	for i := range a.Components {
+		body, err := yaml.Marshal(&a.Components[i])
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, body)
+	}
+	body, err := yaml.Marshal(a.Configuration)
+	if err != nil {
+		return nil, err
+	}
+	docs = append(docs, body)
+	return bytes.Join(docs, []byte("---\n")), nil
 }
 
 //Builder oam application model builder
 type Builder interface {
 	// build oam application
-	Build() *v1alpha2.ApplicationConfiguration
+	Build() (*Application, error)
 }
 
 //WorkloadBuilder workload builder
 type WorkloadBuilder interface {
 	Build() runtime.RawExtension
 	Output() []v1alpha2.DataOutput
+	Input() []v1alpha2.DataInput
 	Kind() string
 }
 
+//resourceBuilder a workload builder whose workload needs other kubernetes objects,
+//every object is built as a component of the application
+type resourceBuilder interface {
+	Resources() []runtime.Object
+}
+
 //NewBuilder new oam model builder
 func NewBuilder(ram v1alpha1.RainbondApplicationConfig) Builder {
 	var oam v1alpha2.ApplicationConfiguration
@@ -54,84 +93,189 @@ func NewBuilder(ram v1alpha1.RainbondApplicationConfig) Builder {
 
 //NewWorkloadBuilder new workload builder
 func NewWorkloadBuilder(com v1alpha1.Component, plugins []*v1alpha1.Plugin) WorkloadBuilder {
+	return NewWorkloadBuilderWithDeps(com, plugins, nil)
+}
+
+//NewWorkloadBuilderWithDeps new workload builder, deps are the components the component depends on,
+//their connection information is injected into the main container.
+func NewWorkloadBuilderWithDeps(com v1alpha1.Component, plugins []*v1alpha1.Plugin, deps []*v1alpha1.Component) WorkloadBuilder {
+	return newWorkloadBuilder(com, plugins, deps, nil)
+}
+
+//newWorkloadBuilder shares are the components whose volumes are mounted by the component
+func newWorkloadBuilder(com v1alpha1.Component, plugins []*v1alpha1.Plugin, deps, shares []*v1alpha1.Component) WorkloadBuilder {
 	switch com.DeployType {
 	case v1alpha1.StateMultipleDeployType, v1alpha1.StateSingletonDeployType:
 		return &statefulWorkloadBuilder{
 			com:     com,
 			plugins: plugins,
+			deps:    deps,
+			shares:  shares,
 		}
 	case v1alpha1.StatelessMultipleDeployType, v1alpha1.StatelessSingletionDeployType:
 		return &containerWorkloadBuilder{
 			com:     com,
 			plugins: plugins,
+			deps:    deps,
 		}
 	default:
 		return &containerWorkloadBuilder{
 			com:     com,
 			plugins: plugins,
+			deps:    deps,
 		}
 	}
 }
 
-func (b *builder) Build() *v1alpha2.ApplicationConfiguration {
-	return b.oamApp
+func (b *builder) Build() (*Application, error) {
+	b.buildApplication()
+	if err := b.buildComponent(); err != nil {
+		return nil, err
+	}
+	if err := b.buildTrait(); err != nil {
+		return nil, err
+	}
+	return &Application{
+		Configuration: b.oamApp,
+		Components:    b.components,
+	}, nil
 }
 
 func (b *builder) buildApplication() {
+	b.oamApp.TypeMeta = metav1.TypeMeta{
+		APIVersion: v1alpha2.SchemeGroupVersion.String(),
+		Kind:       v1alpha2.ApplicationConfigurationKind,
+	}
 	b.oamApp.Name = b.ram.AppName
+	b.oamApp.Annotations = map[string]string{
+		"app.oam.dev/version": b.ram.AppVersion,
+	}
 }
 
-func (b *builder) buildComponent() {
+func (b *builder) buildComponent() error {
 	var components []v1alpha2.Component
 	var configurationComponents []v1alpha2.ApplicationConfigurationComponent
+	var resources []v1alpha2.Component
+	var resourceComponents []v1alpha2.ApplicationConfigurationComponent
 	for i := range b.ram.Components {
 		rcom := b.ram.Components[i]
-		builder := NewWorkloadBuilder(*rcom, b.ram.Plugins)
-		cw := builder.Build()
-		output := builder.Output()
-		component := v1alpha2.Component{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:        rcom.ServiceCname,
-				Labels:      map[string]string{},
-				Annotations: map[string]string{},
-			},
-			Spec: v1alpha2.ComponentSpec{
-				Workload: cw,
-			},
+		var deps []*v1alpha1.Component
+		for _, dep := range rcom.DepServiceMapList {
+			if depCom := b.getComponent(dep.DepServiceKey); depCom != nil {
+				deps = append(deps, depCom)
+			}
 		}
-		components = append(components, component)
-		var acc = v1alpha2.ApplicationConfigurationComponent{
-			ComponentName: component.GetName(),
-			DataOutputs:   output,
+		shares, err := b.shareComponents(rcom)
+		if err != nil {
+			return err
+		}
+		builder := newWorkloadBuilder(*rcom, b.ram.Plugins, deps, shares)
+		component, err := newComponent(ComponentName(*rcom), builder.Build())
+		if err != nil {
+			return fmt.Errorf("marshal component %s workload failure %s", rcom.ServiceCname, err.Error())
 		}
+		components = append(components, *component)
 		// Handle dependencies between components
-		for _, dep := range rcom.DepServiceMapList {
-			for _, env := range b.getDepComponentConnectionInfo(dep.DepServiceKey) {
-				acc.DataInputs = append(acc.DataInputs, v1alpha2.DataInput{
-					ValueFrom: v1alpha2.DataInputValueFrom{
-						DataOutputName: env.AttrName,
-					},
-					//TODO:
-					ToFieldPaths: func() []string {
-						return []string{""}
-					}(),
-				})
+		configurationComponents = append(configurationComponents, v1alpha2.ApplicationConfigurationComponent{
+			ComponentName: component.GetName(),
+			DataOutputs:   builder.Output(),
+			DataInputs:    builder.Input(),
+		})
+		if rb, ok := builder.(resourceBuilder); ok {
+			for _, object := range rb.Resources() {
+				accessor, err := meta.Accessor(object)
+				if err != nil {
+					return fmt.Errorf("component %s resource has no metadata %s", rcom.ServiceCname, err.Error())
+				}
+				name := fmt.Sprintf("%s-%s", strings.ToLower(object.GetObjectKind().GroupVersionKind().Kind), accessor.GetName())
+				resource, err := newComponent(name, runtime.RawExtension{Object: object})
+				if err != nil {
+					return fmt.Errorf("marshal component %s resource failure %s", rcom.ServiceCname, err.Error())
+				}
+				resources = append(resources, *resource)
+				resourceComponents = append(resourceComponents, v1alpha2.ApplicationConfigurationComponent{ComponentName: name})
 			}
 		}
-		configurationComponents = append(configurationComponents, acc)
 	}
-	b.oamApp.Spec.Components = configurationComponents
+	// the resources follow the workloads, so the components of the workloads keep the order of the ram components
+	b.components = append(components, resources...)
+	b.oamApp.Spec.Components = append(configurationComponents, resourceComponents...)
+	return nil
+}
+
+func newComponent(name string, workload runtime.RawExtension) (*v1alpha2.Component, error) {
+	raw, err := json.Marshal(workload.Object)
+	if err != nil {
+		return nil, err
+	}
+	workload.Raw = raw
+	return &v1alpha2.Component{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha2.SchemeGroupVersion.String(),
+			Kind:       v1alpha2.ComponentKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec: v1alpha2.ComponentSpec{
+			Workload: workload,
+		},
+	}, nil
+}
+
+//shareComponents the components whose volumes are mounted by the component, only the volumes
+//of state components can be mounted by state components
+func (b *builder) shareComponents(com *v1alpha1.Component) ([]*v1alpha1.Component, error) {
+	if com.DeployType != v1alpha1.StateMultipleDeployType && com.DeployType != v1alpha1.StateSingletonDeployType {
+		return nil, nil
+	}
+	var shares []*v1alpha1.Component
+	for _, share := range com.MntReleationList {
+		shareCom := b.getComponent(share.ShareServiceUUID)
+		if shareCom == nil {
+			return nil, fmt.Errorf("component %s mounts the volume %s of the unknown component %s", com.ServiceCname, share.VolumeName, share.ShareServiceUUID)
+		}
+		if shareCom.DeployType != v1alpha1.StateMultipleDeployType && shareCom.DeployType != v1alpha1.StateSingletonDeployType {
+			return nil, fmt.Errorf("state component %s can not mount the volume %s of the stateless component %s", com.ServiceCname, share.VolumeName, shareCom.ServiceCname)
+		}
+		shares = append(shares, shareCom)
+	}
+	return shares, nil
 }
 
-func (b *builder) getDepComponentConnectionInfo(componentKey string) []v1alpha1.ComponentEnv {
+func (b *builder) getComponent(componentKey string) *v1alpha1.Component {
 	for _, com := range b.ram.Components {
-		if com.ComponentKey == componentKey {
-			return com.ServiceConnectInfoMapList
+		if com.ComponentKey == componentKey || com.ServiceShareID == componentKey {
+			return com
 		}
 	}
 	return nil
 }
 
-func (b *builder) buildTrait() {
-
+// buildTrait scales every component to the minimum node count of its scaling rule
+func (b *builder) buildTrait() error {
+	for i, com := range b.ram.Components {
+		if com.ExtendMethodRule.MinNode < 1 {
+			continue
+		}
+		trait := &v1alpha2.ManualScalerTrait{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: v1alpha2.SchemeGroupVersion.String(),
+				Kind:       v1alpha2.ManualScalerTraitKind,
+			},
+			Spec: v1alpha2.ManualScalerTraitSpec{
+				ReplicaCount: int32(com.ExtendMethodRule.MinNode),
+			},
+		}
+		raw, err := json.Marshal(trait)
+		if err != nil {
+			return fmt.Errorf("marshal component %s trait failure %s", com.ServiceCname, err.Error())
+		}
+		b.oamApp.Spec.Components[i].Traits = append(b.oamApp.Spec.Components[i].Traits, v1alpha2.ComponentTrait{
+			Trait: runtime.RawExtension{Raw: raw, Object: trait},
+		})
+	}
+	return nil
 }
@@ -0,0 +1,186 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package oam
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/crossplane/oam-kubernetes-runtime/apis/core/v1alpha2"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+)
+
+func TestBuild(t *testing.T) {
+	ram := v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{
+			{
+				ServiceCname:      "web",
+				ComponentKey:      "web",
+				DeployType:        v1alpha1.StatelessMultipleDeployType,
+				ShareImage:        "nginx:1.19",
+				Envs:              []v1alpha1.ComponentEnv{{AttrName: "DEBUG", AttrValue: "1"}, {AttrName: "LANG", AttrValue: "C"}},
+				DepServiceMapList: []v1alpha1.ComponentDep{{DepServiceKey: "db"}},
+				ExtendMethodRule:  v1alpha1.ComponentExtendMethodRule{MinNode: 2},
+			},
+			{
+				ServiceCname:              "db",
+				ComponentKey:              "db",
+				DeployType:                v1alpha1.StatelessSingletionDeployType,
+				ShareImage:                "mysql:5.7",
+				ServiceConnectInfoMapList: []v1alpha1.ComponentEnv{{AttrName: "MYSQL_PASSWORD", AttrValue: "secret"}},
+			},
+		},
+	}
+	app, err := NewBuilder(ram).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Components) != 2 || len(app.Configuration.Spec.Components) != 2 {
+		t.Fatalf("expected 2 components, got %d", len(app.Components))
+	}
+	web := app.Configuration.Spec.Components[0]
+	db := app.Configuration.Spec.Components[1]
+	if len(db.DataOutputs) != 1 || len(web.DataInputs) != 1 {
+		t.Fatalf("unexpected data outputs %v and inputs %v", db.DataOutputs, web.DataInputs)
+	}
+	if web.DataInputs[0].ValueFrom.DataOutputName != db.DataOutputs[0].Name {
+		t.Errorf("data input %s does not reference the output %s", web.DataInputs[0].ValueFrom.DataOutputName, db.DataOutputs[0].Name)
+	}
+	if got := web.DataInputs[0].ToFieldPaths; len(got) != 1 || got[0] != "spec.containers[0].env[2].value" {
+		t.Errorf("unexpected field paths %v", got)
+	}
+	if len(web.Traits) != 1 {
+		t.Errorf("expected manual scaler trait")
+	}
+	cw := app.Components[0].Spec.Workload.Object.(*v1alpha2.ContainerizedWorkload)
+	if value := *cw.Spec.Containers[0].Environment[0].Value; value != "1" {
+		t.Errorf("unexpected env value %s", value)
+	}
+	body, err := app.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(body), "---\n") != 2 || !strings.Contains(string(body), "kind: ContainerizedWorkload") {
+		t.Errorf("unexpected yaml:\n%s", body)
+	}
+}
+
+func TestStatefulWorkload(t *testing.T) {
+	com := v1alpha1.Component{
+		ServiceCname:     "mysql",
+		K8SComponentName: "mysql",
+		DeployType:       v1alpha1.StateSingletonDeployType,
+		ShareImage:       "mysql:5.7",
+		Memory:           1024,
+		CPU:              500,
+		Ports:            []v1alpha1.ComponentPort{{ContainerPort: 3306, Protocol: "mysql"}},
+		Probes: []v1alpha1.ComponentProbe{
+			{Mode: "readiness", Scheme: "tcp", Port: 3306, IsUsed: true},
+			{Mode: "liveness", Cmd: "mysqladmin ping | grep alive", IsUsed: true},
+			{Mode: "readiness", Scheme: "tcp", IsUsed: false},
+		},
+		ServiceConnectInfoMapList: []v1alpha1.ComponentEnv{{AttrName: "MYSQL_PASSWORD", AttrValue: "secret"}},
+		ServiceVolumeMapList: v1alpha1.ComponentVolumeList{
+			{VolumeName: "data", VolumeMountPath: "/var/lib/mysql", VolumeType: v1alpha1.LocalVolumeType, VolumeCapacity: 10, AccessMode: v1alpha1.RWOAccessMode},
+			{VolumeName: "conf", VolumeMountPath: "/etc/mysql/my.cnf", VolumeType: v1alpha1.ConfigFileVolumeType, FileConent: "[mysqld]"},
+		},
+		ServicePluginConfigs: []v1alpha1.ComponentPluginConfig{{PluginKey: "init"}},
+	}
+	plugins := []*v1alpha1.Plugin{{PluginKey: "init", PluginName: "init", Image: "busybox", Category: "init-plugin"}}
+	builder := NewWorkloadBuilder(com, plugins)
+	sts := builder.Build().Object.(*apps.StatefulSet)
+	if sts.Spec.ServiceName != "mysql" {
+		t.Errorf("unexpected service name %s", sts.Spec.ServiceName)
+	}
+	if sts.Spec.Selector.MatchLabels["name"] != sts.Spec.Template.Labels["name"] {
+		t.Errorf("selector %v does not match the pod labels %v", sts.Spec.Selector.MatchLabels, sts.Spec.Template.Labels)
+	}
+	if len(sts.Spec.VolumeClaimTemplates) != 1 || sts.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests.Storage().String() != "10Gi" {
+		t.Errorf("unexpected volume claim templates %v", sts.Spec.VolumeClaimTemplates)
+	}
+	pod := sts.Spec.Template.Spec
+	if len(pod.Containers) != 1 || len(pod.InitContainers) != 1 {
+		t.Fatalf("expected one container and one init container")
+	}
+	container := pod.Containers[0]
+	if container.Resources.Limits.Cpu().String() != "500m" || container.Resources.Limits.Memory().String() != "1Gi" {
+		t.Errorf("unexpected resources %v", container.Resources.Limits)
+	}
+	if container.ReadinessProbe == nil || container.ReadinessProbe.TCPSocket == nil || len(container.VolumeMounts) != 2 {
+		t.Errorf("probe or volume mount is not set")
+	}
+	if probe := container.LivenessProbe; probe == nil || len(probe.Exec.Command) != 3 || probe.Exec.Command[2] != "mysqladmin ping | grep alive" {
+		t.Errorf("the probe command should run in a shell: %+v", probe)
+	}
+	if mount := container.VolumeMounts[1]; mount.SubPath != "my.cnf" || len(pod.Volumes) != 1 || pod.Volumes[0].ConfigMap.Name != "mysql-conf" {
+		t.Errorf("config file is not mounted from the config map: %+v %+v", mount, pod.Volumes)
+	}
+	resources := builder.(resourceBuilder).Resources()
+	if len(resources) != 2 {
+		t.Fatalf("expected the headless service and the config map, got %d", len(resources))
+	}
+	if svc := resources[0].(*core.Service); svc.Name != sts.Spec.ServiceName || svc.Spec.ClusterIP != core.ClusterIPNone || len(svc.Spec.Ports) != 1 {
+		t.Errorf("unexpected headless service %+v", svc)
+	}
+	if out := builder.Output(); len(out) != 1 || out[0].FieldPath != "spec.template.spec.containers[0].env[0].value" {
+		t.Errorf("unexpected data outputs %v", out)
+	}
+}
+
+func TestStatefulShareVolume(t *testing.T) {
+	ram := v1alpha1.RainbondApplicationConfig{
+		AppName: "demo",
+		Components: []*v1alpha1.Component{
+			{
+				ServiceCname: "mysql",
+				ComponentKey: "mysql",
+				DeployType:   v1alpha1.StateSingletonDeployType,
+				ServiceVolumeMapList: v1alpha1.ComponentVolumeList{
+					{VolumeName: "data", VolumeMountPath: "/var/lib/mysql", VolumeType: v1alpha1.LocalVolumeType},
+				},
+			},
+			{
+				ServiceCname:     "backup",
+				ComponentKey:     "backup",
+				DeployType:       v1alpha1.StateSingletonDeployType,
+				MntReleationList: []v1alpha1.ComponentShareVolume{{ShareServiceUUID: "mysql", VolumeName: "data", VolumeMountDir: "/backup"}},
+			},
+		},
+	}
+	app, err := NewBuilder(ram).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Components) != 4 || len(app.Configuration.Spec.Components) != 4 || app.Components[2].Name != "service-mysql" {
+		t.Fatalf("expected the statefulsets and their headless services, got %d components", len(app.Components))
+	}
+	pod := app.Components[1].Spec.Workload.Object.(*apps.StatefulSet).Spec.Template.Spec
+	if len(pod.Volumes) != 1 || pod.Volumes[0].PersistentVolumeClaim.ClaimName != "data-mysql-0" || pod.Containers[0].VolumeMounts[0].MountPath != "/backup" {
+		t.Errorf("the shared volume is not mounted: %+v", pod.Volumes)
+	}
+
+	ram.Components[0].DeployType = v1alpha1.StatelessSingletionDeployType
+	if _, err := NewBuilder(ram).Build(); err == nil {
+		t.Errorf("mounting the volume of a stateless component should fail")
+	}
+}
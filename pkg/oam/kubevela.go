@@ -0,0 +1,352 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package oam
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+//VelaAPIVersion the api version of the kubevela application
+const VelaAPIVersion = "core.oam.dev/v1beta1"
+
+//VelaApplication kubevela application model
+type VelaApplication struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              VelaApplicationSpec `json:"spec"`
+}
+
+//VelaApplicationSpec kubevela application spec
+type VelaApplicationSpec struct {
+	Components []VelaComponent `json:"components"`
+	Workflow   *VelaWorkflow   `json:"workflow,omitempty"`
+}
+
+//VelaComponent kubevela application component
+type VelaComponent struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	Traits     []VelaTrait            `json:"traits,omitempty"`
+}
+
+//VelaTrait kubevela component trait
+type VelaTrait struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+//VelaWorkflow kubevela application workflow
+type VelaWorkflow struct {
+	Steps []VelaWorkflowStep `json:"steps,omitempty"`
+}
+
+//VelaWorkflowStep kubevela application workflow step
+type VelaWorkflowStep struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	DependsOn  []string               `json:"dependsOn,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+//YAML return the kubevela application yaml
+func (a *VelaApplication) YAML() ([]byte, error) {
+	return yaml.Marshal(a)
+}
+
+//VelaBuilder kubevela application model builder
+type VelaBuilder interface {
+	// build kubevela application
+	Build() (*VelaApplication, error)
+}
+
+//NewVelaBuilder new kubevela application model builder
+func NewVelaBuilder(ram v1alpha1.RainbondApplicationConfig) VelaBuilder {
+	return &velaBuilder{ram: ram}
+}
+
+type velaBuilder struct {
+	ram v1alpha1.RainbondApplicationConfig
+}
+
+func (v *velaBuilder) Build() (*VelaApplication, error) {
+	app := &VelaApplication{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: VelaAPIVersion,
+			Kind:       "Application",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: v.ram.AppName,
+			Annotations: map[string]string{
+				"app.oam.dev/version": v.ram.AppVersion,
+			},
+		},
+	}
+	var steps []VelaWorkflowStep
+	for _, com := range v.ram.Components {
+		app.Spec.Components = append(app.Spec.Components, v.buildComponent(com))
+		step := VelaWorkflowStep{
+			Name: ComponentName(*com),
+			Type: "apply-component",
+			Properties: map[string]interface{}{
+				"component": ComponentName(*com),
+			},
+		}
+		for _, dep := range com.DepServiceMapList {
+			depCom := v.getComponent(dep.DepServiceKey)
+			if depCom == nil {
+				return nil, fmt.Errorf("dependent component %s of %s not found", dep.DepServiceKey, com.ServiceCname)
+			}
+			step.DependsOn = append(step.DependsOn, ComponentName(*depCom))
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) > 0 {
+		app.Spec.Workflow = &VelaWorkflow{Steps: steps}
+	}
+	return app, nil
+}
+
+func (v *velaBuilder) getComponent(key string) *v1alpha1.Component {
+	for _, com := range v.ram.Components {
+		if com.ComponentKey == key || com.ServiceShareID == key {
+			return com
+		}
+	}
+	return nil
+}
+
+func (v *velaBuilder) getPlugin(key string) *v1alpha1.Plugin {
+	for _, p := range v.ram.Plugins {
+		if p.PluginKey == key {
+			return p
+		}
+	}
+	return nil
+}
+
+func (v *velaBuilder) buildComponent(com *v1alpha1.Component) VelaComponent {
+	// components that expose no port run as background workers
+	componentType := "webservice"
+	if len(com.Ports) == 0 {
+		componentType = "worker"
+	}
+	properties := map[string]interface{}{
+		"image": componentImage(*com),
+	}
+	if cmd := strings.Fields(com.Cmd); len(cmd) > 0 {
+		properties["cmd"] = cmd
+	}
+	if com.CPU > 0 {
+		properties["cpu"] = fmt.Sprintf("%dm", com.CPU)
+	}
+	if com.Memory > 0 {
+		properties["memory"] = fmt.Sprintf("%dMi", com.Memory)
+	}
+	var envs []map[string]interface{}
+	for _, env := range append(append([]v1alpha1.ComponentEnv{}, com.Envs...), com.ServiceConnectInfoMapList...) {
+		envs = append(envs, map[string]interface{}{"name": env.AttrName, "value": env.AttrValue})
+	}
+	if len(envs) > 0 {
+		properties["env"] = envs
+	}
+	if componentType == "webservice" {
+		var ports []map[string]interface{}
+		for _, port := range com.Ports {
+			protocol := "TCP"
+			if strings.ToLower(port.Protocol) == "udp" {
+				protocol = "UDP"
+			}
+			ports = append(ports, map[string]interface{}{
+				"port":     port.ContainerPort,
+				"protocol": protocol,
+				"expose":   port.IsInner || port.IsOuter,
+			})
+		}
+		properties["ports"] = ports
+	}
+	for _, probe := range com.Probes {
+		if !probe.IsUsed {
+			continue
+		}
+		switch probe.Mode {
+		case "liveness":
+			properties["livenessProbe"] = velaProbe(probe)
+		case "readiness":
+			properties["readinessProbe"] = velaProbe(probe)
+		}
+	}
+	return VelaComponent{
+		Name:       ComponentName(*com),
+		Type:       componentType,
+		Properties: properties,
+		Traits:     v.buildTraits(com),
+	}
+}
+
+func (v *velaBuilder) buildTraits(com *v1alpha1.Component) (traits []VelaTrait) {
+	if replicas := com.ExtendMethodRule.MinNode; replicas > 0 {
+		traits = append(traits, VelaTrait{
+			Type:       "scaler",
+			Properties: map[string]interface{}{"replicas": replicas},
+		})
+	}
+	if storage := v.buildStorage(com); storage != nil {
+		traits = append(traits, VelaTrait{Type: "storage", Properties: storage})
+	}
+	// the connection information of the dependencies
+	envs := make(map[string]string)
+	for _, dep := range com.DepServiceMapList {
+		if depCom := v.getComponent(dep.DepServiceKey); depCom != nil {
+			for _, env := range depCom.ServiceConnectInfoMapList {
+				envs[env.AttrName] = env.AttrValue
+			}
+		}
+	}
+	if len(envs) > 0 {
+		traits = append(traits, VelaTrait{
+			Type:       "env",
+			Properties: map[string]interface{}{"env": envs},
+		})
+	}
+	http := make(map[string]int)
+	for _, route := range v.ram.IngressHTTPRoutes {
+		if v.getComponent(route.ComponentKey) != com {
+			continue
+		}
+		location := route.Location
+		if location == "" {
+			location = "/"
+		}
+		http[location] = int(route.Port)
+	}
+	if len(http) > 0 {
+		traits = append(traits, VelaTrait{
+			Type:       "gateway",
+			Properties: map[string]interface{}{"http": http},
+		})
+	}
+	// kubevela applies one trait of each type, the other plugins are ignored
+	var sidecar, initContainer bool
+	for _, pluginConfig := range com.ServicePluginConfigs {
+		plugin := v.getPlugin(pluginConfig.PluginKey)
+		if plugin == nil {
+			continue
+		}
+		traitType := "sidecar"
+		if isInitPlugin(*plugin) {
+			traitType = "init-container"
+		}
+		if (traitType == "sidecar" && sidecar) || (traitType == "init-container" && initContainer) {
+			logrus.Warningf("component %s has more than one %s plugin, ignore plugin %s", com.ServiceCname, traitType, plugin.PluginName)
+			continue
+		}
+		sidecar = sidecar || traitType == "sidecar"
+		initContainer = initContainer || traitType == "init-container"
+		traits = append(traits, VelaTrait{
+			Type: traitType,
+			Properties: map[string]interface{}{
+				"name":  plugin.PluginName,
+				"image": pluginImage(*plugin),
+			},
+		})
+	}
+	return
+}
+
+func (v *velaBuilder) buildStorage(com *v1alpha1.Component) map[string]interface{} {
+	var pvc, emptyDir, configMap []map[string]interface{}
+	for _, volume := range com.ServiceVolumeMapList {
+		name := volumeName(volume)
+		switch volume.VolumeType {
+		case v1alpha1.ConfigFileVolumeType:
+			file := path.Base(volume.VolumeMountPath)
+			configMap = append(configMap, map[string]interface{}{
+				"name":      fmt.Sprintf("%s-%s", ComponentName(*com), name),
+				"mountPath": volume.VolumeMountPath,
+				"subPath":   file,
+				"data":      map[string]string{file: volume.FileConent},
+			})
+		case v1alpha1.MemoryFSVolumeType:
+			emptyDir = append(emptyDir, map[string]interface{}{
+				"name":      name,
+				"mountPath": volume.VolumeMountPath,
+				"medium":    "memory",
+			})
+		default:
+			capacity := volume.VolumeCapacity
+			if capacity < 1 {
+				capacity = 1
+			}
+			pvc = append(pvc, map[string]interface{}{
+				"name":        fmt.Sprintf("%s-%s", ComponentName(*com), name),
+				"mountPath":   volume.VolumeMountPath,
+				"accessModes": []string{string(NewPersistentVolumeAccess(volume.AccessMode))},
+				"resources": map[string]interface{}{
+					"requests": map[string]string{"storage": fmt.Sprintf("%dGi", capacity)},
+				},
+			})
+		}
+	}
+	storage := make(map[string]interface{})
+	if len(pvc) > 0 {
+		storage["pvc"] = pvc
+	}
+	if len(emptyDir) > 0 {
+		storage["emptyDir"] = emptyDir
+	}
+	if len(configMap) > 0 {
+		storage["configMap"] = configMap
+	}
+	if len(storage) == 0 {
+		return nil
+	}
+	return storage
+}
+
+func velaProbe(probe v1alpha1.ComponentProbe) map[string]interface{} {
+	re := map[string]interface{}{}
+	switch {
+	case probe.Cmd != "":
+		re["exec"] = map[string]interface{}{"command": strings.Fields(probe.Cmd)}
+	case probe.Scheme == "http":
+		re["httpGet"] = map[string]interface{}{"path": probe.Path, "port": probe.Port}
+	default:
+		re["tcpSocket"] = map[string]interface{}{"port": probe.Port}
+	}
+	for key, value := range map[string]int{
+		"initialDelaySeconds": probe.InitialDelaySecond,
+		"periodSeconds":       probe.PeriodSecond,
+		"timeoutSeconds":      probe.TimeoutSecond,
+		"successThreshold":    probe.SuccessThreshold,
+		"failureThreshold":    probe.FailureThreshold,
+	} {
+		if value > 0 {
+			re[key] = value
+		}
+	}
+	return re
+}
@@ -0,0 +1,89 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package oam
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+func TestVelaBuild(t *testing.T) {
+	ram := v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{
+			{
+				ServiceCname:      "web",
+				ComponentKey:      "web",
+				ShareImage:        "nginx:1.19",
+				Ports:             []v1alpha1.ComponentPort{{ContainerPort: 80, Protocol: "http", IsOuter: true}},
+				DepServiceMapList: []v1alpha1.ComponentDep{{DepServiceKey: "db"}},
+				ExtendMethodRule:  v1alpha1.ComponentExtendMethodRule{MinNode: 2},
+				ServiceVolumeMapList: v1alpha1.ComponentVolumeList{
+					{VolumeName: "conf", VolumeMountPath: "/etc/nginx/nginx.conf", VolumeType: v1alpha1.ConfigFileVolumeType, FileConent: "worker_processes 1;"},
+				},
+				ServicePluginConfigs: []v1alpha1.ComponentPluginConfig{{PluginKey: "log"}},
+			},
+			{
+				ServiceCname:              "db",
+				ComponentKey:              "db",
+				ShareImage:                "mysql:5.7",
+				ServiceConnectInfoMapList: []v1alpha1.ComponentEnv{{AttrName: "MYSQL_PASSWORD", AttrValue: "secret"}},
+				ServiceVolumeMapList: v1alpha1.ComponentVolumeList{
+					{VolumeName: "data", VolumeMountPath: "/var/lib/mysql", VolumeType: v1alpha1.LocalVolumeType, VolumeCapacity: 10},
+				},
+			},
+		},
+		Plugins: []*v1alpha1.Plugin{{PluginKey: "log", PluginName: "log", Image: "fluentbit"}},
+		IngressHTTPRoutes: []*v1alpha1.IngressHTTPRoute{
+			{Location: "/", TargetComponent: v1alpha1.TargetComponent{ComponentKey: "web", Port: 80}},
+		},
+	}
+	app, err := NewVelaBuilder(ram).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.APIVersion != VelaAPIVersion || len(app.Spec.Components) != 2 {
+		t.Fatalf("unexpected application %+v", app)
+	}
+	web, db := app.Spec.Components[0], app.Spec.Components[1]
+	if web.Type != "webservice" || db.Type != "worker" {
+		t.Errorf("unexpected component types %s and %s", web.Type, db.Type)
+	}
+	var traits []string
+	for _, trait := range web.Traits {
+		traits = append(traits, trait.Type)
+	}
+	if got := strings.Join(traits, ","); got != "scaler,storage,env,gateway,sidecar" {
+		t.Errorf("unexpected traits %s", got)
+	}
+	steps := app.Spec.Workflow.Steps
+	if len(steps) != 2 || len(steps[0].DependsOn) != 1 || steps[0].DependsOn[0] != "db" {
+		t.Errorf("unexpected workflow steps %+v", steps)
+	}
+	body, err := app.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "apiVersion: core.oam.dev/v1beta1") || !strings.Contains(string(body), "storage: 10Gi") {
+		t.Errorf("unexpected yaml:\n%s", body)
+	}
+}
@@ -0,0 +1,593 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package oam
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/crossplane/oam-kubernetes-runtime/apis/core/v1alpha2"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util"
+	"github.com/sirupsen/logrus"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+//Parser oam application model parser
+type Parser interface {
+	// parse oam application to rainbond application model
+	Parse() (*v1alpha1.RainbondApplicationConfig, error)
+}
+
+//NewParser new oam model parser
+func NewParser(app *Application) Parser {
+	return &parser{
+		app:        app,
+		outputs:    make(map[string]dataOutput),
+		configMaps: make(map[string]*core.ConfigMap),
+	}
+}
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+//LoadApplication load the oam application from a multi-document yaml that contains
+//an application configuration and the components it references
+func LoadApplication(body []byte) (*Application, error) {
+	var app Application
+	for _, doc := range documentSeparator.Split(string(body), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		var meta metav1.TypeMeta
+		if err := yaml.Unmarshal([]byte(doc), &meta); err != nil {
+			return nil, fmt.Errorf("parse oam document failure %s", err.Error())
+		}
+		switch meta.Kind {
+		case v1alpha2.ApplicationConfigurationKind:
+			if app.Configuration != nil {
+				return nil, fmt.Errorf("more than one %s is defined", meta.Kind)
+			}
+			app.Configuration = &v1alpha2.ApplicationConfiguration{}
+			if err := yaml.Unmarshal([]byte(doc), app.Configuration); err != nil {
+				return nil, fmt.Errorf("parse %s failure %s", meta.Kind, err.Error())
+			}
+		case v1alpha2.ComponentKind:
+			var component v1alpha2.Component
+			if err := yaml.Unmarshal([]byte(doc), &component); err != nil {
+				return nil, fmt.Errorf("parse %s failure %s", meta.Kind, err.Error())
+			}
+			app.Components = append(app.Components, component)
+		default:
+			logrus.Warningf("oam document kind %s is not supported, ignore it", meta.Kind)
+		}
+	}
+	if app.Configuration == nil {
+		return nil, fmt.Errorf("%s is not defined", v1alpha2.ApplicationConfigurationKind)
+	}
+	return &app, nil
+}
+
+// dataOutput the component and the env a data output is read from
+type dataOutput struct {
+	com *v1alpha1.Component
+	env string
+}
+
+type parser struct {
+	app     *Application
+	ram     *v1alpha1.RainbondApplicationConfig
+	outputs map[string]dataOutput
+	// configMaps the config maps of the config files mounted by the workloads
+	configMaps map[string]*core.ConfigMap
+}
+
+func (p *parser) Parse() (*v1alpha1.RainbondApplicationConfig, error) {
+	if p.app == nil || p.app.Configuration == nil {
+		return nil, fmt.Errorf("application configuration is not defined")
+	}
+	p.ram = &v1alpha1.RainbondApplicationConfig{
+		AppKeyID:   util.NewUUID(),
+		AppName:    p.app.Configuration.Name,
+		AppVersion: p.app.Configuration.Annotations["app.oam.dev/version"],
+	}
+	if p.ram.AppVersion == "" {
+		p.ram.AppVersion = "1.0"
+	}
+	var workloads = make(map[string]string)
+	for i := range p.app.Components {
+		raw, err := workloadRaw(p.app.Components[i].Spec.Workload)
+		if err != nil {
+			return nil, fmt.Errorf("parse component %s failure %s", p.app.Components[i].Name, err.Error())
+		}
+		var meta metav1.TypeMeta
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, fmt.Errorf("parse component %s failure %s", p.app.Components[i].Name, err.Error())
+		}
+		workloads[p.app.Components[i].Name] = meta.Kind
+		if meta.Kind == "ConfigMap" {
+			var configMap core.ConfigMap
+			if err := json.Unmarshal(raw, &configMap); err != nil {
+				return nil, fmt.Errorf("parse component %s failure %s", p.app.Components[i].Name, err.Error())
+			}
+			p.configMaps[configMap.Name] = &configMap
+		}
+	}
+	var inputs = make(map[*v1alpha1.Component][]v1alpha2.DataInput)
+	for _, acc := range p.app.Configuration.Spec.Components {
+		component := p.getComponent(acc.ComponentName)
+		if component == nil {
+			return nil, fmt.Errorf("component %s is not defined", acc.ComponentName)
+		}
+		// the services and config maps are the resources of the workloads
+		if kind := workloads[acc.ComponentName]; kind == "Service" || kind == "ConfigMap" {
+			continue
+		}
+		com, err := p.parseComponent(component, acc)
+		if err != nil {
+			return nil, fmt.Errorf("parse component %s failure %s", acc.ComponentName, err.Error())
+		}
+		p.ram.Components = append(p.ram.Components, com)
+		inputs[com] = acc.DataInputs
+	}
+	// dependencies are resolved after all the data outputs are known
+	for _, com := range p.ram.Components {
+		for _, in := range inputs[com] {
+			out, ok := p.outputs[in.ValueFrom.DataOutputName]
+			if !ok {
+				return nil, fmt.Errorf("data output %s of component %s is not defined", in.ValueFrom.DataOutputName, com.ServiceCname)
+			}
+			if !hasDependency(com, out.com.ComponentKey) {
+				com.DepServiceMapList = append(com.DepServiceMapList, v1alpha1.ComponentDep{DepServiceKey: out.com.ComponentKey})
+			}
+		}
+	}
+	return p.ram, nil
+}
+
+func (p *parser) getComponent(name string) *v1alpha2.Component {
+	for i := range p.app.Components {
+		if p.app.Components[i].Name == name {
+			return &p.app.Components[i]
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseComponent(component *v1alpha2.Component, acc v1alpha2.ApplicationConfigurationComponent) (*v1alpha1.Component, error) {
+	name := component.Name
+	key := util.NewUUID()
+	com := &v1alpha1.Component{
+		ComponentKey:     key,
+		ServiceShareID:   key,
+		ServiceCname:     name,
+		ServiceName:      name,
+		ServiceAlias:     name,
+		K8SComponentName: name,
+		ServiceSource:    "docker_image",
+		ServiceType:      v1alpha1.ApplicationServiceType,
+		ExtendMethodRule: v1alpha1.DefaultExtendMethodRule(),
+	}
+	raw, err := workloadRaw(component.Spec.Workload)
+	if err != nil {
+		return nil, err
+	}
+	var meta metav1.TypeMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	// the env vars of the main container, in order, to resolve the env field paths
+	var envs []v1alpha1.ComponentEnv
+	switch meta.Kind {
+	case v1alpha2.ContainerizedWorkloadKind:
+		var cw v1alpha2.ContainerizedWorkload
+		if err := json.Unmarshal(raw, &cw); err != nil {
+			return nil, err
+		}
+		if envs, err = parseContainerizedWorkload(com, &cw); err != nil {
+			return nil, err
+		}
+	case "StatefulSet":
+		var sts apps.StatefulSet
+		if err := json.Unmarshal(raw, &sts); err != nil {
+			return nil, err
+		}
+		if envs, err = parseStatefulSet(com, &sts, p.configMaps); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("workload kind %s is not supported", meta.Kind)
+	}
+	// the envs read by data outputs are the connection information of the component,
+	// the envs written by data inputs are the connection information of the dependencies.
+	var connect, injected = make(map[int]bool), make(map[int]bool)
+	for _, out := range acc.DataOutputs {
+		index, ok := envIndex(out.FieldPath, len(envs))
+		if !ok {
+			logrus.Warningf("data output %s field path %s is not an env of the main container, ignore it", out.Name, out.FieldPath)
+			continue
+		}
+		connect[index] = true
+		p.outputs[out.Name] = dataOutput{com: com, env: envs[index].AttrName}
+	}
+	for _, in := range acc.DataInputs {
+		for _, fieldPath := range in.ToFieldPaths {
+			if index, ok := envIndex(fieldPath, len(envs)); ok {
+				injected[index] = true
+			}
+		}
+	}
+	for i, env := range envs {
+		switch {
+		case injected[i]:
+		case connect[i]:
+			com.ServiceConnectInfoMapList = append(com.ServiceConnectInfoMapList, env)
+		default:
+			com.Envs = append(com.Envs, env)
+		}
+	}
+	for _, trait := range acc.Traits {
+		if err := parseTrait(com, trait.Trait); err != nil {
+			return nil, err
+		}
+	}
+	com.HandleNullValue()
+	return com, nil
+}
+
+func workloadRaw(workload runtime.RawExtension) ([]byte, error) {
+	if len(workload.Raw) > 0 {
+		return workload.Raw, nil
+	}
+	if workload.Object == nil {
+		return nil, fmt.Errorf("workload is not defined")
+	}
+	return json.Marshal(workload.Object)
+}
+
+func parseTrait(com *v1alpha1.Component, trait runtime.RawExtension) error {
+	raw, err := workloadRaw(trait)
+	if err != nil {
+		return err
+	}
+	var meta metav1.TypeMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return err
+	}
+	switch meta.Kind {
+	case v1alpha2.ManualScalerTraitKind:
+		var scaler v1alpha2.ManualScalerTrait
+		if err := json.Unmarshal(raw, &scaler); err != nil {
+			return err
+		}
+		com.ExtendMethodRule.MinNode = int(scaler.Spec.ReplicaCount)
+	default:
+		logrus.Warningf("trait kind %s of component %s is not supported, ignore it", meta.Kind, com.ServiceCname)
+	}
+	return nil
+}
+
+var envFieldPath = regexp.MustCompile(`containers\[0\]\.env\[(\d+)\]\.value$`)
+
+func envIndex(fieldPath string, count int) (int, bool) {
+	match := envFieldPath.FindStringSubmatch(fieldPath)
+	if match == nil {
+		return 0, false
+	}
+	index, err := strconv.Atoi(match[1])
+	if err != nil || index >= count {
+		return 0, false
+	}
+	return index, true
+}
+
+func hasDependency(com *v1alpha1.Component, key string) bool {
+	for _, dep := range com.DepServiceMapList {
+		if dep.DepServiceKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+func parseContainerizedWorkload(com *v1alpha1.Component, cw *v1alpha2.ContainerizedWorkload) ([]v1alpha1.ComponentEnv, error) {
+	if len(cw.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("workload has no container")
+	}
+	com.DeployType = v1alpha1.StatelessMultipleDeployType
+	container := cw.Spec.Containers[0]
+	setImage(com, container.Image)
+	com.Cmd = strings.Join(append(container.Command, container.Arguments...), " ")
+	if container.Resources != nil {
+		com.Memory = int(container.Resources.Memory.Required.Value() / 1024 / 1024)
+		com.CPU = int(container.Resources.CPU.Required.MilliValue())
+		for _, volume := range container.Resources.Volumes {
+			com.ServiceVolumeMapList.Add(parseVolumeResource(volume))
+		}
+	}
+	for _, port := range container.Ports {
+		protocol := "tcp"
+		if port.Protocol != nil && *port.Protocol == v1alpha2.TransportProtocolUDP {
+			protocol = "udp"
+		}
+		com.Ports = append(com.Ports, v1alpha1.ComponentPort{
+			PortAlias:     portAlias(com.K8SComponentName, int(port.Port)),
+			Protocol:      protocol,
+			ContainerPort: int(port.Port),
+			IsInner:       true,
+			Name:          port.Name,
+		})
+	}
+	for _, file := range container.ConfigFiles {
+		if file.Value == nil {
+			logrus.Warningf("config file %s of component %s is read from secret, ignore it", file.Path, com.ServiceCname)
+			continue
+		}
+		com.ServiceVolumeMapList.Add(v1alpha1.ComponentVolume{
+			VolumeName:      configFileVolumeName(file.Path),
+			VolumeMountPath: file.Path,
+			VolumeType:      v1alpha1.ConfigFileVolumeType,
+			FileConent:      *file.Value,
+		})
+	}
+	if probe := container.LivenessProbe; probe != nil {
+		com.Probes = append(com.Probes, parseHealthProbe("liveness", probe))
+	}
+	if probe := container.ReadinessProbe; probe != nil {
+		com.Probes = append(com.Probes, parseHealthProbe("readiness", probe))
+	}
+	for _, sidecar := range cw.Spec.Containers[1:] {
+		logrus.Warningf("container %s of component %s can not be converted to a plugin, ignore it", sidecar.Name, com.ServiceCname)
+	}
+	var envs []v1alpha1.ComponentEnv
+	for _, env := range container.Environment {
+		var value string
+		if env.Value != nil {
+			value = *env.Value
+		}
+		envs = append(envs, v1alpha1.ComponentEnv{Name: env.Name, AttrName: env.Name, AttrValue: value})
+	}
+	return envs, nil
+}
+
+func parseStatefulSet(com *v1alpha1.Component, sts *apps.StatefulSet, configMaps map[string]*core.ConfigMap) ([]v1alpha1.ComponentEnv, error) {
+	pod := sts.Spec.Template.Spec
+	if len(pod.Containers) == 0 {
+		return nil, fmt.Errorf("workload has no container")
+	}
+	com.DeployType = v1alpha1.StateMultipleDeployType
+	if sts.Spec.Replicas == nil || *sts.Spec.Replicas <= 1 {
+		com.DeployType = v1alpha1.StateSingletonDeployType
+	}
+	if sts.Spec.Replicas != nil {
+		com.ExtendMethodRule.MinNode = int(*sts.Spec.Replicas)
+	}
+	container := pod.Containers[0]
+	setImage(com, container.Image)
+	com.Cmd = strings.Join(append(container.Command, container.Args...), " ")
+	com.Memory = int(container.Resources.Limits.Memory().Value() / 1024 / 1024)
+	com.CPU = int(container.Resources.Limits.Cpu().MilliValue())
+	for _, port := range container.Ports {
+		protocol := "tcp"
+		if port.Protocol == core.ProtocolUDP {
+			protocol = "udp"
+		}
+		com.Ports = append(com.Ports, v1alpha1.ComponentPort{
+			PortAlias:     portAlias(com.K8SComponentName, int(port.ContainerPort)),
+			Protocol:      protocol,
+			ContainerPort: int(port.ContainerPort),
+			IsInner:       true,
+			Name:          port.Name,
+		})
+	}
+	for _, mount := range container.VolumeMounts {
+		volume := v1alpha1.ComponentVolume{
+			VolumeName:      mount.Name,
+			VolumeMountPath: mount.MountPath,
+			VolumeType:      v1alpha1.LocalVolumeType,
+			AccessMode:      v1alpha1.RWOAccessMode,
+		}
+		if claim := volumeClaimTemplate(sts, mount.Name); claim != nil {
+			volume.VolumeCapacity = int(claim.Spec.Resources.Requests.Storage().Value() / 1024 / 1024 / 1024)
+			if len(claim.Spec.AccessModes) > 0 {
+				volume.AccessMode = parsePersistentVolumeAccess(claim.Spec.AccessModes[0])
+			}
+		} else if podVolume := podVolume(pod, mount.Name); podVolume != nil && podVolume.EmptyDir != nil {
+			volume.VolumeType = v1alpha1.MemoryFSVolumeType
+		} else if podVolume != nil && podVolume.ConfigMap != nil && configMaps[podVolume.ConfigMap.Name] != nil {
+			volume.VolumeType = v1alpha1.ConfigFileVolumeType
+			volume.FileConent = configMaps[podVolume.ConfigMap.Name].Data[path.Base(mount.MountPath)]
+			if mode := podVolume.ConfigMap.DefaultMode; mode != nil {
+				fileMode := int(*mode)
+				volume.Mode = &fileMode
+			}
+		} else {
+			logrus.Warningf("volume %s of component %s is not supported, ignore it", mount.Name, com.ServiceCname)
+			continue
+		}
+		com.ServiceVolumeMapList.Add(volume)
+	}
+	if probe := container.LivenessProbe; probe != nil {
+		com.Probes = append(com.Probes, parseProbe("liveness", probe))
+	}
+	if probe := container.ReadinessProbe; probe != nil {
+		com.Probes = append(com.Probes, parseProbe("readiness", probe))
+	}
+	for _, sidecar := range append(pod.InitContainers, pod.Containers[1:]...) {
+		logrus.Warningf("container %s of component %s can not be converted to a plugin, ignore it", sidecar.Name, com.ServiceCname)
+	}
+	var envs []v1alpha1.ComponentEnv
+	for _, env := range container.Env {
+		envs = append(envs, v1alpha1.ComponentEnv{Name: env.Name, AttrName: env.Name, AttrValue: env.Value})
+	}
+	return envs, nil
+}
+
+//portAlias the alias of the port is the prefix of the port envs injected into the dependent
+//components, so it is unique among the ports of the component
+func portAlias(name string, port int) string {
+	return strings.ToUpper(strings.Replace(name, "-", "_", -1)) + strconv.Itoa(port)
+}
+
+func setImage(com *v1alpha1.Component, image string) {
+	com.Image = image
+	com.ShareImage = image
+	com.Version = "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		com.Version = image[i+1:]
+	}
+}
+
+func parseVolumeResource(volume v1alpha2.VolumeResource) v1alpha1.ComponentVolume {
+	re := v1alpha1.ComponentVolume{
+		VolumeName:      volume.Name,
+		VolumeMountPath: volume.MountPath,
+		VolumeType:      v1alpha1.LocalVolumeType,
+		AccessMode:      v1alpha1.RWOAccessMode,
+	}
+	if volume.SharingPolicy != nil && *volume.SharingPolicy == v1alpha2.VolumeSharingPolicyShared {
+		re.VolumeType = v1alpha1.ShareFileVolumeType
+		re.AccessMode = v1alpha1.RWXAccessMode
+		re.SharePolicy = string(v1alpha2.VolumeSharingPolicyShared)
+	}
+	if volume.AccessMode != nil && *volume.AccessMode == v1alpha2.VolumeAccessModeRO {
+		re.AccessMode = v1alpha1.ROXAccessMode
+	}
+	if volume.Disk != nil {
+		re.VolumeCapacity = int(volume.Disk.Required.Value() / 1024 / 1024 / 1024)
+	}
+	return re
+}
+
+func parsePersistentVolumeAccess(mode core.PersistentVolumeAccessMode) v1alpha1.AccessMode {
+	switch mode {
+	case core.ReadOnlyMany:
+		return v1alpha1.ROXAccessMode
+	case core.ReadWriteMany:
+		return v1alpha1.RWXAccessMode
+	default:
+		return v1alpha1.RWOAccessMode
+	}
+}
+
+func volumeClaimTemplate(sts *apps.StatefulSet, name string) *core.PersistentVolumeClaim {
+	for i := range sts.Spec.VolumeClaimTemplates {
+		if sts.Spec.VolumeClaimTemplates[i].Name == name {
+			return &sts.Spec.VolumeClaimTemplates[i]
+		}
+	}
+	return nil
+}
+
+func podVolume(pod core.PodSpec, name string) *core.Volume {
+	for i := range pod.Volumes {
+		if pod.Volumes[i].Name == name {
+			return &pod.Volumes[i]
+		}
+	}
+	return nil
+}
+
+func configFileVolumeName(filePath string) string {
+	name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(path.Base(filePath)), "-"), "-")
+	if name == "" {
+		return "config-file"
+	}
+	return name
+}
+
+func parseHealthProbe(mode string, probe *v1alpha2.ContainerHealthProbe) v1alpha1.ComponentProbe {
+	re := v1alpha1.ComponentProbe{
+		ProbeID:            util.NewUUID(),
+		Mode:               mode,
+		IsUsed:             true,
+		InitialDelaySecond: int32Value(probe.InitialDelaySeconds),
+		PeriodSecond:       int32Value(probe.PeriodSeconds),
+		TimeoutSecond:      int32Value(probe.TimeoutSeconds),
+		SuccessThreshold:   int32Value(probe.SuccessThreshold),
+		FailureThreshold:   int32Value(probe.FailureThreshold),
+	}
+	switch {
+	case probe.Exec != nil:
+		re.Scheme = "cmd"
+		re.Cmd = strings.Join(probe.Exec.Command, " ")
+	case probe.HTTPGet != nil:
+		re.Scheme = "http"
+		re.Path = probe.HTTPGet.Path
+		re.Port = int(probe.HTTPGet.Port)
+		var headers []string
+		for _, header := range probe.HTTPGet.HTTPHeaders {
+			headers = append(headers, header.Name+"="+header.Value)
+		}
+		re.HTTPHeader = strings.Join(headers, ",")
+	case probe.TCPSocket != nil:
+		re.Scheme = "tcp"
+		re.Port = int(probe.TCPSocket.Port)
+	}
+	return re
+}
+
+func parseProbe(mode string, probe *core.Probe) v1alpha1.ComponentProbe {
+	re := v1alpha1.ComponentProbe{
+		ProbeID:            util.NewUUID(),
+		Mode:               mode,
+		IsUsed:             true,
+		InitialDelaySecond: int(probe.InitialDelaySeconds),
+		PeriodSecond:       int(probe.PeriodSeconds),
+		TimeoutSecond:      int(probe.TimeoutSeconds),
+		SuccessThreshold:   int(probe.SuccessThreshold),
+		FailureThreshold:   int(probe.FailureThreshold),
+	}
+	switch {
+	case probe.Exec != nil:
+		re.Scheme = "cmd"
+		re.Cmd = strings.Join(probe.Exec.Command, " ")
+		// the probe commands are run by a shell
+		if cmd := probe.Exec.Command; len(cmd) == 3 && cmd[0] == "/bin/sh" && cmd[1] == "-c" {
+			re.Cmd = cmd[2]
+		}
+	case probe.HTTPGet != nil:
+		re.Scheme = "http"
+		re.Path = probe.HTTPGet.Path
+		re.Port = probe.HTTPGet.Port.IntValue()
+		var headers []string
+		for _, header := range probe.HTTPGet.HTTPHeaders {
+			headers = append(headers, header.Name+"="+header.Value)
+		}
+		re.HTTPHeader = strings.Join(headers, ",")
+	case probe.TCPSocket != nil:
+		re.Scheme = "tcp"
+		re.Port = probe.TCPSocket.Port.IntValue()
+	}
+	return re
+}
+
+func int32Value(v *int32) int {
+	if v == nil {
+		return 0
+	}
+	return int(*v)
+}
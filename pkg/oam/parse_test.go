@@ -0,0 +1,105 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package oam
+
+import (
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+func TestParse(t *testing.T) {
+	ram := v1alpha1.RainbondApplicationConfig{
+		AppName:    "demo",
+		AppVersion: "1.0",
+		Components: []*v1alpha1.Component{
+			{
+				ServiceCname:      "web",
+				ComponentKey:      "web",
+				DeployType:        v1alpha1.StatelessMultipleDeployType,
+				ShareImage:        "nginx:1.19",
+				Memory:            256,
+				CPU:               200,
+				Ports:             []v1alpha1.ComponentPort{{ContainerPort: 80, Protocol: "tcp"}, {ContainerPort: 443, Protocol: "tcp"}},
+				Envs:              []v1alpha1.ComponentEnv{{AttrName: "DEBUG", AttrValue: "1"}},
+				Probes:            []v1alpha1.ComponentProbe{{Mode: "liveness", Scheme: "http", Port: 80, Path: "/healthz"}},
+				DepServiceMapList: []v1alpha1.ComponentDep{{DepServiceKey: "db"}},
+				ServiceVolumeMapList: v1alpha1.ComponentVolumeList{
+					{VolumeName: "conf", VolumeMountPath: "/etc/nginx/nginx.conf", VolumeType: v1alpha1.ConfigFileVolumeType, FileConent: "worker_processes 1;"},
+				},
+				ExtendMethodRule: v1alpha1.ComponentExtendMethodRule{MinNode: 3},
+			},
+			{
+				ServiceCname:              "db",
+				ComponentKey:              "db",
+				DeployType:                v1alpha1.StateSingletonDeployType,
+				ShareImage:                "mysql:5.7",
+				ServiceConnectInfoMapList: []v1alpha1.ComponentEnv{{AttrName: "MYSQL_PASSWORD", AttrValue: "secret"}},
+				ServiceVolumeMapList: v1alpha1.ComponentVolumeList{
+					{VolumeName: "data", VolumeMountPath: "/var/lib/mysql", VolumeType: v1alpha1.LocalVolumeType, VolumeCapacity: 10},
+					{VolumeName: "conf", VolumeMountPath: "/etc/mysql/my.cnf", VolumeType: v1alpha1.ConfigFileVolumeType, FileConent: "[mysqld]"},
+				},
+			},
+		},
+	}
+	app, err := NewBuilder(ram).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := app.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadApplication(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, err := NewParser(loaded).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re.AppName != "demo" || len(re.Components) != 2 {
+		t.Fatalf("unexpected application %s with %d components", re.AppName, len(re.Components))
+	}
+	web, db := re.Components[0], re.Components[1]
+	if web.ShareImage != "nginx:1.19" || web.Memory != 256 || web.CPU != 200 || web.ExtendMethodRule.MinNode != 3 {
+		t.Errorf("unexpected component %+v", web)
+	}
+	if len(web.Envs) != 1 || web.Envs[0].AttrName != "DEBUG" {
+		t.Errorf("the injected connection information should not be an env: %v", web.Envs)
+	}
+	if len(web.DepServiceMapList) != 1 || web.DepServiceMapList[0].DepServiceKey != db.ComponentKey {
+		t.Errorf("unexpected dependencies %v", web.DepServiceMapList)
+	}
+	if len(web.ServiceVolumeMapList) != 1 || web.ServiceVolumeMapList[0].VolumeType != v1alpha1.ConfigFileVolumeType || web.ServiceVolumeMapList[0].FileConent != "worker_processes 1;" {
+		t.Errorf("unexpected volumes %v", web.ServiceVolumeMapList)
+	}
+	if len(web.Probes) != 1 || web.Probes[0].Path != "/healthz" || len(web.Ports) != 2 {
+		t.Errorf("unexpected probes %v or ports %v", web.Probes, web.Ports)
+	}
+	if web.Ports[0].PortAlias != "WEB80" || web.Ports[1].PortAlias != "WEB443" {
+		t.Errorf("the port aliases should be unique: %v", web.Ports)
+	}
+	if db.DeployType != v1alpha1.StateSingletonDeployType || len(db.ServiceConnectInfoMapList) != 1 || db.ServiceConnectInfoMapList[0].AttrValue != "secret" {
+		t.Errorf("unexpected component %+v", db)
+	}
+	if len(db.ServiceVolumeMapList) != 2 || db.ServiceVolumeMapList[0].VolumeCapacity != 10 || db.ServiceVolumeMapList[1].FileConent != "[mysqld]" {
+		t.Errorf("unexpected volumes %v", db.ServiceVolumeMapList)
+	}
+}
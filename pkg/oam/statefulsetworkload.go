@@ -19,36 +19,51 @@
 package oam
 
 import (
+	"fmt"
+	"path"
+	"strings"
+
 	v1alpha2 "github.com/crossplane/oam-kubernetes-runtime/apis/core/v1alpha2"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/sirupsen/logrus"
 	apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 type statefulWorkloadBuilder struct {
 	com     v1alpha1.Component
 	plugins []*v1alpha1.Plugin
-	output  []v1alpha2.DataOutput
+	deps    []*v1alpha1.Component
+	// shares the components whose volumes are mounted by the component
+	shares []*v1alpha1.Component
+	output []v1alpha2.DataOutput
+	input  []v1alpha2.DataInput
 }
 
 func (s *statefulWorkloadBuilder) Build() runtime.RawExtension {
+	name := ComponentName(s.com)
 	var statefulset = &apps.StatefulSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apps.SchemeGroupVersion.String(),
+			Kind:       "StatefulSet",
+		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        s.com.ServiceCname,
-			Labels:      map[string]string{},
+			Name:        name,
+			Labels:      s.labels(),
 			Annotations: map[string]string{},
 		},
 		Spec: apps.StatefulSetSpec{
-			Replicas:    Int32(s.com.ExtendMethodRule.MinNode),
-			Template:    s.buildPodTemplate(),
-			ServiceName: "",
+			Replicas: Int32(s.replicas()),
+			Template: s.buildPodTemplate(),
+			// the headless service that governs the statefulset has the same name as the component
+			ServiceName: name,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"name": s.com.ServiceName,
-				},
+				MatchLabels: s.labels(),
 			},
+			VolumeClaimTemplates: s.buildVolumeClaimTemplates(),
 			UpdateStrategy: apps.StatefulSetUpdateStrategy{
 				Type: apps.RollingUpdateStatefulSetStrategyType,
 			},
@@ -57,33 +72,348 @@ func (s *statefulWorkloadBuilder) Build() runtime.RawExtension {
 	return runtime.RawExtension{Object: statefulset}
 }
 
+func (s *statefulWorkloadBuilder) labels() map[string]string {
+	return map[string]string{
+		"name": ComponentName(s.com),
+	}
+}
+
+func (s *statefulWorkloadBuilder) replicas() int {
+	if s.com.DeployType == v1alpha1.StateSingletonDeployType || s.com.ExtendMethodRule.MinNode < 1 {
+		return 1
+	}
+	return s.com.ExtendMethodRule.MinNode
+}
+
 func (s *statefulWorkloadBuilder) buildPodTemplate() core.PodTemplateSpec {
 	var podT = core.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        s.com.ServiceCname,
-			Labels:      map[string]string{},
+			Labels:      s.labels(),
 			Annotations: map[string]string{},
 		},
 		Spec: core.PodSpec{
-			Volumes:          s.buildVolume(),
-			Containers:       s.buildPodContainer(),
-			InitContainers:   s.buildPodInitContainer(),
-			RestartPolicy:    core.RestartPolicyAlways,
-			ImagePullSecrets: []core.LocalObjectReference{},
+			Volumes:        s.buildVolume(),
+			Containers:     s.buildPodContainer(),
+			InitContainers: s.buildPodInitContainer(),
+			RestartPolicy:  core.RestartPolicyAlways,
 		},
 	}
 	return podT
 }
 
-func (s *statefulWorkloadBuilder) buildVolume() []core.Volume {
-	return nil
+//buildVolume the pod volumes that are not claimed by the volume claim templates
+func (s *statefulWorkloadBuilder) buildVolume() (re []core.Volume) {
+	for _, volume := range s.com.ServiceVolumeMapList {
+		switch volume.VolumeType {
+		case v1alpha1.MemoryFSVolumeType:
+			re = append(re, core.Volume{
+				Name: volumeName(volume),
+				VolumeSource: core.VolumeSource{
+					EmptyDir: &core.EmptyDirVolumeSource{Medium: core.StorageMediumMemory},
+				},
+			})
+		case v1alpha1.ConfigFileVolumeType:
+			configMap := &core.ConfigMapVolumeSource{
+				LocalObjectReference: core.LocalObjectReference{Name: s.configMapName(volume)},
+			}
+			if volume.Mode != nil {
+				configMap.DefaultMode = Int32(*volume.Mode)
+			}
+			re = append(re, core.Volume{
+				Name:         volumeName(volume),
+				VolumeSource: core.VolumeSource{ConfigMap: configMap},
+			})
+		}
+	}
+	for _, share := range s.com.MntReleationList {
+		claim := s.shareVolumeClaim(share)
+		if claim == "" {
+			logrus.Warningf("[oam] dependent volume(%s/%s) of component %s not found", share.ShareServiceUUID, share.VolumeName, s.com.ServiceCname)
+			continue
+		}
+		re = append(re, core.Volume{
+			Name: shareVolumeName(share),
+			VolumeSource: core.VolumeSource{
+				PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			},
+		})
+	}
+	return
+}
+
+//shareVolumeClaim the claim of the shared volume, the claims of a statefulset are created
+//from its templates per pod, so the claim of the first pod is shared
+func (s *statefulWorkloadBuilder) shareVolumeClaim(share v1alpha1.ComponentShareVolume) string {
+	for _, com := range s.shares {
+		if com.ServiceShareID != share.ShareServiceUUID && com.ComponentKey != share.ShareServiceUUID {
+			continue
+		}
+		for _, volume := range com.ServiceVolumeMapList {
+			if volume.VolumeName == share.VolumeName {
+				return fmt.Sprintf("%s-%s-0", volumeName(volume), ComponentName(*com))
+			}
+		}
+	}
+	return ""
+}
+
+func (s *statefulWorkloadBuilder) configMapName(volume v1alpha1.ComponentVolume) string {
+	return fmt.Sprintf("%s-%s", ComponentName(s.com), volumeName(volume))
+}
+
+//Resources the headless service that governs the statefulset and the config maps of the config files
+func (s *statefulWorkloadBuilder) Resources() []runtime.Object {
+	headless := &core.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: core.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   ComponentName(s.com),
+			Labels: s.labels(),
+		},
+		Spec: core.ServiceSpec{
+			ClusterIP: core.ClusterIPNone,
+			Selector:  s.labels(),
+		},
+	}
+	for _, port := range s.buildPorts() {
+		headless.Spec.Ports = append(headless.Spec.Ports, core.ServicePort{
+			Name:       port.Name,
+			Port:       port.ContainerPort,
+			Protocol:   port.Protocol,
+			TargetPort: intstr.FromInt(int(port.ContainerPort)),
+		})
+	}
+	objects := []runtime.Object{headless}
+	for _, volume := range s.com.ServiceVolumeMapList {
+		if volume.VolumeType != v1alpha1.ConfigFileVolumeType {
+			continue
+		}
+		objects = append(objects, &core.ConfigMap{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: core.SchemeGroupVersion.String(),
+				Kind:       "ConfigMap",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   s.configMapName(volume),
+				Labels: s.labels(),
+			},
+			Data: map[string]string{
+				path.Base(volume.VolumeMountPath): volume.FileConent,
+			},
+		})
+	}
+	return objects
+}
+
+func (s *statefulWorkloadBuilder) buildVolumeClaimTemplates() (re []core.PersistentVolumeClaim) {
+	for _, volume := range s.com.ServiceVolumeMapList {
+		if volume.VolumeType == v1alpha1.ConfigFileVolumeType || volume.VolumeType == v1alpha1.MemoryFSVolumeType {
+			continue
+		}
+		capacity := volume.VolumeCapacity
+		if capacity < 1 {
+			capacity = 1
+		}
+		re = append(re, core.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   volumeName(volume),
+				Labels: s.labels(),
+			},
+			Spec: core.PersistentVolumeClaimSpec{
+				AccessModes: []core.PersistentVolumeAccessMode{NewPersistentVolumeAccess(volume.AccessMode)},
+				Resources: core.ResourceRequirements{
+					Requests: core.ResourceList{
+						core.ResourceStorage: NewDiskQuantity(capacity),
+					},
+				},
+			},
+		})
+	}
+	return
+}
+
+func (s *statefulWorkloadBuilder) buildVolumeMounts() (re []core.VolumeMount) {
+	for _, volume := range s.com.ServiceVolumeMapList {
+		mount := core.VolumeMount{
+			Name:      volumeName(volume),
+			MountPath: volume.VolumeMountPath,
+			ReadOnly:  volume.AccessMode == v1alpha1.ROXAccessMode,
+		}
+		if volume.VolumeType == v1alpha1.ConfigFileVolumeType {
+			// the config file is the only key of the config map
+			mount.SubPath = path.Base(volume.VolumeMountPath)
+		}
+		re = append(re, mount)
+	}
+	for _, share := range s.com.MntReleationList {
+		if s.shareVolumeClaim(share) == "" {
+			continue
+		}
+		re = append(re, core.VolumeMount{
+			Name:      shareVolumeName(share),
+			MountPath: share.VolumeMountDir,
+		})
+	}
+	return
 }
 
 func (s *statefulWorkloadBuilder) buildPodContainer() []core.Container {
+	com := s.com
+	mainContainer := core.Container{
+		Name:           ComponentName(com),
+		Image:          componentImage(com),
+		Command:        strings.Fields(com.Cmd),
+		Env:            s.buildEnv(true),
+		Ports:          s.buildPorts(),
+		Resources:      s.buildResources(com.Memory, com.CPU),
+		VolumeMounts:   s.buildVolumeMounts(),
+		LivenessProbe:  s.buildProbe("liveness"),
+		ReadinessProbe: s.buildProbe("readiness"),
+	}
+	containers := []core.Container{mainContainer}
+	//plugin container
+	for _, pluginConfig := range com.ServicePluginConfigs {
+		plugin := s.getPlugin(pluginConfig.PluginKey)
+		if plugin == nil || isInitPlugin(*plugin) {
+			continue
+		}
+		containers = append(containers, s.buildPluginContainer(*plugin, pluginConfig))
+	}
+	return containers
+}
+
+func (s *statefulWorkloadBuilder) buildPodInitContainer() (re []core.Container) {
+	for _, pluginConfig := range s.com.ServicePluginConfigs {
+		plugin := s.getPlugin(pluginConfig.PluginKey)
+		if plugin == nil || !isInitPlugin(*plugin) {
+			continue
+		}
+		re = append(re, s.buildPluginContainer(*plugin, pluginConfig))
+	}
+	return
+}
+
+func (s *statefulWorkloadBuilder) buildPluginContainer(plugin v1alpha1.Plugin, pluginConfig v1alpha1.ComponentPluginConfig) core.Container {
+	return core.Container{
+		Name:         plugin.PluginName,
+		Image:        pluginImage(plugin),
+		Env:          s.buildEnv(false),
+		Resources:    s.buildResources(pluginConfig.MemoryRequired, pluginConfig.CPURequired),
+		VolumeMounts: s.buildVolumeMounts(),
+	}
+}
+
+func (s *statefulWorkloadBuilder) buildResources(memory, cpu int) core.ResourceRequirements {
+	var re = core.ResourceRequirements{
+		Limits:   core.ResourceList{},
+		Requests: core.ResourceList{},
+	}
+	if memory > 0 {
+		re.Limits[core.ResourceMemory] = NewMemoryQuantity(memory)
+		re.Requests[core.ResourceMemory] = NewMemoryQuantity(memory)
+	}
+	if cpu > 0 {
+		re.Limits[core.ResourceCPU] = NewCPUQuantity(cpu)
+		re.Requests[core.ResourceCPU] = NewCPUQuantity(cpu)
+	}
+	return re
+}
+
+//buildEnv the envs of the component, the connection information is exposed as data
+//outputs and the connection information of the dependencies is set by data inputs
+func (s *statefulWorkloadBuilder) buildEnv(insetOutput bool) (re []core.EnvVar) {
+	for _, env := range s.com.Envs {
+		re = append(re, core.EnvVar{Name: env.AttrName, Value: env.AttrValue})
+	}
+	for _, out := range s.com.ServiceConnectInfoMapList {
+		re = append(re, core.EnvVar{Name: out.AttrName, Value: out.AttrValue})
+		if insetOutput {
+			s.output = append(s.output, v1alpha2.DataOutput{
+				Name:      OutputName(s.com, out.AttrName),
+				FieldPath: fmt.Sprintf("spec.template.spec.containers[0].env[%d].value", len(re)-1),
+			})
+		}
+	}
+	if !insetOutput {
+		return
+	}
+	for _, dep := range s.deps {
+		for _, in := range dep.ServiceConnectInfoMapList {
+			re = append(re, core.EnvVar{Name: in.AttrName, Value: in.AttrValue})
+			s.input = append(s.input, v1alpha2.DataInput{
+				ValueFrom: v1alpha2.DataInputValueFrom{
+					DataOutputName: OutputName(*dep, in.AttrName),
+				},
+				ToFieldPaths: []string{fmt.Sprintf("spec.template.spec.containers[0].env[%d].value", len(re)-1)},
+			})
+		}
+	}
+	return
+}
+
+func (s *statefulWorkloadBuilder) buildPorts() (re []core.ContainerPort) {
+	for _, port := range s.com.Ports {
+		protocol := core.ProtocolTCP
+		if strings.ToLower(port.Protocol) == "udp" {
+			protocol = core.ProtocolUDP
+		}
+		re = append(re, core.ContainerPort{
+			Name:          fmt.Sprintf("port-%d", port.ContainerPort),
+			ContainerPort: int32(port.ContainerPort),
+			Protocol:      protocol,
+		})
+	}
+	return
+}
+
+func (s *statefulWorkloadBuilder) buildProbe(mode string) *core.Probe {
+	for _, probe := range s.com.Probes {
+		if !probe.IsUsed || probe.Mode != mode {
+			continue
+		}
+		var handler core.Handler
+		switch {
+		case probe.Cmd != "":
+			handler.Exec = &core.ExecAction{Command: []string{"/bin/sh", "-c", probe.Cmd}}
+		case probe.Scheme == "http":
+			handler.HTTPGet = &core.HTTPGetAction{
+				Path: probe.Path,
+				Port: intstr.FromInt(probe.Port),
+			}
+			for _, hd := range strings.Split(probe.HTTPHeader, ",") {
+				if hd == "" {
+					continue
+				}
+				kv := strings.SplitN(hd, "=", 2)
+				header := core.HTTPHeader{Name: kv[0]}
+				if len(kv) == 2 {
+					header.Value = kv[1]
+				}
+				handler.HTTPGet.HTTPHeaders = append(handler.HTTPGet.HTTPHeaders, header)
+			}
+		default:
+			handler.TCPSocket = &core.TCPSocketAction{Port: intstr.FromInt(probe.Port)}
+		}
+		return &core.Probe{
+			Handler:             handler,
+			InitialDelaySeconds: int32(probe.InitialDelaySecond),
+			PeriodSeconds:       int32(probe.PeriodSecond),
+			TimeoutSeconds:      int32(probe.TimeoutSecond),
+			SuccessThreshold:    int32(probe.SuccessThreshold),
+			FailureThreshold:    int32(probe.FailureThreshold),
+		}
+	}
 	return nil
 }
 
-func (s *statefulWorkloadBuilder) buildPodInitContainer() []core.Container {
+func (s *statefulWorkloadBuilder) getPlugin(key string) *v1alpha1.Plugin {
+	for _, p := range s.plugins {
+		if p.PluginKey == key {
+			return p
+		}
+	}
 	return nil
 }
 
@@ -94,3 +424,7 @@ func (s *statefulWorkloadBuilder) Kind() string {
 func (s *statefulWorkloadBuilder) Output() []v1alpha2.DataOutput {
 	return s.output
 }
+
+func (s *statefulWorkloadBuilder) Input() []v1alpha2.DataInput {
+	return s.input
+}
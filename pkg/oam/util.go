@@ -20,6 +20,7 @@ package oam
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/crossplane/oam-kubernetes-runtime/apis/core/v1alpha2"
@@ -27,6 +28,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 
+	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
 
@@ -41,7 +43,7 @@ func NewMemoryQuantity(memory int) resource.Quantity {
 
 //NewCPUQuantity new cpu quantity
 func NewCPUQuantity(cpu int) resource.Quantity {
-	rq, err := resource.ParseQuantity(fmt.Sprintf("%d", cpu))
+	rq, err := resource.ParseQuantity(fmt.Sprintf("%dm", cpu))
 	if err != nil {
 		logrus.Warningf("parse cpu quantity failure %s", err.Error())
 	}
@@ -73,6 +75,18 @@ func NewVolumeAccess(va v1alpha1.AccessMode) *v1alpha2.VolumeAccessMode {
 	}
 }
 
+//NewPersistentVolumeAccess new persistent volume access mode
+func NewPersistentVolumeAccess(va v1alpha1.AccessMode) core.PersistentVolumeAccessMode {
+	switch va {
+	case v1alpha1.ROXAccessMode:
+		return core.ReadOnlyMany
+	case v1alpha1.RWXAccessMode:
+		return core.ReadWriteMany
+	default:
+		return core.ReadWriteOnce
+	}
+}
+
 //NewSharingPolicy new sharing policy
 func NewSharingPolicy(sp string) *v1alpha2.VolumeSharingPolicy {
 	var share = v1alpha2.VolumeSharingPolicyShared
@@ -110,3 +124,48 @@ func Int32(s int) *int32 {
 	var ss = int32(s)
 	return &ss
 }
+
+//ComponentName the name of the oam component, k8s component name is preferred
+//because it is a valid kubernetes resource name.
+func ComponentName(com v1alpha1.Component) string {
+	if com.K8SComponentName != "" {
+		return com.K8SComponentName
+	}
+	return com.ServiceCname
+}
+
+//OutputName the data output name of the component connection information
+func OutputName(com v1alpha1.Component, attrName string) string {
+	return fmt.Sprintf("%s-%s", ComponentName(com), strings.ToLower(strings.Replace(attrName, "_", "-", -1)))
+}
+
+func componentImage(com v1alpha1.Component) string {
+	if com.ShareImage != "" {
+		return com.ShareImage
+	}
+	return com.Image
+}
+
+func pluginImage(plugin v1alpha1.Plugin) string {
+	if plugin.ShareImage != "" {
+		return plugin.ShareImage
+	}
+	return plugin.Image
+}
+
+//isInitPlugin init plugins run as init containers before the component starts
+func isInitPlugin(plugin v1alpha1.Plugin) bool {
+	return plugin.Category == "init-plugin"
+}
+
+//volumeName a valid kubernetes volume name of the component volume
+func volumeName(volume v1alpha1.ComponentVolume) string {
+	return strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(volume.VolumeName), "-"), "-")
+}
+
+//shareVolumeName a valid kubernetes volume name of the volume shared by another component
+func shareVolumeName(share v1alpha1.ComponentShareVolume) string {
+	return "mnt-" + strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(share.VolumeName), "-"), "-")
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
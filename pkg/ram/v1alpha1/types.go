@@ -78,20 +78,11 @@ func (s *RainbondApplicationConfig) HandleNullValue() {
 	}
 }
 
-//Validation validation app templete
+//Validation validation app templete, the returned error is ValidationErrors
+//that contains all the problems found
 func (s *RainbondApplicationConfig) Validation() error {
-	if len(s.Components) == 0 && len(s.K8sResources) == 0 {
-		return fmt.Errorf("template is empty")
-	}
-	for _, app := range s.Components {
-		if err := app.Validation(); err != nil {
-			return err
-		}
-	}
-	for _, plugin := range s.Plugins {
-		if err := plugin.Validation(); err != nil {
-			return err
-		}
+	if errs := s.Validate(); len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
@@ -213,8 +204,12 @@ func (s *Component) HandleNullValue() {
 	}
 }
 
-//Validation -
+//Validation validation component, the references to the other components are
+//checked by RainbondApplicationConfig.Validation
 func (s *Component) Validation() error {
+	if errs := s.validate(s.ServiceCname); len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
@@ -242,6 +237,15 @@ func (s *ComponentProbe) Validation() error {
 	if s.Port == 0 && s.Cmd == "" {
 		return fmt.Errorf("probe endpoint port is 0")
 	}
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("probe endpoint port %d is out of range", s.Port)
+	}
+	if s.Mode != "" && s.Mode != "liveness" && s.Mode != "readiness" && s.Mode != "ignore" {
+		return fmt.Errorf("probe mode %s is not supported", s.Mode)
+	}
+	if s.InitialDelaySecond < 0 || s.PeriodSecond < 0 || s.TimeoutSecond < 0 || s.SuccessThreshold < 0 || s.FailureThreshold < 0 {
+		return fmt.Errorf("probe timing is negative")
+	}
 	return nil
 }
 
@@ -328,8 +332,11 @@ type Plugin struct {
 	BuildVersion  string              `json:"build_version"`
 }
 
-//Validation validation app templete
+//Validation validation plugin
 func (s *Plugin) Validation() error {
+	if errs := s.validate(s.PluginName); len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
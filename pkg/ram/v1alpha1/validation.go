@@ -0,0 +1,216 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError a problem of the application template, field is the path of the
+// invalid value, such as apps[0].port_map_list[1]
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors all problems of the application template
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate check the whole application template and return all the problems found
+func (s *RainbondApplicationConfig) Validate() ValidationErrors {
+	var errs ValidationErrors
+	if len(s.Components) == 0 && len(s.K8sResources) == 0 {
+		errs.add("apps", "template is empty")
+	}
+	components := make(map[string]*Component, len(s.Components))
+	for i, com := range s.Components {
+		field := fmt.Sprintf("apps[%d]", i)
+		if com.ComponentKey == "" {
+			errs.add(field+".service_key", "component %s has no key", com.ServiceCname)
+			continue
+		}
+		if _, ok := components[com.ComponentKey]; ok {
+			errs.add(field+".service_key", "duplicate component key %s", com.ComponentKey)
+			continue
+		}
+		components[com.ComponentKey] = com
+	}
+	getComponent := func(key string) *Component {
+		if com, ok := components[key]; ok {
+			return com
+		}
+		for _, com := range s.Components {
+			if com.ServiceShareID != "" && com.ServiceShareID == key {
+				return com
+			}
+		}
+		return nil
+	}
+	plugins := make(map[string]bool, len(s.Plugins))
+	for i, plugin := range s.Plugins {
+		field := fmt.Sprintf("plugins[%d]", i)
+		errs = append(errs, plugin.validate(field)...)
+		if plugins[plugin.PluginKey] {
+			errs.add(field+".plugin_key", "duplicate plugin key %s", plugin.PluginKey)
+		}
+		plugins[plugin.PluginKey] = true
+	}
+	for i, com := range s.Components {
+		field := fmt.Sprintf("apps[%d]", i)
+		errs = append(errs, com.validate(field)...)
+		for j, dep := range com.DepServiceMapList {
+			if getComponent(dep.DepServiceKey) == nil {
+				errs.add(fmt.Sprintf("%s.dep_service_map_list[%d]", field, j), "component %s depends on unknown component %s", com.ServiceCname, dep.DepServiceKey)
+			}
+		}
+		for j, mnt := range com.MntReleationList {
+			mntField := fmt.Sprintf("%s.mnt_relation_list[%d]", field, j)
+			owner := getComponent(mnt.ShareServiceUUID)
+			if owner == nil {
+				errs.add(mntField, "component %s mounts volume %s of unknown component %s", com.ServiceCname, mnt.VolumeName, mnt.ShareServiceUUID)
+				continue
+			}
+			if !owner.hasVolume(mnt.VolumeName) {
+				errs.add(mntField, "component %s mounts volume %s that does not exist in component %s", com.ServiceCname, mnt.VolumeName, owner.ServiceCname)
+			}
+		}
+		for j, config := range com.ServicePluginConfigs {
+			if !plugins[config.PluginKey] {
+				errs.add(fmt.Sprintf("%s.service_related_plugin_config[%d]", field, j), "component %s uses unknown plugin %s", com.ServiceCname, config.PluginKey)
+			}
+		}
+	}
+	for i, group := range s.AppConfigGroups {
+		for j, key := range group.ComponentKeys {
+			if getComponent(key) == nil {
+				errs.add(fmt.Sprintf("app_config_groups[%d].component_keys[%d]", i, j), "config group %s references unknown component %s", group.Name, key)
+			}
+		}
+	}
+	for i, route := range s.IngressHTTPRoutes {
+		errs = append(errs, validateTarget(fmt.Sprintf("ingress_http_routes[%d]", i), route.TargetComponent, getComponent)...)
+	}
+	for i, route := range s.IngressSreamRoutes {
+		errs = append(errs, validateTarget(fmt.Sprintf("ingress_stream_routes[%d]", i), route.TargetComponent, getComponent)...)
+	}
+	return errs
+}
+
+func validateTarget(field string, target TargetComponent, getComponent func(string) *Component) (errs ValidationErrors) {
+	com := getComponent(target.ComponentKey)
+	if com == nil {
+		errs.add(field+".component_key", "ingress targets unknown component %s", target.ComponentKey)
+		return
+	}
+	for _, port := range com.Ports {
+		if uint32(port.ContainerPort) == target.Port {
+			return
+		}
+	}
+	errs.add(field+".port", "ingress targets port %d that is not defined in component %s", target.Port, com.ServiceCname)
+	return
+}
+
+func (s *Component) hasVolume(name string) bool {
+	for _, volume := range s.ServiceVolumeMapList {
+		if volume.VolumeName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Component) validate(field string) (errs ValidationErrors) {
+	if s.Memory < 0 {
+		errs.add(field+".memory", "memory %d of component %s is negative", s.Memory, s.ServiceCname)
+	}
+	if s.CPU < 0 {
+		errs.add(field+".cpu", "cpu %d of component %s is negative", s.CPU, s.ServiceCname)
+	}
+	ports := make(map[int]bool, len(s.Ports))
+	for i, port := range s.Ports {
+		portField := fmt.Sprintf("%s.port_map_list[%d]", field, i)
+		if port.ContainerPort < 1 || port.ContainerPort > 65535 {
+			errs.add(portField, "port %d of component %s is out of range", port.ContainerPort, s.ServiceCname)
+		}
+		if ports[port.ContainerPort] {
+			errs.add(portField, "duplicate port %d in component %s", port.ContainerPort, s.ServiceCname)
+		}
+		ports[port.ContainerPort] = true
+	}
+	mountPaths := make(map[string]bool)
+	for i, volume := range s.ServiceVolumeMapList {
+		volumeField := fmt.Sprintf("%s.service_volume_map_list[%d]", field, i)
+		if volume.VolumeCapacity < 0 {
+			errs.add(volumeField, "capacity %d of volume %s is negative", volume.VolumeCapacity, volume.VolumeName)
+		}
+		if mountPaths[volume.VolumeMountPath] {
+			errs.add(volumeField, "duplicate mount path %s in component %s", volume.VolumeMountPath, s.ServiceCname)
+		}
+		mountPaths[volume.VolumeMountPath] = true
+	}
+	for i, mnt := range s.MntReleationList {
+		if mountPaths[mnt.VolumeMountDir] {
+			errs.add(fmt.Sprintf("%s.mnt_relation_list[%d]", field, i), "duplicate mount path %s in component %s", mnt.VolumeMountDir, s.ServiceCname)
+		}
+		mountPaths[mnt.VolumeMountDir] = true
+	}
+	for i := range s.Probes {
+		// the disabled probes are kept by the templates but never used
+		if !s.Probes[i].IsUsed {
+			continue
+		}
+		if err := s.Probes[i].Validation(); err != nil {
+			errs.add(fmt.Sprintf("%s.probes[%d]", field, i), "probe of component %s is invalid: %s", s.ServiceCname, err.Error())
+		}
+	}
+	for i, config := range s.ServicePluginConfigs {
+		if config.MemoryRequired < 0 || config.CPURequired < 0 {
+			errs.add(fmt.Sprintf("%s.service_related_plugin_config[%d]", field, i), "resources of plugin %s are negative", config.PluginKey)
+		}
+	}
+	return
+}
+
+func (s *Plugin) validate(field string) (errs ValidationErrors) {
+	if s.PluginKey == "" {
+		errs.add(field+".plugin_key", "plugin %s has no key", s.PluginName)
+	}
+	if s.Image == "" && s.ShareImage == "" {
+		errs.add(field+".share_image", "plugin %s has no image", s.PluginName)
+	}
+	return
+}
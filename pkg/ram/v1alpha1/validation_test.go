@@ -0,0 +1,91 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	ram := RainbondApplicationConfig{
+		Components: []*Component{
+			{
+				ServiceCname:      "web",
+				ComponentKey:      "web",
+				Memory:            -1,
+				Ports:             []ComponentPort{{ContainerPort: 80}},
+				DepServiceMapList: []ComponentDep{{DepServiceKey: "cache"}},
+				MntReleationList:  []ComponentShareVolume{{VolumeName: "logs", VolumeMountDir: "/data", ShareServiceUUID: "db"}},
+				ServiceVolumeMapList: ComponentVolumeList{
+					{VolumeName: "data", VolumeMountPath: "/data"},
+				},
+				// the disabled probe is not validated
+				Probes:               []ComponentProbe{{Mode: "liveness", IsUsed: true}, {Mode: "readiness"}},
+				ServicePluginConfigs: []ComponentPluginConfig{{PluginKey: "unknown"}},
+			},
+			{
+				ServiceCname: "db",
+				ComponentKey: "db",
+				Ports:        []ComponentPort{{ContainerPort: 3306}},
+			},
+			{
+				ServiceCname: "db2",
+				ComponentKey: "db",
+			},
+		},
+		IngressHTTPRoutes: []*IngressHTTPRoute{
+			{TargetComponent: TargetComponent{ComponentKey: "web", Port: 8080}},
+		},
+	}
+	errs := ram.Validate()
+	expected := map[string]bool{
+		"apps[2].service_key":                      true,
+		"apps[0].memory":                           true,
+		"apps[0].dep_service_map_list[0]":          true,
+		"apps[0].mnt_relation_list[0]":             true,
+		"apps[0].probes[0]":                        true,
+		"apps[0].service_related_plugin_config[0]": true,
+		"ingress_http_routes[0].port":              true,
+	}
+	found := make(map[string]bool)
+	for _, err := range errs {
+		found[err.Field] = true
+		if !expected[err.Field] {
+			t.Errorf("unexpected problem %s", err.Error())
+		}
+	}
+	for field := range expected {
+		if !found[field] {
+			t.Errorf("problem of %s is not found", field)
+		}
+	}
+	// the mount path of the shared volume duplicates the one of the own volume
+	var duplicates int
+	for _, err := range errs {
+		if err.Field == "apps[0].mnt_relation_list[0]" {
+			duplicates++
+		}
+	}
+	if duplicates != 2 {
+		t.Errorf("expected unknown volume and duplicate mount path problems, got %d", duplicates)
+	}
+	if err := ram.Validation(); err == nil {
+		t.Errorf("expected validation error")
+	}
+}
@@ -0,0 +1,311 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package archive extracts and creates tar, tar.gz and zip archives without
+// shelling out. Extraction rejects entries that would be written outside the
+// target directory, either by their name or through a symbolic link, and
+// enforces size limits, so packages uploaded by users can be extracted safely.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	//ErrUnsafePath the entry would be written outside the target directory
+	ErrUnsafePath = errors.New("path escapes the target directory")
+	//ErrTooLarge the entry or the archive exceeds the size limit
+	ErrTooLarge = errors.New("size limit exceeded")
+	//ErrTooManyEntries the archive exceeds the entry limit
+	ErrTooManyEntries = errors.New("entry limit exceeded")
+)
+
+// EntryError an error of an archive entry
+type EntryError struct {
+	Entry string
+	Err   error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("archive entry %s: %s", e.Entry, e.Err.Error())
+}
+
+// Unwrap return the cause of the error
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// Limits the size limits of the extraction, zero means no limit
+type Limits struct {
+	// the total size of the extracted files
+	MaxTotalSize int64
+	// the size of a single extracted file
+	MaxFileSize int64
+	// the number of entries
+	MaxEntries int
+}
+
+// DefaultLimits the limits used when none is given, large enough for application
+// packages with many image layers
+var DefaultLimits = Limits{
+	MaxTotalSize: 256 << 30,
+	MaxFileSize:  64 << 30,
+	MaxEntries:   1 << 20,
+}
+
+// extractor writes the entries of an archive into the target directory
+type extractor struct {
+	target  string
+	limits  Limits
+	total   int64
+	entries int
+}
+
+func newExtractor(target string, limits Limits) (*extractor, error) {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, err
+	}
+	// the target is resolved so that the checks compare real paths
+	real, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(real)
+	if err != nil {
+		return nil, err
+	}
+	return &extractor{target: abs, limits: limits}, nil
+}
+
+// path returns the destination of the entry, it fails if the cleaned name is
+// absolute or climbs out of the target directory
+func (e *extractor) path(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || strings.HasPrefix(name, `\`) {
+		return "", ErrUnsafePath
+	}
+	clean := filepath.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", ErrUnsafePath
+	}
+	dest := filepath.Join(e.target, clean)
+	if err := e.checkParent(dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// checkParent resolves the symbolic links of the existing parent directories of
+// dest, and fails if they lead outside the target directory
+func (e *extractor) checkParent(dest string) error {
+	dir := filepath.Dir(dest)
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		if dir == e.target || len(dir) <= len(e.target) {
+			return nil
+		}
+		dir = filepath.Dir(dir)
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if !e.inside(real) {
+		return ErrUnsafePath
+	}
+	return nil
+}
+
+func (e *extractor) inside(path string) bool {
+	return path == e.target || strings.HasPrefix(path, e.target+string(filepath.Separator))
+}
+
+// countEntry enforces the entry limit
+func (e *extractor) countEntry() error {
+	e.entries++
+	if e.limits.MaxEntries > 0 && e.entries > e.limits.MaxEntries {
+		return ErrTooManyEntries
+	}
+	return nil
+}
+
+func (e *extractor) mkdir(name string, mode os.FileMode) error {
+	dest, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	return os.Chmod(dest, mode.Perm()|0700)
+}
+
+func (e *extractor) writeFile(name string, mode os.FileMode, r io.Reader) error {
+	dest, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := e.checkParent(dest); err != nil {
+		return err
+	}
+	// never write through an existing link
+	if info, err := os.Lstat(dest); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// a negative limit means unlimited
+	limit := int64(-1)
+	if e.limits.MaxFileSize > 0 {
+		limit = e.limits.MaxFileSize
+	}
+	if e.limits.MaxTotalSize > 0 && (limit < 0 || e.limits.MaxTotalSize-e.total < limit) {
+		limit = e.limits.MaxTotalSize - e.total
+	}
+	var n int64
+	if limit >= 0 {
+		n, err = io.CopyN(file, r, limit+1)
+		if err == nil {
+			return ErrTooLarge
+		}
+		if err != io.EOF {
+			return err
+		}
+	} else if n, err = io.Copy(file, r); err != nil {
+		return err
+	}
+	e.total += n
+	// the umask may have dropped bits of the mode
+	return file.Chmod(mode.Perm())
+}
+
+func (e *extractor) symlink(name, linkname string) error {
+	dest, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(linkname) {
+		return ErrUnsafePath
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	// the link is resolved like the kernel does, through the links already extracted,
+	// a lexical check misses chains such as s -> .. and t -> s/..
+	parent, err := filepath.EvalSymlinks(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	resolved, err := e.resolve(parent, filepath.FromSlash(linkname), 0)
+	if err != nil {
+		return err
+	}
+	if !e.inside(parent) || !e.inside(resolved) {
+		return ErrUnsafePath
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	return os.Symlink(linkname, dest)
+}
+
+// maxLinkDepth the number of links followed when a link target is resolved
+const maxLinkDepth = 40
+
+// resolve returns the real path of the relative path from the real directory dir,
+// the existing links are followed component by component and the missing
+// components are joined as they are
+func (e *extractor) resolve(dir, rel string, depth int) (string, error) {
+	if depth > maxLinkDepth {
+		return "", ErrUnsafePath
+	}
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			dir = filepath.Dir(dir)
+			continue
+		}
+		next := filepath.Join(dir, name)
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			dir = next
+			continue
+		}
+		linkname, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(linkname) {
+			return "", ErrUnsafePath
+		}
+		if dir, err = e.resolve(dir, linkname, depth+1); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func (e *extractor) link(name, linkname string) error {
+	dest, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	source, err := e.path(linkname)
+	if err != nil {
+		return err
+	}
+	// the link source must be a regular file inside the target
+	real, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return err
+	}
+	if !e.inside(real) {
+		return ErrUnsafePath
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	return os.Link(real, dest)
+}
+
+func entryError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &EntryError{Entry: name, Err: err}
+}
@@ -0,0 +1,210 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/zip"
+)
+
+type entry struct {
+	name, linkname, body string
+	typeflag             byte
+	mode                 int64
+}
+
+func tarball(t *testing.T, entries ...entry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		hdr := &tar.Header{Name: e.name, Linkname: e.linkname, Typeflag: e.typeflag, Mode: mode, Size: int64(len(e.body))}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte(e.body))
+		}
+	}
+	tw.Close()
+	return &buf
+}
+
+func TestExtractTar(t *testing.T) {
+	target := t.TempDir()
+	buf := tarball(t,
+		entry{name: "app/", typeflag: tar.TypeDir, mode: 0755},
+		entry{name: "app/run.sh", body: "#!/bin/sh", typeflag: tar.TypeReg, mode: 0755},
+		entry{name: "app/current", linkname: "run.sh", typeflag: tar.TypeSymlink},
+	)
+	if err := ExtractTar(buf, target, DefaultLimits); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(target, "app", "run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("mode is not preserved: %s", info.Mode())
+	}
+	if link, _ := os.Readlink(filepath.Join(target, "app", "current")); link != "run.sh" {
+		t.Errorf("unexpected link %s", link)
+	}
+}
+
+func TestExtractTarUnsafe(t *testing.T) {
+	cases := map[string][]entry{
+		"parent":           {{name: "../evil", body: "x", typeflag: tar.TypeReg}},
+		"absolute":         {{name: "/tmp/evil", body: "x", typeflag: tar.TypeReg}},
+		"symlink":          {{name: "link", linkname: "../../", typeflag: tar.TypeSymlink}},
+		"absolute symlink": {{name: "link", linkname: "/etc", typeflag: tar.TypeSymlink}},
+		"through symlink": {
+			{name: "a/", typeflag: tar.TypeDir},
+			{name: "a/b", linkname: "..", typeflag: tar.TypeSymlink},
+			{name: "a/c", linkname: "b/..", typeflag: tar.TypeSymlink},
+			{name: "a/c/evil", body: "x", typeflag: tar.TypeReg},
+		},
+		"hardlink": {{name: "passwd", linkname: "../../etc/passwd", typeflag: tar.TypeLink}},
+	}
+	for name, entries := range cases {
+		root := t.TempDir()
+		target := filepath.Join(root, "target")
+		err := ExtractTar(tarball(t, entries...), target, DefaultLimits)
+		var entryErr *EntryError
+		if !errors.As(err, &entryErr) || !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("%s: expected unsafe path error, got %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "evil")); err == nil {
+			t.Errorf("%s: file is written outside the target", name)
+		}
+		// the link that escapes through another link is not created at all
+		if _, err := os.Lstat(filepath.Join(target, "a", "c")); err == nil {
+			t.Errorf("%s: escaping link is created", name)
+		}
+	}
+}
+
+func TestExtractTarLimits(t *testing.T) {
+	buf := tarball(t, entry{name: "big", body: "0123456789", typeflag: tar.TypeReg})
+	err := ExtractTar(buf, t.TempDir(), Limits{MaxFileSize: 5})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected size limit error, got %v", err)
+	}
+	buf = tarball(t, entry{name: "a", body: "1", typeflag: tar.TypeReg}, entry{name: "b", body: "2", typeflag: tar.TypeReg})
+	err = ExtractTar(buf, t.TempDir(), Limits{MaxEntries: 1})
+	if !errors.Is(err, ErrTooManyEntries) {
+		t.Errorf("expected entry limit error, got %v", err)
+	}
+}
+
+func TestCreateTarGz(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "demo-1.0-ram")
+	os.MkdirAll(filepath.Join(dir, "images"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "metadata.json"), []byte("{}"), 0600)
+	archive := filepath.Join(root, "demo.tar.gz")
+	if err := CreateTarGz(context.Background(), archive, dir); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(root, "out")
+	if err := ExtractTarGzFile(archive, target); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(target, "demo-1.0-ram", "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode is not preserved: %s", info.Mode())
+	}
+}
+
+func TestCreateTarball(t *testing.T) {
+	gzipBlockSize = 16
+	defer func() { gzipBlockSize = 1 << 20 }()
+	body := bytes.Repeat([]byte("layer"), 100)
+	for _, opts := range []PackOptions{
+		{Compression: Gzip, Concurrency: 4},
+		{Compression: Zstd, Concurrency: 2},
+		{Compression: Uncompressed, RemoveFiles: true},
+	} {
+		root := t.TempDir()
+		dir := filepath.Join(root, "demo-1.0-ram")
+		os.MkdirAll(dir, 0755)
+		ioutil.WriteFile(filepath.Join(dir, "images.tar"), body, 0644)
+		archive := filepath.Join(root, "demo"+opts.Compression.Extension())
+		if err := CreateTarball(context.Background(), archive, dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := DetectCompression(bufio.NewReader(file)); c != opts.Compression {
+			t.Errorf("expected compression %s detected, got %s", opts.Compression, c)
+		}
+		file.Close()
+		target := filepath.Join(root, "out")
+		if err := ExtractTarballFile(archive, target); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadFile(filepath.Join(target, "demo-1.0-ram", "images.tar")); !bytes.Equal(got, body) {
+			t.Errorf("the file of %s tarball is not restored", opts.Compression)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "images.tar")); opts.RemoveFiles != os.IsNotExist(err) {
+			t.Errorf("expected the packaged file removed %v, got %v", opts.RemoveFiles, err)
+		}
+	}
+}
+
+func TestExtractZipUnsafe(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(root, "evil.zip")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	w, _ := zw.Create("../evil")
+	w.Write([]byte("x"))
+	zw.Close()
+	file.Close()
+	err = ExtractZip(archive, filepath.Join(root, "target"), DefaultLimits)
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("expected unsafe path error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "evil")); err == nil {
+		t.Errorf("file is written outside the target")
+	}
+}
@@ -0,0 +1,267 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression the compression of a tarball
+type Compression string
+
+const (
+	//Gzip gzip compressed tarball, the default
+	Gzip Compression = "gzip"
+	//Zstd zstandard compressed tarball
+	Zstd Compression = "zstd"
+	//Uncompressed plain tarball
+	Uncompressed Compression = "none"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+)
+
+// gzipBlockSize the size of the blocks compressed in parallel
+var gzipBlockSize = 1 << 20
+
+// ParseCompression parse the name of the compression, empty means gzip
+func ParseCompression(s string) (Compression, error) {
+	switch Compression(s) {
+	case "", Gzip, "gz":
+		return Gzip, nil
+	case Zstd, "zst":
+		return Zstd, nil
+	case Uncompressed, "tar":
+		return Uncompressed, nil
+	}
+	return "", fmt.Errorf("unsupported compression %s", s)
+}
+
+// Extension the file extension of the tarball
+func (c Compression) Extension() string {
+	switch c {
+	case Zstd:
+		return ".tar.zst"
+	case Uncompressed:
+		return ".tar"
+	}
+	return ".tar.gz"
+}
+
+// DetectCompression detect the compression of the stream from its magic bytes, the
+// bytes are peeked so nothing is consumed
+func DetectCompression(r *bufio.Reader) Compression {
+	magic, _ := r.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		return Zstd
+	}
+	return Uncompressed
+}
+
+// IsZipFile whether the file is a zip archive by its magic bytes
+func IsZipFile(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(magic, zipMagic), nil
+}
+
+// NewReader return the decompressed stream, the compression is detected from the
+// magic bytes
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	switch DetectCompression(br) {
+	case Gzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("read gzip failure %s", err.Error())
+		}
+		return gr, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("read zstd failure %s", err.Error())
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+// NewWriter return the writer that compresses into w, concurrency is the number of
+// blocks compressed at the same time. Close must be called to flush the stream, it
+// does not close w.
+func NewWriter(w io.Writer, c Compression, concurrency int) (io.WriteCloser, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	switch c {
+	case "", Gzip:
+		if concurrency == 1 {
+			return gzip.NewWriter(w), nil
+		}
+		return newParallelGzipWriter(w, concurrency), nil
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(concurrency))
+	case Uncompressed:
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unsupported compression %s", c)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// parallelGzipWriter compress the blocks of the stream in parallel, every block is
+// written as a gzip member. The concatenated members are a valid gzip stream, which
+// gzip -d and the go reader read as one.
+type parallelGzipWriter struct {
+	buf   []byte
+	queue chan chan gzipBlock
+	done  chan struct{}
+	mu    sync.Mutex
+	err   error
+	// submitted whether a block is submitted
+	submitted bool
+	closed    bool
+}
+
+type gzipBlock struct {
+	data []byte
+	err  error
+}
+
+func newParallelGzipWriter(w io.Writer, concurrency int) *parallelGzipWriter {
+	p := &parallelGzipWriter{
+		queue: make(chan chan gzipBlock, concurrency),
+		done:  make(chan struct{}),
+	}
+	// the blocks are written in order
+	go func() {
+		defer close(p.done)
+		for result := range p.queue {
+			block := <-result
+			if p.error() != nil {
+				continue
+			}
+			err := block.err
+			if err == nil {
+				_, err = w.Write(block.data)
+			}
+			if err != nil {
+				p.setError(err)
+			}
+		}
+	}()
+	return p
+}
+
+func (p *parallelGzipWriter) error() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *parallelGzipWriter) setError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *parallelGzipWriter) Write(b []byte) (int, error) {
+	if err := p.error(); err != nil {
+		return 0, err
+	}
+	n := len(b)
+	for len(b) > 0 {
+		if p.buf == nil {
+			p.buf = make([]byte, 0, gzipBlockSize)
+		}
+		m := gzipBlockSize - len(p.buf)
+		if m > len(b) {
+			m = len(b)
+		}
+		p.buf = append(p.buf, b[:m]...)
+		b = b[m:]
+		if len(p.buf) == gzipBlockSize {
+			p.submit()
+		}
+	}
+	return n, nil
+}
+
+// submit compress the buffered block, it blocks when all the workers are busy
+func (p *parallelGzipWriter) submit() {
+	data := p.buf
+	p.buf = nil
+	p.submitted = true
+	result := make(chan gzipBlock, 1)
+	p.queue <- result
+	go func() {
+		var out bytes.Buffer
+		gw := gzip.NewWriter(&out)
+		_, err := gw.Write(data)
+		if cerr := gw.Close(); err == nil {
+			err = cerr
+		}
+		result <- gzipBlock{data: out.Bytes(), err: err}
+	}()
+}
+
+func (p *parallelGzipWriter) Close() error {
+	if p.closed {
+		return p.error()
+	}
+	p.closed = true
+	// an empty stream still has a gzip member
+	if len(p.buf) > 0 || !p.submitted {
+		p.submit()
+	}
+	close(p.queue)
+	<-p.done
+	return p.error()
+}
@@ -0,0 +1,239 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ExtractTar extract the tar stream into the target directory
+func ExtractTar(r io.Reader, target string, limits Limits) error {
+	e, err := newExtractor(target, limits)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar failure %s", err.Error())
+		}
+		if err := e.countEntry(); err != nil {
+			return entryError(hdr.Name, err)
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdir(hdr.Name, mode)
+		case tar.TypeReg, tar.TypeRegA:
+			err = e.writeFile(hdr.Name, mode, tr)
+		case tar.TypeSymlink:
+			err = e.symlink(hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = e.link(hdr.Name, hdr.Linkname)
+		case tar.TypeXGlobalHeader:
+		default:
+			// devices and fifos are never part of a package
+			err = fmt.Errorf("unsupported entry type %c", hdr.Typeflag)
+		}
+		if err != nil {
+			return entryError(hdr.Name, err)
+		}
+	}
+}
+
+// ExtractTarGz extract the gzip compressed tar stream into the target directory
+func ExtractTarGz(r io.Reader, target string, limits Limits) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("read gzip failure %s", err.Error())
+	}
+	defer gr.Close()
+	return ExtractTar(gr, target, limits)
+}
+
+// ExtractTarball extract the tar stream into the target directory, the stream may be
+// compressed by gzip or zstd, which is detected from its magic bytes
+func ExtractTarball(r io.Reader, target string, limits Limits) error {
+	dr, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	return ExtractTar(dr, target, limits)
+}
+
+// ExtractTarballFile extract the tar file into the target directory, the file may be
+// compressed by gzip or zstd
+func ExtractTarballFile(archive, target string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ExtractTarball(file, target, DefaultLimits)
+}
+
+// ExtractTarFile extract the tar file into the target directory
+func ExtractTarFile(archive, target string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ExtractTar(file, target, DefaultLimits)
+}
+
+// ExtractTarGzFile extract the gzip compressed tar file into the target directory
+func ExtractTarGzFile(archive, target string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ExtractTarGz(file, target, DefaultLimits)
+}
+
+// WriteTar write the directory into the tar stream, the entries are named
+// relative to the parent directory of dir, like `tar -C $(dirname dir) -c $(basename dir)`.
+// It stops when the context is canceled.
+func WriteTar(ctx context.Context, w io.Writer, dir string) error {
+	return writeTar(ctx, w, dir, false)
+}
+
+// writeTar write the directory into the tar stream, the regular files are removed as
+// soon as they are written if remove is true
+func writeTar(ctx context.Context, w io.Writer, dir string, remove bool) error {
+	tw := tar.NewWriter(w)
+	base := filepath.Dir(filepath.Clean(dir))
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return entryError(path, err)
+		}
+		name, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return entryError(hdr.Name, err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.Copy(tw, &contextReader{ctx: ctx, r: file}); err != nil {
+			return entryError(hdr.Name, err)
+		}
+		if remove {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// CreateTarGz create the gzip compressed tar file of the directory, the file
+// is removed if it fails or the context is canceled
+func CreateTarGz(ctx context.Context, archive, dir string) error {
+	return CreateTarball(ctx, archive, dir, PackOptions{Compression: Gzip})
+}
+
+// PackOptions the options of creating a tarball
+type PackOptions struct {
+	Compression Compression
+	// Concurrency the number of blocks compressed at the same time
+	Concurrency int
+	// RemoveFiles remove the files of the directory as soon as they are written, so that
+	// the directory and the tarball do not take the disk space twice. The removed files
+	// are lost if it fails.
+	RemoveFiles bool
+}
+
+// CreateTarball create the tar file of the directory, the file is removed if it fails
+// or the context is canceled
+func CreateTarball(ctx context.Context, archive, dir string, opts PackOptions) (err error) {
+	file, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(archive)
+		}
+	}()
+	cw, err := NewWriter(file, opts.Compression, opts.Concurrency)
+	if err != nil {
+		return err
+	}
+	if err := writeTar(ctx, cw, dir, opts.RemoveFiles); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// contextReader fails the reads once the context is canceled, so that copying
+// a large file stops early
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,96 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/goodrain/rainbond-oam/pkg/util/zip"
+)
+
+// ExtractZip extract the zip file into the target directory. The owner of the
+// entries is restored from the `uid/gid` comment written by rainbond.
+func ExtractZip(archive, target string, limits Limits) error {
+	reader, err := zip.OpenDirectReader(archive)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %v", err)
+	}
+	defer reader.Close()
+	e, err := newExtractor(target, limits)
+	if err != nil {
+		return err
+	}
+	for _, file := range reader.File {
+		if err := e.countEntry(); err != nil {
+			return entryError(file.Name, err)
+		}
+		if err := extractZipEntry(e, file); err != nil {
+			return entryError(file.Name, err)
+		}
+	}
+	return nil
+}
+
+func extractZipEntry(e *extractor, file *zip.File) error {
+	mode := file.Mode()
+	if mode.IsDir() {
+		if err := e.mkdir(file.Name, mode); err != nil {
+			return err
+		}
+		return chownFromComment(e, file)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("error opening file: %v", err)
+	}
+	defer rc.Close()
+	if mode&os.ModeSymlink != 0 {
+		linkname, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		return e.symlink(file.Name, string(linkname))
+	}
+	if err := e.writeFile(file.Name, mode, rc); err != nil {
+		return err
+	}
+	return chownFromComment(e, file)
+}
+
+func chownFromComment(e *extractor, file *zip.File) error {
+	guid := strings.Split(file.Comment, "/")
+	if len(guid) != 2 {
+		return nil
+	}
+	dest, err := e.path(file.Name)
+	if err != nil {
+		return err
+	}
+	uid, _ := strconv.Atoi(guid[0])
+	gid, _ := strconv.Atoi(guid[1])
+	if err := os.Lchown(dest, uid, gid); err != nil {
+		return fmt.Errorf("error changing owner: %v", err)
+	}
+	return nil
+}
@@ -21,17 +21,15 @@ package util
 import (
 	"bufio"
 	"fmt"
-	"github.com/goodrain/rainbond-oam/pkg/util/zip"
+	"github.com/goodrain/rainbond-oam/pkg/util/archive"
 	"github.com/google/uuid"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
 )
 
@@ -74,82 +72,30 @@ func getVariableKey(source string) (key, value string) {
 }
 
 //Unzip archive file to target dir
-func Unzip(archive, target string) error {
-	reader, err := zip.OpenDirectReader(archive)
-	if err != nil {
-		return fmt.Errorf("error opening archive: %v", err)
-	}
-	if err := os.MkdirAll(target, 0755); err != nil {
-		return err
-	}
-	for _, file := range reader.File {
-		run := func() error {
-			path := filepath.Join(target, file.Name)
-			if file.FileInfo().IsDir() {
-				os.MkdirAll(path, file.Mode())
-				if file.Comment != "" && strings.Contains(file.Comment, "/") {
-					guid := strings.Split(file.Comment, "/")
-					if len(guid) == 2 {
-						uid, _ := strconv.Atoi(guid[0])
-						gid, _ := strconv.Atoi(guid[1])
-						if err := os.Chown(path, uid, gid); err != nil {
-							return fmt.Errorf("error changing owner: %v", err)
-						}
-					}
-				}
-				return nil
-			}
-
-			fileReader, err := file.Open()
-			if err != nil {
-				return fmt.Errorf("fileReader; error opening file: %v", err)
-			}
-			defer fileReader.Close()
-			targetFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-			if err != nil {
-				return fmt.Errorf("targetFile; error opening file: %v", err)
-			}
-			defer targetFile.Close()
-
-			if _, err := io.Copy(targetFile, fileReader); err != nil {
-				return fmt.Errorf("error copy file: %v", err)
-			}
-			if file.Comment != "" && strings.Contains(file.Comment, "/") {
-				guid := strings.Split(file.Comment, "/")
-				if len(guid) == 2 {
-					uid, _ := strconv.Atoi(guid[0])
-					gid, _ := strconv.Atoi(guid[1])
-					if err := os.Chown(path, uid, gid); err != nil {
-						return err
-					}
-				}
-			}
-			return nil
-		}
-		if err := run(); err != nil {
-			return err
-		}
-	}
+func Unzip(archiveFile, target string) error {
+	return archive.ExtractZip(archiveFile, target, archive.DefaultLimits)
+}
 
-	return nil
+//Untar tar -xf, the compression is detected from the content of the file
+func Untar(archiveFile, target string) error {
+	return archive.ExtractTarballFile(archiveFile, target)
 }
 
-//Untar tar -zxvf
-func Untar(archive, target string) error {
-	cmd := exec.Command("tar", "-xzf", archive, "-C", target)
-	if err := cmd.Run(); err != nil {
+//Extract extract the zip or tarball file, the format is detected from its content
+func Extract(archiveFile, target string) error {
+	isZip, err := archive.IsZipFile(archiveFile)
+	if err != nil {
 		return err
 	}
-	return nil
+	if isZip {
+		return Unzip(archiveFile, target)
+	}
+	return Untar(archiveFile, target)
 }
 
 //UnImagetar image-tar
-func UnImagetar(archive, target string) error {
-	cmd := exec.Command("tar", "-xf", archive, "-C", target)
-	if err := cmd.Run(); err != nil {
-		return err
-	}
-	return nil
+func UnImagetar(archiveFile, target string) error {
+	return archive.ExtractTarFile(archiveFile, target)
 }
 
 //GetFileList -
@@ -213,32 +159,5 @@ func FormatPath(s string) string {
 
 // CopyDir copy dir
 func CopyDir(src string, dest string) error {
-	_, err := os.Stat(dest)
-	if err != nil {
-		if !os.IsExist(err) {
-			err := os.MkdirAll(dest, 0755)
-			if err != nil {
-				fmt.Println("make and copy dir error", err)
-				return err
-			}
-		}
-	}
-	src = FormatPath(src)
-	dest = FormatPath(dest)
-
-	var cmd *exec.Cmd
-
-	switch runtime.GOOS {
-	case "windows":
-		cmd = exec.Command("xcopy", src, dest, "/I", "/E")
-	case "darwin", "linux":
-		cmd = exec.Command("cp", "-R", src, dest)
-	}
-	outPut, err := cmd.Output()
-	if err != nil {
-		fmt.Println("Output error: %s", err.Error())
-		return err
-	}
-	fmt.Println(outPut)
-	return nil
+	return archive.CopyDir(src, dest)
 }
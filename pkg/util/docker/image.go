@@ -33,6 +33,7 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 )
@@ -46,6 +47,12 @@ var ErrorNoImage = fmt.Errorf("image not exist")
 //ImagePull pull docker image
 //timeout minutes of the unit
 func ImagePull(dockerCli *client.Client, image string, username, password string, timeout int) (*types.ImageInspect, error) {
+	return ImagePullWithContext(context.Background(), dockerCli, image, username, password, timeout)
+}
+
+//ImagePullWithContext pull docker image, the pull stops when the context is canceled
+//timeout minutes of the unit
+func ImagePullWithContext(ctx context.Context, dockerCli *client.Client, image string, username, password string, timeout int) (*types.ImageInspect, error) {
 	var pullipo types.ImagePullOptions
 	if username != "" && password != "" {
 		auth, err := EncodeAuthToBase64(types.AuthConfig{Username: username, Password: password})
@@ -68,7 +75,7 @@ func ImagePull(dockerCli *client.Client, image string, username, password string
 	if timeout < 1 {
 		timeout = 1
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*time.Duration(timeout))
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*time.Duration(timeout))
 	defer cancel()
 	//TODO: 使用1.12版本api的bug “repository name must be canonical”，使用rf.String()完整的镜像地址
 	readcloser, err := dockerCli.ImagePull(ctx, rf.String(), pullipo)
@@ -81,6 +88,7 @@ func ImagePull(dockerCli *client.Client, image string, username, password string
 	}
 	defer readcloser.Close()
 	dec := json.NewDecoder(readcloser)
+	layers := newLayerProgress(progress.NewTracker(ctx, progress.PhasePull, image, 0))
 	for {
 		select {
 		case <-ctx.Done():
@@ -99,7 +107,9 @@ func ImagePull(dockerCli *client.Client, image string, username, password string
 			logrus.Debugf("error pulling image: %v", jm.Error)
 			return nil, jm.Error
 		}
+		layers.update(&jm)
 	}
+	layers.tracker.Done()
 	ins, _, err := dockerCli.ImageInspectWithRaw(ctx, image)
 	if err != nil {
 		return nil, err
@@ -109,7 +119,12 @@ func ImagePull(dockerCli *client.Client, image string, username, password string
 
 //ImageTag change docker image tag
 func ImageTag(dockerCli *client.Client, source, target string, timeout int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*time.Duration(timeout))
+	return ImageTagWithContext(context.Background(), dockerCli, source, target, timeout)
+}
+
+//ImageTagWithContext change docker image tag
+func ImageTagWithContext(ctx context.Context, dockerCli *client.Client, source, target string, timeout int) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*time.Duration(timeout))
 	defer cancel()
 	err := dockerCli.ImageTag(ctx, source, target)
 	if err != nil {
@@ -122,6 +137,12 @@ func ImageTag(dockerCli *client.Client, source, target string, timeout int) erro
 //ImagePush push image to registry
 //timeout minutes of the unit
 func ImagePush(dockerCli *client.Client, image, username, password string, timeout int) error {
+	return ImagePushWithContext(context.Background(), dockerCli, image, username, password, timeout)
+}
+
+//ImagePushWithContext push image to registry, the push stops when the context is canceled
+//timeout minutes of the unit
+func ImagePushWithContext(ctx context.Context, dockerCli *client.Client, image, username, password string, timeout int) error {
 	if timeout < 1 {
 		timeout = 1
 	}
@@ -142,7 +163,7 @@ func ImagePush(dockerCli *client.Client, image, username, password string, timeo
 	} else {
 		pushipo = types.ImagePushOptions{}
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*time.Duration(timeout))
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*time.Duration(timeout))
 	defer cancel()
 	readcloser, err := dockerCli.ImagePush(ctx, image, pushipo)
 	if err != nil {
@@ -154,6 +175,7 @@ func ImagePush(dockerCli *client.Client, image, username, password string, timeo
 	if readcloser != nil {
 		defer readcloser.Close()
 		dec := json.NewDecoder(readcloser)
+		layers := newLayerProgress(progress.NewTracker(ctx, progress.PhasePush, image, 0))
 		for {
 			select {
 			case <-ctx.Done():
@@ -170,11 +192,36 @@ func ImagePush(dockerCli *client.Client, image, username, password string, timeo
 			if jm.Error != nil {
 				return jm.Error
 			}
+			layers.update(&jm)
 		}
+		layers.tracker.Done()
 	}
 	return nil
 }
 
+// layerProgress sums the progress of the layers reported by the docker daemon
+type layerProgress struct {
+	tracker *progress.Tracker
+	layers  map[string]JSONProgress
+}
+
+func newLayerProgress(tracker *progress.Tracker) *layerProgress {
+	return &layerProgress{tracker: tracker, layers: make(map[string]JSONProgress)}
+}
+
+func (l *layerProgress) update(jm *JSONMessage) {
+	if jm.ID == "" || jm.Progress == nil || jm.Progress.Total <= 0 {
+		return
+	}
+	l.layers[jm.ID] = *jm.Progress
+	var current, total int64
+	for _, layer := range l.layers {
+		current += layer.Current
+		total += layer.Total
+	}
+	l.tracker.Set(current, total)
+}
+
 //TrustedImagePush push image to trusted registry
 func TrustedImagePush(dockerCli *client.Client, image, user, pass string, timeout int) error {
 	if err := CheckTrustedRepositories(image, user, pass); err != nil {
@@ -273,13 +320,23 @@ func MultiImageSave(ctx context.Context, dockerCli *client.Client, destination s
 		return err
 	}
 	defer rc.Close()
-	return CopyToFile(destination, rc)
+	tracker := progress.NewTracker(ctx, progress.PhaseSave, strings.Join(images, ","), 0)
+	if err := CopyToFile(destination, tracker.Reader(rc)); err != nil {
+		return err
+	}
+	tracker.Done()
+	return nil
 }
 
 //ImageLoad load image from  tar file
 // destination destination file name eg. /tmp/xxx.tar
 func ImageLoad(dockerCli *client.Client, tarFile string) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	return ImageLoadWithContext(context.Background(), dockerCli, tarFile)
+}
+
+//ImageLoadWithContext load image from tar file, the load stops when the context is canceled
+func ImageLoadWithContext(ctx context.Context, dockerCli *client.Client, tarFile string) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	reader, err := os.OpenFile(tarFile, os.O_RDONLY, 0644)
@@ -287,8 +344,13 @@ func ImageLoad(dockerCli *client.Client, tarFile string) error {
 		return err
 	}
 	defer reader.Close()
+	var total int64
+	if info, err := reader.Stat(); err == nil {
+		total = info.Size()
+	}
+	tracker := progress.NewTracker(ctx, progress.PhaseLoad, tarFile, total)
 
-	rc, err := dockerCli.ImageLoad(ctx, reader, false)
+	rc, err := dockerCli.ImageLoad(ctx, tracker.Reader(reader), false)
 	if err != nil {
 		return err
 	}
@@ -313,7 +375,7 @@ func ImageLoad(dockerCli *client.Client, tarFile string) error {
 			}
 		}
 	}
-
+	tracker.Done()
 	return nil
 }
 
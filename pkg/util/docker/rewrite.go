@@ -0,0 +1,114 @@
+// Copyright (C) 2014-2018 Goodrain Co., Ltd.
+// RAINBOND, Application Management Platform
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package docker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+// RewriteTarget the registry that the matched images are rewritten to
+type RewriteTarget struct {
+	v1alpha1.ImageInfo
+	// Insecure the registry is served over plain http
+	Insecure bool `json:"insecure"`
+}
+
+// RewriteRule rewrite the images of the matched registry and repository to the target.
+// Registry and Repository are prefixes of the original registry (eg. docker.io) and
+// repository (eg. library/nginx), or regular expressions if Regex is set. An empty
+// pattern matches everything.
+type RewriteRule struct {
+	Registry   string        `json:"registry"`
+	Repository string        `json:"repository"`
+	Regex      bool          `json:"regex"`
+	Target     RewriteTarget `json:"target"`
+
+	registry, repository *regexp.Regexp
+}
+
+func (r *RewriteRule) compile() error {
+	if r.Target.HubURL == "" {
+		return fmt.Errorf("rewrite rule %s/%s must define the target hub url", r.Registry, r.Repository)
+	}
+	if !r.Regex {
+		return nil
+	}
+	var err error
+	if r.registry, err = regexp.Compile(r.Registry); err != nil {
+		return fmt.Errorf("invalid registry pattern %s: %s", r.Registry, err.Error())
+	}
+	if r.repository, err = regexp.Compile(r.Repository); err != nil {
+		return fmt.Errorf("invalid repository pattern %s: %s", r.Repository, err.Error())
+	}
+	return nil
+}
+
+func (r *RewriteRule) match(registry, repository string) bool {
+	if r.Regex {
+		return r.registry.MatchString(registry) && r.repository.MatchString(repository)
+	}
+	return strings.HasPrefix(registry, r.Registry) && strings.HasPrefix(repository, r.Repository)
+}
+
+// Rewriter rewrite the image names by the first matched rule, the images that match
+// no rule are rewritten to the default hub like NewImageName
+type Rewriter struct {
+	defaultHub v1alpha1.ImageInfo
+	rules      []RewriteRule
+}
+
+// NewRewriter new rewriter
+func NewRewriter(defaultHub v1alpha1.ImageInfo, rules ...RewriteRule) (*Rewriter, error) {
+	compiled := make([]RewriteRule, len(rules))
+	for i := range rules {
+		compiled[i] = rules[i]
+		if err := compiled[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return &Rewriter{defaultHub: defaultHub, rules: compiled}, nil
+}
+
+// Rewrite returns the new image name and the registry it's pushed to
+func (r *Rewriter) Rewrite(source string) (string, RewriteTarget, error) {
+	named, err := reference.ParseNormalizedNamed(source)
+	if err != nil {
+		return "", RewriteTarget{}, err
+	}
+	target := RewriteTarget{ImageInfo: r.defaultHub}
+	for _, rule := range r.rules {
+		if rule.match(reference.Domain(named), reference.Path(named)) {
+			target = rule.Target
+			break
+		}
+	}
+	if target.HubURL == "" {
+		return "", RewriteTarget{}, fmt.Errorf("no rewrite rule matches image %s and no default hub url is defined", source)
+	}
+	name, err := NewImageName(source, target.ImageInfo)
+	if err != nil {
+		return "", RewriteTarget{}, err
+	}
+	return name, target, nil
+}
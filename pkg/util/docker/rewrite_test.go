@@ -0,0 +1,60 @@
+// Copyright (C) 2014-2018 Goodrain Co., Ltd.
+// RAINBOND, Application Management Platform
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/goodrain/rainbond-oam/pkg/ram/v1alpha1"
+)
+
+func TestRewriter(t *testing.T) {
+	rewriter, err := NewRewriter(v1alpha1.ImageInfo{HubURL: "hub.example.com", Namespace: "default"},
+		RewriteRule{Registry: "goodrain.me", Target: RewriteTarget{ImageInfo: v1alpha1.ImageInfo{HubURL: "team-a.example.com", HubUser: "a"}, Insecure: true}},
+		RewriteRule{Registry: `^docker\.io$`, Repository: `^library/`, Regex: true, Target: RewriteTarget{ImageInfo: v1alpha1.ImageInfo{HubURL: "mirror.example.com", Namespace: "library"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		source, image, user string
+		insecure            bool
+	}{
+		{source: "goodrain.me/app/web:v1", image: "team-a.example.com/web:v1", user: "a", insecure: true},
+		{source: "nginx:1.19", image: "mirror.example.com/library/nginx:1.19"},
+		{source: "docker.io/bitnami/redis", image: "hub.example.com/default/redis:latest"},
+	}
+	for _, tc := range tests {
+		image, target, err := rewriter.Rewrite(tc.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image != tc.image || target.HubUser != tc.user || target.Insecure != tc.insecure {
+			t.Errorf("rewrite %s: got %s %+v", tc.source, image, target)
+		}
+	}
+
+	if _, err := NewRewriter(v1alpha1.ImageInfo{}, RewriteRule{Registry: "(", Regex: true, Target: RewriteTarget{ImageInfo: v1alpha1.ImageInfo{HubURL: "x"}}}); err == nil {
+		t.Errorf("expected invalid pattern error")
+	}
+	rewriter, _ = NewRewriter(v1alpha1.ImageInfo{}, RewriteRule{Registry: "goodrain.me", Target: RewriteTarget{ImageInfo: v1alpha1.ImageInfo{HubURL: "x"}}})
+	if _, _, err := rewriter.Rewrite("nginx"); err == nil {
+		t.Errorf("expected error for the image without matched rule and default hub")
+	}
+}
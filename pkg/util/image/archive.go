@@ -0,0 +1,160 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxArchiveManifestSize the max size of the manifests read into memory
+const maxArchiveManifestSize = 8 << 20
+
+// ArchiveImage an image of an archive written by docker save or containerd export
+type ArchiveImage struct {
+	Name string
+	// Digest the digest of the manifest or index of the image, the image id if the
+	// archive has no oci layout
+	Digest digest.Digest
+	// Blobs the sizes of the config and layers of the image by their path in the archive
+	Blobs map[string]int64
+}
+
+// dockerManifest an entry of the manifest.json written by docker save and containerd export
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// ReadArchive read the images of the archive, the layers are skipped without being
+// decompressed. An empty archive has no image.
+func ReadArchive(r io.Reader) ([]ArchiveImage, error) {
+	var (
+		manifests []dockerManifest
+		index     ocispec.Index
+		sizes     = make(map[string]int64)
+		blobs     = make(map[string][]byte)
+	)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		sizes[name] = hdr.Size
+		switch {
+		case name == "manifest.json":
+			if err := json.NewDecoder(tr).Decode(&manifests); err != nil {
+				return nil, err
+			}
+		case name == "index.json":
+			if err := json.NewDecoder(tr).Decode(&index); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(name, "blobs/") && hdr.Size <= maxArchiveManifestSize:
+			// the manifests of the oci layout are needed to find the layers of an image
+			if blobs[name], err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var result []ArchiveImage
+	byName := make(map[string]int)
+	add := func(name string, dgst digest.Digest, paths map[string]int64) {
+		i, ok := byName[name]
+		if !ok {
+			byName[name] = len(result)
+			result = append(result, ArchiveImage{Name: name, Digest: dgst, Blobs: paths})
+			return
+		}
+		// the oci layout covers all the platforms of the image
+		if dgst != "" {
+			result[i].Digest = dgst
+		}
+		for p, size := range paths {
+			result[i].Blobs[p] = size
+		}
+	}
+	for _, m := range manifests {
+		paths := make(map[string]int64)
+		for _, p := range append([]string{m.Config}, m.Layers...) {
+			p = path.Clean(p)
+			paths[p] = sizes[p]
+		}
+		for _, tag := range m.RepoTags {
+			add(tag, configDigest(m.Config), paths)
+		}
+	}
+	for _, desc := range index.Manifests {
+		name := desc.Annotations[images.AnnotationImageName]
+		if name == "" {
+			name = desc.Annotations[ocispec.AnnotationRefName]
+		}
+		if name == "" {
+			continue
+		}
+		paths := make(map[string]int64)
+		walkArchive(desc, blobs, paths)
+		add(name, desc.Digest, paths)
+	}
+	return result, nil
+}
+
+// ReadArchiveFile read the images of the archive file
+func ReadArchiveFile(file string) ([]ArchiveImage, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadArchive(f)
+}
+
+// configDigest the image id of a docker save archive, the config is named by its digest
+func configDigest(config string) digest.Digest {
+	encoded := strings.TrimSuffix(path.Base(config), ".json")
+	dgst := digest.NewDigestFromEncoded(digest.SHA256, encoded)
+	if dgst.Validate() != nil {
+		return ""
+	}
+	return dgst
+}
+
+// walkArchive collect the descriptor and its children found in the oci layout
+func walkArchive(desc ocispec.Descriptor, blobs map[string][]byte, paths map[string]int64) {
+	p := path.Join("blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+	paths[p] = desc.Size
+	blob, ok := blobs[p]
+	if !ok || !images.IsManifestType(desc.MediaType) && !images.IsIndexType(desc.MediaType) {
+		return
+	}
+	var children struct {
+		Config    *ocispec.Descriptor  `json:"config"`
+		Layers    []ocispec.Descriptor `json:"layers"`
+		Manifests []ocispec.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(blob, &children); err != nil {
+		return
+	}
+	if children.Config != nil {
+		walkArchive(*children.Config, blobs, paths)
+	}
+	for _, child := range append(children.Layers, children.Manifests...) {
+		walkArchive(child, blobs, paths)
+	}
+}
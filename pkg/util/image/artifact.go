@@ -0,0 +1,169 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxArtifactBlobSize the max size of the config and layers of an artifact, they are
+// read into memory
+const maxArtifactBlobSize = 64 << 20
+
+// Artifact an oci artifact, an oci manifest whose config and layers are not the ones of
+// a container image. The media type of the config tells the type of the artifact.
+type Artifact struct {
+	ConfigMediaType string
+	Config          []byte
+	Layers          []ArtifactLayer
+	Annotations     map[string]string
+}
+
+// ArtifactLayer a layer of an artifact
+type ArtifactLayer struct {
+	MediaType   string
+	Data        []byte
+	Annotations map[string]string
+}
+
+// PushArtifact push the artifact to the reference in the registry, eg. registry/app:1.0.
+// It returns the descriptor of the manifest of the artifact.
+func PushArtifact(ctx context.Context, reference, username, password string, artifact *Artifact) (ocispec.Descriptor, error) {
+	named, err := normalizeImage(reference)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	ctx, cancel := withTimeout(ctx, 5)
+	defer cancel()
+	pusher, err := newRegistryResolver(ctx, username, password, nil).Pusher(ctx, named)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	manifest := ocispec.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Config:      blobDescriptor(artifact.ConfigMediaType, artifact.Config, nil),
+		Layers:      []ocispec.Descriptor{},
+		Annotations: artifact.Annotations,
+	}
+	if err := pushBlob(ctx, pusher, manifest.Config, artifact.Config); err != nil {
+		return ocispec.Descriptor{}, &PushError{Image: reference, Err: err}
+	}
+	for _, layer := range artifact.Layers {
+		desc := blobDescriptor(layer.MediaType, layer.Data, layer.Annotations)
+		if err := pushBlob(ctx, pusher, desc, layer.Data); err != nil {
+			return ocispec.Descriptor{}, &PushError{Image: reference, Err: err}
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := blobDescriptor(ocispec.MediaTypeImageManifest, body, nil)
+	if err := pushBlob(ctx, pusher, desc, body); err != nil {
+		return ocispec.Descriptor{}, &PushError{Image: reference, Err: err}
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePush, Image: reference, Current: desc.Size, Total: desc.Size, Message: "pushed artifact " + reference})
+	return desc, nil
+}
+
+// PullArtifact pull the artifact of the reference from the registry, the artifact of
+// another type than configMediaType is rejected if it's not empty
+func PullArtifact(ctx context.Context, reference, username, password, configMediaType string) (*Artifact, ocispec.Descriptor, error) {
+	named, err := normalizeImage(reference)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+	ctx, cancel := withTimeout(ctx, 5)
+	defer cancel()
+	resolver := newRegistryResolver(ctx, username, password, nil)
+	_, desc, err := resolver.Resolve(ctx, named)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		return nil, ocispec.Descriptor{}, fmt.Errorf("%s is not an oci artifact but %s", reference, desc.MediaType)
+	}
+	fetcher, err := resolver.Fetcher(ctx, named)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+	body, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+	if configMediaType != "" && manifest.Config.MediaType != configMediaType {
+		return nil, ocispec.Descriptor{}, fmt.Errorf("%s is not an artifact of %s but %s", reference, configMediaType, manifest.Config.MediaType)
+	}
+	artifact := &Artifact{ConfigMediaType: manifest.Config.MediaType, Annotations: manifest.Annotations}
+	if artifact.Config, err = fetchBlob(ctx, fetcher, manifest.Config); err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+	for _, layer := range manifest.Layers {
+		data, err := fetchBlob(ctx, fetcher, layer)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+		artifact.Layers = append(artifact.Layers, ArtifactLayer{MediaType: layer.MediaType, Data: data, Annotations: layer.Annotations})
+	}
+	progress.Publish(ctx, progress.Event{Phase: progress.PhasePull, Image: reference, Current: desc.Size, Total: desc.Size, Message: "pulled artifact " + reference})
+	return artifact, desc, nil
+}
+
+func blobDescriptor(mediaType string, data []byte, annotations map[string]string) ocispec.Descriptor {
+	return ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data)), Annotations: annotations}
+}
+
+// pushBlob push the blob or manifest, it's skipped if the registry already has it
+func pushBlob(ctx context.Context, pusher remotes.Pusher, desc ocispec.Descriptor, data []byte) error {
+	w, err := pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if err := w.Commit(ctx, desc.Size, desc.Digest); err != nil && !errdefs.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// fetchBlob fetch the blob and verify its digest
+func fetchBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	if desc.Size > maxArtifactBlobSize {
+		return nil, fmt.Errorf("blob %s of %d bytes is too large", desc.Digest, desc.Size)
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, desc.Size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != desc.Size || digest.FromBytes(data) != desc.Digest {
+		return nil, fmt.Errorf("blob %s does not match its descriptor", desc.Digest)
+	}
+	return data, nil
+}
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"fmt"
 	"github.com/containerd/containerd"
 	dockercli "github.com/docker/docker/client"
@@ -17,6 +18,17 @@ type Client interface {
 	ImageTag(source, target string, timeout int) error
 }
 
+// ContextClient the client whose operations stop when the context is canceled, the
+// clients of this package implement it
+type ContextClient interface {
+	Client
+	ImageSaveWithContext(ctx context.Context, destination string, images []string) error
+	ImageLoadWithContext(ctx context.Context, tarFile string) error
+	ImagePullWithContext(ctx context.Context, image string, username, password string, timeout int) (*ocispec.ImageConfig, error)
+	ImagePushWithContext(ctx context.Context, image, user, pass string, timeout int) error
+	ImageTagWithContext(ctx context.Context, source, target string, timeout int) error
+}
+
 func NewClient(client *containerd.Client, dockerCli *dockercli.Client) (c Client, err error) {
 	if client != nil {
 		return &containerdImageCliImpl{
@@ -30,3 +42,59 @@ func NewClient(client *containerd.Client, dockerCli *dockercli.Client) (c Client
 	}
 	return nil, fmt.Errorf("client is nil")
 }
+
+// ImageSaveWithContext save the images with the client, the clients that are not a
+// ContextClient are only checked for the canceled context before the operation
+func ImageSaveWithContext(ctx context.Context, c Client, destination string, images []string) error {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.ImageSaveWithContext(ctx, destination, images)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.ImageSave(destination, images)
+}
+
+// ImageLoadWithContext load the images with the client
+func ImageLoadWithContext(ctx context.Context, c Client, tarFile string) error {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.ImageLoadWithContext(ctx, tarFile)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.ImageLoad(tarFile)
+}
+
+// ImagePullWithContext pull the image with the client
+func ImagePullWithContext(ctx context.Context, c Client, image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.ImagePullWithContext(ctx, image, username, password, timeout)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.ImagePull(image, username, password, timeout)
+}
+
+// ImagePushWithContext push the image with the client
+func ImagePushWithContext(ctx context.Context, c Client, image, user, pass string, timeout int) error {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.ImagePushWithContext(ctx, image, user, pass, timeout)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.ImagePush(image, user, pass, timeout)
+}
+
+// ImageTagWithContext tag the image with the client
+func ImageTagWithContext(ctx context.Context, c Client, source, target string, timeout int) error {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.ImageTagWithContext(ctx, source, target, timeout)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.ImageTag(source, target, timeout)
+}
@@ -12,19 +12,18 @@ import (
 	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/images/archive"
 	"github.com/containerd/containerd/namespaces"
-	"github.com/containerd/containerd/pkg/progress"
-	"github.com/containerd/containerd/platforms"
 	refdocker "github.com/containerd/containerd/reference/docker"
 	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
 	"github.com/containerd/containerd/remotes/docker/config"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 	"os"
+	"strings"
 	"sync"
-	"text/tabwriter"
 	"time"
 )
 
@@ -35,7 +34,17 @@ type containerdImageCliImpl struct {
 //ImageSave save image to tar file
 // destination destination file name eg. /tmp/xxx.tar
 func (c *containerdImageCliImpl) ImageSave(destination string, images []string) error {
-	var exportOpts []archive.ExportOpt
+	return c.ImageSaveWithContext(context.Background(), destination, images)
+}
+
+//ImageSaveWithContext save image to tar file, the half-written file is removed on failure
+func (c *containerdImageCliImpl) ImageSaveWithContext(ctx context.Context, destination string, images []string) error {
+	matcher, err := platformMatcher(ctx)
+	if err != nil {
+		return err
+	}
+	// the index is kept in the archive, but only the manifests of the platforms are saved
+	exportOpts := []archive.ExportOpt{archive.WithPlatform(matcher)}
 	for _, image := range images {
 		ref, err := refdocker.ParseDockerRef(image)
 		if err != nil {
@@ -44,29 +53,39 @@ func (c *containerdImageCliImpl) ImageSave(destination string, images []string)
 		}
 		exportOpts = append(exportOpts, archive.WithImage(c.client.ImageService(), ref.String()))
 	}
-	ctx := namespaces.WithNamespace(context.Background(), Namespace)
+	ctx = namespaces.WithNamespace(ctx, Namespace)
 	w, err := os.Create(destination)
 	if err != nil {
 		return err
 	}
 	defer w.Close()
-	return c.client.Export(ctx, w, exportOpts...)
+	tracker := progress.NewTracker(ctx, progress.PhaseSave, strings.Join(images, ","), 0)
+	if err := c.client.Export(ctx, tracker.Writer(w), exportOpts...); err != nil {
+		os.Remove(destination)
+		return err
+	}
+	tracker.Done()
+	return nil
 }
 
 func (c *containerdImageCliImpl) ImagePull(image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	return c.ImagePullWithContext(context.Background(), image, username, password, timeout)
+}
+
+func (c *containerdImageCliImpl) ImagePullWithContext(ctx context.Context, image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
 	named, err := refdocker.ParseDockerRef(image)
 	if err != nil {
 		return nil, err
 	}
 	reference := named.String()
 	ongoing := ctrcontent.NewJobs(reference)
-	ctx := namespaces.WithNamespace(context.Background(), Namespace)
+	ctx = namespaces.WithNamespace(ctx, Namespace)
 	pctx, stopProgress := context.WithCancel(ctx)
-	progress := make(chan struct{})
-
+	progressDone := make(chan struct{})
+	tracker := progress.NewTracker(ctx, progress.PhasePull, image, 0)
 	go func() {
-		ctrcontent.ShowProgress(pctx, ongoing, c.client.ContentStore(), os.Stdout)
-		close(progress)
+		publishPullProgress(pctx, ongoing, c.client.ContentStore(), tracker)
+		close(progressDone)
 	}()
 	h := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
 		if desc.MediaType != images.MediaTypeDockerSchema1Manifest {
@@ -79,6 +98,7 @@ func (c *containerdImageCliImpl) ImagePull(image string, username, password stri
 	}
 	hostOpt := config.HostOptions{}
 	hostOpt.DefaultTLS = defaultTLS
+	hostOpt.DefaultScheme = registryScheme(ctx)
 	hostOpt.Credentials = func(host string) (string, string, error) {
 		return username, password, nil
 	}
@@ -88,7 +108,11 @@ func (c *containerdImageCliImpl) ImagePull(image string, username, password stri
 		Hosts:   config.ConfigureHosts(pctx, hostOpt),
 	}
 
-	platformMC := platforms.Ordered([]ocispec.Platform{platforms.DefaultSpec()}...)
+	platformMC, err := platformMatcher(ctx)
+	if err != nil {
+		stopProgress()
+		return nil, err
+	}
 	opts := []containerd.RemoteOpt{
 		containerd.WithImageHandler(h),
 		//nolint:staticcheck
@@ -97,15 +121,59 @@ func (c *containerdImageCliImpl) ImagePull(image string, username, password stri
 		containerd.WithResolver(docker.NewResolver(options)),
 	}
 	var img containerd.Image
-	img, err = c.client.Pull(pctx, reference, opts...)
+	if multiPlatform(ctx) {
+		// pull only fetches one manifest of an index, fetch the manifests of all the platforms
+		var record images.Image
+		record, err = c.client.Fetch(pctx, reference, opts...)
+		if err == nil {
+			img = containerd.NewImageWithPlatform(c.client, record, platformMC)
+		}
+	} else {
+		img, err = c.client.Pull(pctx, reference, opts...)
+	}
 	stopProgress()
+	<-progressDone
 	if err != nil {
 		return nil, err
 	}
-	<-progress
+	tracker.Done()
 	return getImageConfig(ctx, img)
 }
 
+// publishPullProgress publishes the bytes of the blobs pulled so far until the context is done
+func publishPullProgress(ctx context.Context, ongoing *ctrcontent.Jobs, cs content.Store, tracker *progress.Tracker) {
+	if !progress.Enabled(ctx) {
+		return
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			actives, err := cs.ListStatuses(ctx, "")
+			if err != nil {
+				continue
+			}
+			offsets := make(map[string]int64, len(actives))
+			for _, active := range actives {
+				offsets[active.Ref] = active.Offset
+			}
+			var current, total int64
+			for _, desc := range ongoing.Jobs() {
+				total += desc.Size
+				if offset, ok := offsets[remotes.MakeRefKey(ctx, desc)]; ok {
+					current += offset
+				} else if _, err := cs.Info(ctx, desc.Digest); err == nil {
+					current += desc.Size
+				}
+			}
+			tracker.Set(current, total)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func getImageConfig(ctx context.Context, image containerd.Image) (*ocispec.ImageConfig, error) {
 	desc, err := image.Config(ctx)
 	if err != nil {
@@ -131,58 +199,66 @@ func getImageConfig(ctx context.Context, image containerd.Image) (*ocispec.Image
 // ImageLoad load image from  tar file
 // destination destination file name eg. /tmp/xxx.tar
 func (c *containerdImageCliImpl) ImageLoad(tarFile string) error {
-	ctx := namespaces.WithNamespace(context.Background(), Namespace)
+	return c.ImageLoadWithContext(context.Background(), tarFile)
+}
+
+func (c *containerdImageCliImpl) ImageLoadWithContext(ctx context.Context, tarFile string) error {
+	ctx = namespaces.WithNamespace(ctx, Namespace)
 	reader, err := os.OpenFile(tarFile, os.O_RDONLY, 0644)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
-	if _, err = c.client.Import(ctx, reader); err != nil {
+	var total int64
+	if info, err := reader.Stat(); err == nil {
+		total = info.Size()
+	}
+	tracker := progress.NewTracker(ctx, progress.PhaseLoad, tarFile, total)
+	// keep the multi-arch index of the archive, so that all the platforms are pushed
+	if _, err = c.client.Import(ctx, tracker.Reader(reader), containerd.WithAllPlatforms(true)); err != nil {
 		return err
 	}
+	tracker.Done()
 	return nil
 }
 
 func (c *containerdImageCliImpl) ImagePush(image, user, pass string, timeout int) error {
+	return c.ImagePushWithContext(context.Background(), image, user, pass, timeout)
+}
+
+func (c *containerdImageCliImpl) ImagePushWithContext(ctx context.Context, image, user, pass string, timeout int) error {
 	named, err := refdocker.ParseDockerRef(image)
 	if err != nil {
 		return err
 	}
 	reference := named.String()
-	ctx := namespaces.WithNamespace(context.Background(), Namespace)
+	ctx = namespaces.WithNamespace(ctx, Namespace)
 	img, err := c.client.ImageService().Get(ctx, reference)
 	if err != nil {
 		return errors.Wrap(err, "unable to resolve image to manifest")
 	}
-	desc := img.Target
-	cs := c.client.ContentStore()
-	if manifests, err := images.Children(ctx, cs, desc); err == nil && len(manifests) > 0 {
-		matcher := platforms.NewMatcher(platforms.DefaultSpec())
-		for _, manifest := range manifests {
-			if manifest.Platform != nil && matcher.Match(*manifest.Platform) {
-				if _, err := images.Children(ctx, cs, manifest); err != nil {
-					return errors.Wrap(err, "no matching manifest")
-				}
-				desc = manifest
-				break
-			}
-		}
+	desc, matcher, err := pushTarget(ctx, c.client.ContentStore(), img.Target)
+	if err != nil {
+		return err
 	}
-	NewTracker := docker.NewInMemoryTracker()
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	tracker := newPushTracker()
 	options := docker.ResolverOptions{
-		Tracker: NewTracker,
+		Tracker: tracker,
 	}
 	hostOptions := config.HostOptions{
 		DefaultTLS: &tls.Config{
 			InsecureSkipVerify: true,
 		},
+		DefaultScheme: registryScheme(ctx),
 	}
 	hostOptions.Credentials = func(host string) (string, string, error) {
 		return user, pass, nil
 	}
 	options.Hosts = config.ConfigureHosts(ctx, hostOptions)
 	resolver := docker.NewResolver(options)
-	ongoing := newPushJobs(NewTracker)
+	ongoing := newPushJobs(tracker)
 
 	eg, ctx := errgroup.WithContext(ctx)
 	// used to notify the progress writer
@@ -197,36 +273,42 @@ func (c *containerdImageCliImpl) ImagePush(image, user, pass string, timeout int
 		ropts := []containerd.RemoteOpt{
 			containerd.WithResolver(resolver),
 			containerd.WithImageHandler(jobHandler),
+			containerd.WithImageHandlerWrapper(tracker.retryHandler),
+			containerd.WithPlatformMatcher(matcher),
 		}
 		return c.client.Push(ctx, reference, desc, ropts...)
 	})
 	eg.Go(func() error {
 		var (
-			ticker = time.NewTicker(100 * time.Millisecond)
-			fw     = progress.NewWriter(os.Stdout)
-			start  = time.Now()
-			done   bool
+			ticker  = time.NewTicker(100 * time.Millisecond)
+			tracker = progress.NewTracker(ctx, progress.PhasePush, image, 0)
+			done    bool
 		)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
-				fw.Flush()
-				tw := tabwriter.NewWriter(fw, 1, 8, 1, ' ', 0)
-				ctrcontent.Display(tw, ongoing.status(), start)
-				tw.Flush()
+				var current, total int64
+				for _, status := range ongoing.status() {
+					current += status.Offset
+					total += status.Total
+				}
+				tracker.Set(current, total)
 				if done {
-					fw.Flush()
+					tracker.Done()
 					return nil
 				}
 			case <-doneCh:
 				done = true
 			case <-ctx.Done():
-				done = true // allow ui to update once more
+				done = true // allow the progress to update once more
 			}
 		}
 	})
+	if err := eg.Wait(); err != nil {
+		return &PushError{Image: image, Err: err}
+	}
 	return nil
 }
 
@@ -291,6 +373,11 @@ func (j *pushjobs) status() []ctrcontent.StatusInfo {
 
 //ImageTag change docker image tag
 func (c *containerdImageCliImpl) ImageTag(source, target string, timeout int) error {
+	return c.ImageTagWithContext(context.Background(), source, target, timeout)
+}
+
+//ImageTagWithContext change docker image tag
+func (c *containerdImageCliImpl) ImageTagWithContext(ctx context.Context, source, target string, timeout int) error {
 	srcNamed, err := refdocker.ParseDockerRef(source)
 	if err != nil {
 		return err
@@ -302,7 +389,7 @@ func (c *containerdImageCliImpl) ImageTag(source, target string, timeout int) er
 	}
 	targetImage := targetNamed.String()
 	logrus.Infof(fmt.Sprintf("change image tag：%s -> %s", srcImage, targetImage))
-	ctx := namespaces.WithNamespace(context.Background(), Namespace)
+	ctx = namespaces.WithNamespace(ctx, Namespace)
 	imageService := c.client.ImageService()
 	image, err := imageService.Get(ctx, srcImage)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	dockercli "github.com/docker/docker/client"
 	"github.com/goodrain/rainbond-oam/pkg/util/docker"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
 )
 
 type dockerImageCliImpl struct {
@@ -14,13 +15,26 @@ type dockerImageCliImpl struct {
 //ImageSave save image to tar file
 // destination destination file name eg. /tmp/xxx.tar
 func (d *dockerImageCliImpl) ImageSave(destination string, images []string) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	return d.ImageSaveWithContext(context.Background(), destination, images)
+}
+
+//ImageSaveWithContext save image to tar file, the save stops when the context is canceled
+func (d *dockerImageCliImpl) ImageSaveWithContext(ctx context.Context, destination string, images []string) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	return docker.MultiImageSave(ctx, d.client, destination, images...)
 }
 
 func (d *dockerImageCliImpl) ImagePull(image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
-	img, err := docker.ImagePull(d.client, image, username, password, timeout)
+	return d.ImagePullWithContext(context.Background(), image, username, password, timeout)
+}
+
+func (d *dockerImageCliImpl) ImagePullWithContext(ctx context.Context, image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	if ps := Platforms(ctx); len(ps) > 0 {
+		// the docker daemon only stores the images of its own platform
+		logrus.Warningf("docker client pulls the image %s of the daemon platform, the platforms %v are ignored", image, ps)
+	}
+	img, err := docker.ImagePullWithContext(ctx, d.client, image, username, password, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -42,13 +56,25 @@ func (d *dockerImageCliImpl) ImagePull(image string, username, password string,
 }
 
 func (d *dockerImageCliImpl) ImageLoad(tarFile string) error {
-	return docker.ImageLoad(d.client, tarFile)
+	return d.ImageLoadWithContext(context.Background(), tarFile)
+}
+
+func (d *dockerImageCliImpl) ImageLoadWithContext(ctx context.Context, tarFile string) error {
+	return docker.ImageLoadWithContext(ctx, d.client, tarFile)
 }
 
 func (d *dockerImageCliImpl) ImagePush(image, user, pass string, timeout int) error {
-	return docker.ImagePush(d.client, image, user, pass, timeout)
+	return d.ImagePushWithContext(context.Background(), image, user, pass, timeout)
+}
+
+func (d *dockerImageCliImpl) ImagePushWithContext(ctx context.Context, image, user, pass string, timeout int) error {
+	return docker.ImagePushWithContext(ctx, d.client, image, user, pass, timeout)
 }
 
 func (d *dockerImageCliImpl) ImageTag(source, target string, timeout int) error {
-	return docker.ImageTag(d.client, source, target, timeout)
+	return d.ImageTagWithContext(context.Background(), source, target, timeout)
+}
+
+func (d *dockerImageCliImpl) ImageTagWithContext(ctx context.Context, source, target string, timeout int) error {
+	return docker.ImageTagWithContext(ctx, d.client, source, target, timeout)
 }
@@ -0,0 +1,414 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// MergeArchives write the images of the archives written by docker save or containerd
+// export into one oci image layout archive, the blobs shared by the images are stored
+// once. The archive has a manifest.json too, so that it can be loaded by docker.
+//
+// The blobs are kept in cacheDir if it's not empty, the blobs already in the cache are
+// not written again. The cache is not cleaned, PruneBlobCache limits its size.
+func MergeArchives(ctx context.Context, dst, cacheDir string, archives ...string) error {
+	layout, err := NewLayout(filepath.Dir(dst), cacheDir)
+	if err != nil {
+		return err
+	}
+	defer layout.Close()
+	for _, archive := range archives {
+		if err := layout.Add(ctx, archive); err != nil {
+			return err
+		}
+	}
+	return layout.Write(ctx, dst)
+}
+
+// Layout the images merged into an oci image layout. The archives are added one by one,
+// so that the caller can remove an archive as soon as its blobs are stored.
+type Layout struct {
+	store blobStore
+	// temporary the blobs are not cached, they are removed as soon as they are written
+	temporary bool
+	manifests []ocispec.Descriptor
+	docker    []dockerManifest
+	// blobs the sizes of the blobs of the images
+	blobs map[digest.Digest]int64
+}
+
+// NewLayout create a layout storing the blobs in cacheDir, if cacheDir is empty the
+// blobs are stored in a temporary dir under tmpDir
+func NewLayout(tmpDir, cacheDir string) (*Layout, error) {
+	store := blobStore{dir: cacheDir}
+	if cacheDir == "" {
+		tmp, err := ioutil.TempDir(tmpDir, ".blobs-")
+		if err != nil {
+			return nil, err
+		}
+		store.dir = tmp
+	}
+	return &Layout{store: store, temporary: cacheDir == "", blobs: make(map[digest.Digest]int64)}, nil
+}
+
+// Close remove the temporary blobs
+func (l *Layout) Close() error {
+	if l.temporary {
+		return os.RemoveAll(l.store.dir)
+	}
+	return nil
+}
+
+// Empty the layout has no images
+func (l *Layout) Empty() bool {
+	return len(l.manifests) == 0 && len(l.docker) == 0
+}
+
+// Add the images of the archive written by docker save or containerd export
+func (l *Layout) Add(ctx context.Context, archive string) error {
+	if err := l.add(ctx, archive); err != nil {
+		return fmt.Errorf("merge image archive %s failure %s", archive, err.Error())
+	}
+	return nil
+}
+
+// add the images of the archive, the blobs are put into the store
+func (l *Layout) add(ctx context.Context, archive string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var (
+		docker []dockerManifest
+		index  ocispec.Index
+		paths  = make(map[string]digest.Digest)
+		sizes  = make(map[digest.Digest]int64)
+		// created the blobs stored by this archive, not the ones already in the store
+		created = make(map[digest.Digest]bool)
+		// symlinks the layers linked by docker save to a layer of another image
+		symlinks = make(map[string]string)
+	)
+	tr := tar.NewReader(f)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag == tar.TypeSymlink {
+			symlinks[name] = path.Join(path.Dir(name), hdr.Linkname)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		switch name {
+		case "manifest.json":
+			err = json.NewDecoder(tr).Decode(&docker)
+		case "index.json":
+			err = json.NewDecoder(tr).Decode(&index)
+		case ocispec.ImageLayoutFile, "repositories":
+		default:
+			var (
+				dgst  digest.Digest
+				isNew bool
+			)
+			if dgst, isNew, err = l.store.put(tr, blobDigest(name)); err == nil {
+				paths[name] = dgst
+				sizes[dgst] = hdr.Size
+				created[dgst] = created[dgst] || isNew
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for name, target := range symlinks {
+		if dgst, ok := paths[target]; ok {
+			paths[name] = dgst
+		}
+	}
+	// only the blobs of the oci layout and the configs and layers of manifest.json are kept,
+	// the other files of docker save such as <id>/json and <id>/VERSION are dropped
+	referenced := make(map[digest.Digest]bool)
+	for name, dgst := range paths {
+		if blobDigest(name) != "" {
+			referenced[dgst] = true
+		}
+	}
+	for _, m := range docker {
+		for _, p := range append([]string{m.Config}, m.Layers...) {
+			if dgst, ok := paths[path.Clean(p)]; ok {
+				referenced[dgst] = true
+			}
+		}
+	}
+	for dgst, size := range sizes {
+		if referenced[dgst] {
+			l.blobs[dgst] = size
+		} else if _, ok := l.blobs[dgst]; !ok && created[dgst] {
+			os.Remove(l.store.path(dgst))
+		}
+	}
+	manifests := index.Manifests
+	if len(manifests) == 0 {
+		// the archives written by docker have no oci index
+		for _, m := range docker {
+			desc, err := l.legacyManifest(m, paths)
+			if err != nil {
+				return err
+			}
+			for _, tag := range m.RepoTags {
+				desc := desc
+				desc.Annotations = map[string]string{images.AnnotationImageName: tag, ocispec.AnnotationRefName: tag}
+				manifests = append(manifests, desc)
+			}
+		}
+	}
+	l.manifests = append(l.manifests, manifests...)
+	for _, m := range docker {
+		m.Config = blobPath(paths[path.Clean(m.Config)])
+		for i := range m.Layers {
+			m.Layers[i] = blobPath(paths[path.Clean(m.Layers[i])])
+		}
+		l.docker = append(l.docker, m)
+	}
+	return nil
+}
+
+// legacyManifest create the oci manifest of an image of an archive written by docker,
+// the layers of docker save are uncompressed
+func (l *Layout) legacyManifest(m dockerManifest, paths map[string]digest.Digest) (ocispec.Descriptor, error) {
+	descriptor := func(p, mediaType string) (ocispec.Descriptor, error) {
+		dgst, ok := paths[path.Clean(p)]
+		if !ok {
+			return ocispec.Descriptor{}, fmt.Errorf("%s not found", p)
+		}
+		return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: l.blobs[dgst]}, nil
+	}
+	config, err := descriptor(m.Config, ocispec.MediaTypeImageConfig)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	manifest := ocispec.Manifest{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: ocispec.MediaTypeImageManifest, Config: config}
+	for _, layer := range m.Layers {
+		desc, err := descriptor(layer, ocispec.MediaTypeImageLayer)
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	dgst, _, err := l.store.put(bytes.NewReader(body), "")
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	l.blobs[dgst] = int64(len(body))
+	desc := ocispec.Descriptor{MediaType: manifest.MediaType, Digest: dgst, Size: int64(len(body))}
+	var image ocispec.Image
+	if body, err := ioutil.ReadFile(l.store.path(config.Digest)); err == nil && json.Unmarshal(body, &image) == nil && image.OS != "" {
+		desc.Platform = &ocispec.Platform{OS: image.OS, Architecture: image.Architecture}
+	}
+	return desc, nil
+}
+
+// Write the oci image layout archive, the temporary blobs are removed once they are written
+func (l *Layout) Write(ctx context.Context, dst string) (err error) {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	tw := tar.NewWriter(f)
+	writeJSON := func(name string, v interface{}) error {
+		body, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0444, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err = tw.Write(body)
+		return err
+	}
+	if err := writeJSON(ocispec.ImageLayoutFile, ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion}); err != nil {
+		return err
+	}
+	index := ocispec.Index{Versioned: specs.Versioned{SchemaVersion: 2}, Manifests: l.manifests}
+	if err := writeJSON("index.json", index); err != nil {
+		return err
+	}
+	if err := writeJSON("manifest.json", l.docker); err != nil {
+		return err
+	}
+	dgsts := make([]digest.Digest, 0, len(l.blobs))
+	for dgst := range l.blobs {
+		dgsts = append(dgsts, dgst)
+	}
+	sort.Slice(dgsts, func(i, j int) bool { return dgsts[i] < dgsts[j] })
+	for _, dgst := range dgsts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: blobPath(dgst), Mode: 0444, Size: l.blobs[dgst], Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		blob, err := os.Open(l.store.path(dgst))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, blob)
+		blob.Close()
+		if err != nil {
+			return err
+		}
+		if l.temporary {
+			os.Remove(l.store.path(dgst))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// blobStore the content addressed blobs, stored as <algorithm>/<encoded>
+type blobStore struct {
+	dir string
+}
+
+func (b blobStore) path(dgst digest.Digest) string {
+	return filepath.Join(b.dir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// put store the blob, known is the digest of the blob if it's known before it's read,
+// the blob is skipped if the store already has it. It returns whether the blob is new to
+// the store, the blobs already there are marked used for PruneBlobCache.
+func (b blobStore) put(r io.Reader, known digest.Digest) (digest.Digest, bool, error) {
+	if known != "" {
+		if b.touch(known) {
+			_, err := io.Copy(ioutil.Discard, r)
+			return known, false, err
+		}
+	}
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return "", false, err
+	}
+	tmp, err := ioutil.TempFile(b.dir, ".tmp-")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.Remove(tmp.Name())
+	digester := digest.Canonical.Digester()
+	_, err = io.Copy(io.MultiWriter(tmp, digester.Hash()), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", false, err
+	}
+	dgst := digester.Digest()
+	if known != "" && known != dgst {
+		return "", false, fmt.Errorf("blob %s does not match its digest %s", known, dgst)
+	}
+	if b.touch(dgst) {
+		return dgst, false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path(dgst)), 0755); err != nil {
+		return "", false, err
+	}
+	return dgst, true, os.Rename(tmp.Name(), b.path(dgst))
+}
+
+// touch mark the blob used, it returns false if the store has no such blob
+func (b blobStore) touch(dgst digest.Digest) bool {
+	now := time.Now()
+	return os.Chtimes(b.path(dgst), now, now) == nil
+}
+
+// PruneBlobCache remove the least recently used blobs of the cache dir of MergeArchives
+// until the blobs take at most maxSize bytes
+func PruneBlobCache(cacheDir string, maxSize int64) error {
+	type blob struct {
+		file string
+		info os.FileInfo
+	}
+	var (
+		blobs []blob
+		total int64
+	)
+	err := filepath.Walk(cacheDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".tmp-") {
+			blobs = append(blobs, blob{file: file, info: info})
+			total += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].info.ModTime().Before(blobs[j].info.ModTime()) })
+	for _, b := range blobs {
+		if total <= maxSize {
+			break
+		}
+		if err := os.Remove(b.file); err != nil {
+			return err
+		}
+		total -= b.info.Size()
+	}
+	return nil
+}
+
+// blobDigest the digest of the blob of an oci image layout by its path
+func blobDigest(name string) digest.Digest {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] != "blobs" {
+		return ""
+	}
+	dgst := digest.NewDigestFromEncoded(digest.Algorithm(parts[1]), parts[2])
+	if dgst.Validate() != nil {
+		return ""
+	}
+	return dgst
+}
+
+func blobPath(dgst digest.Digest) string {
+	return path.Join("blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
@@ -0,0 +1,165 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeDockerArchive writes an archive like docker save of an image with the layers
+func writeDockerArchive(t *testing.T, file, tag string, layers ...string) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	write := func(name string, body []byte) {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg})
+		tw.Write(body)
+	}
+	config, _ := json.Marshal(ocispec.Image{OS: runtime.GOOS, Architecture: runtime.GOARCH, Config: ocispec.ImageConfig{Env: []string{"TAG=" + tag}}})
+	manifest := dockerManifest{Config: digest.FromBytes(config).Encoded() + ".json", RepoTags: []string{tag}}
+	write(manifest.Config, config)
+	for _, layer := range layers {
+		id := digest.FromString(layer).Encoded()
+		name := id + "/layer.tar"
+		write(name, []byte(layer))
+		// the legacy files of docker save that no manifest references
+		write(id+"/json", []byte(`{"id":"`+id+`"}`))
+		write(id+"/VERSION", []byte("1.0"))
+		manifest.Layers = append(manifest.Layers, name)
+	}
+	body, _ := json.Marshal([]dockerManifest{manifest})
+	write("manifest.json", body)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeArchives(t *testing.T) {
+	dir := t.TempDir()
+	component, plugin := filepath.Join(dir, "component-images.tar"), filepath.Join(dir, "plugin-images.tar")
+	writeDockerArchive(t, component, "goodrain.me/app:1.0", "base-layer", "app-layer")
+	writeDockerArchive(t, plugin, "goodrain.me/plugin:1.0", "base-layer", "plugin-layer")
+
+	cache := filepath.Join(dir, "cache")
+	merged := filepath.Join(dir, "images.tar")
+	if err := MergeArchives(context.Background(), merged, cache, component, plugin); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var blobs int
+	tr := tar.NewReader(f)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		if strings.HasPrefix(hdr.Name, "blobs/") {
+			blobs++
+		}
+	}
+	// 2 configs, 2 manifests and 3 layers, the base layer is stored once
+	if blobs != 7 {
+		t.Errorf("expected 7 blobs, got %d", blobs)
+	}
+	archived, err := ReadArchiveFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 2 {
+		t.Errorf("expected 2 images, got %+v", archived)
+	}
+	cached, _ := ioutil.ReadDir(path.Join(cache, "sha256"))
+	if len(cached) != 7 {
+		t.Errorf("expected 7 blobs cached, got %d", len(cached))
+	}
+	for _, blob := range cached {
+		var manifest ocispec.Manifest
+		body, _ := ioutil.ReadFile(path.Join(cache, "sha256", blob.Name()))
+		if json.Unmarshal(body, &manifest) != nil || manifest.MediaType == "" {
+			continue
+		}
+		// docker save writes uncompressed layers
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != ocispec.MediaTypeImageLayer {
+				t.Errorf("unexpected media type %s of layer %s", layer.MediaType, layer.Digest)
+			}
+		}
+	}
+	// the blobs in the cache are reused
+	if err := PruneBlobCache(cache, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := ioutil.ReadDir(path.Join(cache, "sha256")); len(cached) != 7 {
+		t.Errorf("the cache under the limit should not be pruned, got %d blobs", len(cached))
+	}
+	if err := MergeArchives(context.Background(), merged, cache, component, plugin); err != nil {
+		t.Fatal(err)
+	}
+
+	// the temporary blobs are removed
+	if err := MergeArchives(context.Background(), merged, "", component, plugin); err != nil {
+		t.Fatal(err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, ".blobs-*")); len(tmp) != 0 {
+		t.Errorf("the temporary blobs are not removed: %v", tmp)
+	}
+
+	loader, err := NewRegistryClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.ImageLoad(merged); err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range []string{"goodrain.me/app:1.0", "goodrain.me/plugin:1.0"} {
+		if err := loader.ImageTag(image, image+"-copy", 1); err != nil {
+			t.Errorf("the image %s is not loaded: %v", image, err)
+		}
+	}
+}
+
+func TestPruneBlobCache(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "component-images.tar")
+	writeDockerArchive(t, archive, "goodrain.me/app:1.0", "base-layer", "app-layer")
+	cache := filepath.Join(dir, "cache")
+	if err := MergeArchives(context.Background(), filepath.Join(dir, "images.tar"), cache, archive); err != nil {
+		t.Fatal(err)
+	}
+	// the base layer is the least recently used blob
+	old := time.Now().Add(-time.Hour)
+	base := filepath.Join(cache, "sha256", digest.FromString("base-layer").Encoded())
+	if err := os.Chtimes(base, old, old); err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	filepath.Walk(cache, func(_ string, info os.FileInfo, _ error) error {
+		if !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	if err := PruneBlobCache(cache, total-1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(base); !os.IsNotExist(err) {
+		t.Errorf("the least recently used blob should be removed")
+	}
+	if cached, _ := ioutil.ReadDir(filepath.Join(cache, "sha256")); len(cached) != 3 {
+		t.Errorf("only the least recently used blob should be removed, got %d blobs", len(cached))
+	}
+}
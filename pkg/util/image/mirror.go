@@ -0,0 +1,122 @@
+package image
+
+import (
+	"context"
+	"fmt"
+
+	refdocker "github.com/containerd/containerd/reference/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+type insecureKey struct{}
+
+// WithInsecure return a context whose registry requests are sent over plain http
+func WithInsecure(ctx context.Context) context.Context {
+	return context.WithValue(ctx, insecureKey{}, true)
+}
+
+func insecure(ctx context.Context) bool {
+	v, _ := ctx.Value(insecureKey{}).(bool)
+	return v
+}
+
+// registryScheme the scheme of the registry requests of the context
+func registryScheme(ctx context.Context) string {
+	if insecure(ctx) {
+		return "http"
+	}
+	return "https"
+}
+
+// Mirror the mirrors that the images of a registry are pulled from
+type Mirror struct {
+	// Registry the original registry, eg. docker.io, empty matches all registries
+	Registry string `json:"registry"`
+	// Endpoints the mirror registries tried in order, eg. mirror.example.com/dockerhub
+	Endpoints []string `json:"endpoints"`
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	// Insecure the mirrors are served over plain http
+	Insecure bool `json:"insecure"`
+}
+
+// mirrorClient pulls the images from the mirrors first, and tags them with the
+// original name so that they are saved and pushed as usual
+type mirrorClient struct {
+	Client
+	mirrors []Mirror
+}
+
+// NewMirrorClient wraps the client so that images are pulled from the mirrors of their
+// registry first, the original registry is used if all the mirrors fail
+func NewMirrorClient(client Client, mirrors ...Mirror) Client {
+	if len(mirrors) == 0 {
+		return client
+	}
+	return &mirrorClient{Client: client, mirrors: mirrors}
+}
+
+func (m *mirrorClient) ImagePull(image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	return m.ImagePullWithContext(context.Background(), image, username, password, timeout)
+}
+
+func (m *mirrorClient) ImagePullWithContext(ctx context.Context, image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return ImagePullWithContext(ctx, m.Client, image, username, password, timeout)
+	}
+	for _, mirror := range m.mirrors {
+		if mirror.Registry != "" && mirror.Registry != refdocker.Domain(named) {
+			continue
+		}
+		for _, endpoint := range mirror.Endpoints {
+			mirrorImage := mirrorImageName(named, endpoint)
+			pullCtx := ctx
+			if mirror.Insecure {
+				pullCtx = WithInsecure(ctx)
+			}
+			config, err := ImagePullWithContext(pullCtx, m.Client, mirrorImage, mirror.Username, mirror.Password, timeout)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				logrus.Warningf("pull image %s from mirror failure %s", mirrorImage, err.Error())
+				continue
+			}
+			if err := ImageTagWithContext(ctx, m.Client, mirrorImage, image, timeout); err != nil {
+				return nil, err
+			}
+			return config, nil
+		}
+	}
+	return ImagePullWithContext(ctx, m.Client, image, username, password, timeout)
+}
+
+func (m *mirrorClient) ImageSaveWithContext(ctx context.Context, destination string, images []string) error {
+	return ImageSaveWithContext(ctx, m.Client, destination, images)
+}
+
+func (m *mirrorClient) ImageLoadWithContext(ctx context.Context, tarFile string) error {
+	return ImageLoadWithContext(ctx, m.Client, tarFile)
+}
+
+func (m *mirrorClient) ImagePushWithContext(ctx context.Context, image, user, pass string, timeout int) error {
+	return ImagePushWithContext(ctx, m.Client, image, user, pass, timeout)
+}
+
+func (m *mirrorClient) ImageTagWithContext(ctx context.Context, source, target string, timeout int) error {
+	return ImageTagWithContext(ctx, m.Client, source, target, timeout)
+}
+
+// mirrorImageName replace the registry of the image with the mirror endpoint
+func mirrorImageName(named refdocker.Named, endpoint string) string {
+	name := fmt.Sprintf("%s/%s", endpoint, refdocker.Path(named))
+	if digested, ok := named.(refdocker.Digested); ok {
+		return name + "@" + digested.Digest().String()
+	}
+	if tagged, ok := named.(refdocker.Tagged); ok {
+		return name + ":" + tagged.Tag()
+	}
+	return name
+}
@@ -0,0 +1,53 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// pullRecorder records the pulls and tags, the pulls of the failed images fail
+type pullRecorder struct {
+	ContextClient
+	failed map[string]bool
+	pulled []string
+	tagged map[string]string
+}
+
+func (p *pullRecorder) ImagePullWithContext(ctx context.Context, image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	p.pulled = append(p.pulled, image)
+	if p.failed[image] {
+		return nil, errors.New("not found")
+	}
+	return &ocispec.ImageConfig{}, nil
+}
+
+func (p *pullRecorder) ImageTagWithContext(ctx context.Context, source, target string, timeout int) error {
+	p.tagged[target] = source
+	return nil
+}
+
+func TestMirrorClient(t *testing.T) {
+	recorder := &pullRecorder{
+		failed: map[string]bool{"mirror-a.example.com/library/nginx:1.19": true},
+		tagged: make(map[string]string),
+	}
+	client := NewMirrorClient(recorder, Mirror{
+		Registry:  "docker.io",
+		Endpoints: []string{"mirror-a.example.com", "mirror-b.example.com"},
+	})
+	if _, err := client.ImagePull("nginx:1.19", "", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if source := recorder.tagged["nginx:1.19"]; source != "mirror-b.example.com/library/nginx:1.19" {
+		t.Errorf("the image is not pulled from the second mirror: %v", recorder.pulled)
+	}
+	if _, err := client.ImagePull("goodrain.me/web:v1", "", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if last := recorder.pulled[len(recorder.pulled)-1]; last != "goodrain.me/web:v1" {
+		t.Errorf("the image without mirror should be pulled from its registry, got %s", last)
+	}
+}
@@ -0,0 +1,68 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type platformsKey struct{}
+
+// WithPlatforms return a context whose image pulls and saves cover the platforms, eg.
+// linux/amd64 or arm64. The first platform is preferred when only one can be used.
+func WithPlatforms(ctx context.Context, platforms ...string) context.Context {
+	var ps []string
+	for _, p := range platforms {
+		if p = strings.TrimSpace(p); p != "" {
+			ps = append(ps, p)
+		}
+	}
+	return context.WithValue(ctx, platformsKey{}, ps)
+}
+
+// Platforms the platforms requested by the context, empty means the default platform
+func Platforms(ctx context.Context) []string {
+	ps, _ := ctx.Value(platformsKey{}).([]string)
+	return ps
+}
+
+// ParsePlatforms parse the comma separated platforms, eg. the arch of a component
+func ParsePlatforms(s string) ([]string, error) {
+	var ps []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		spec, err := platforms.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform %s: %s", p, err.Error())
+		}
+		ps = append(ps, platforms.Format(spec))
+	}
+	return ps, nil
+}
+
+// platformMatcher the matcher of the platforms requested by the context
+func platformMatcher(ctx context.Context) (platforms.MatchComparer, error) {
+	ps := Platforms(ctx)
+	if len(ps) == 0 {
+		return platforms.Default(), nil
+	}
+	var specs []ocispec.Platform
+	for _, p := range ps {
+		spec, err := platforms.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform %s: %s", p, err.Error())
+		}
+		specs = append(specs, platforms.Normalize(spec))
+	}
+	return platforms.Ordered(specs...), nil
+}
+
+// multiPlatform whether the context requests more than one platform
+func multiPlatform(ctx context.Context) bool {
+	return len(Platforms(ctx)) > 1
+}
@@ -0,0 +1,150 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Pool runs image jobs with bounded concurrency, the jobs of the same image run once
+// and the jobs failed by transient errors are retried with backoff
+type Pool struct {
+	// Concurrency the max number of jobs running at the same time
+	Concurrency int
+	// Retries the times a failed job is retried
+	Retries int
+	// Backoff the interval before the first retry, it doubles on every retry
+	Backoff time.Duration
+}
+
+// DefaultPool the pool used if the context has none
+var DefaultPool = Pool{Concurrency: 4, Retries: 2, Backoff: 2 * time.Second}
+
+type poolKey struct{}
+
+// WithPool return a context whose image jobs run in the pool
+func WithPool(ctx context.Context, pool Pool) context.Context {
+	return context.WithValue(ctx, poolKey{}, pool)
+}
+
+// PoolFromContext the pool of the context, DefaultPool if none
+func PoolFromContext(ctx context.Context) Pool {
+	if pool, ok := ctx.Value(poolKey{}).(Pool); ok {
+		return pool
+	}
+	return DefaultPool
+}
+
+// Job an operation of an image
+type Job struct {
+	Image string
+	Run   func(ctx context.Context) error
+}
+
+// JobError the error of a job
+type JobError struct {
+	Image string
+	Err   error
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Image, e.Err.Error())
+}
+
+// Unwrap returns the error of the job
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// JobErrors the errors of all the failed jobs
+type JobErrors []*JobError
+
+func (e JobErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d image jobs failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Run runs the jobs and waits for all of them, the jobs of an image that already has
+// a job are skipped. It returns the context error if the context is done, otherwise
+// JobErrors of the failed jobs.
+func (p Pool) Run(ctx context.Context, jobs ...Job) error {
+	concurrency := p.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs JobErrors
+		sem  = make(chan struct{}, concurrency)
+		seen = make(map[string]bool)
+	)
+	for _, job := range jobs {
+		if seen[job.Image] {
+			continue
+		}
+		seen[job.Image] = true
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			if err := p.retry(ctx, job); err != nil {
+				mu.Lock()
+				errs = append(errs, &JobError{Image: job.Image, Err: err})
+				mu.Unlock()
+			}
+		}(job)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p Pool) retry(ctx context.Context, job Job) error {
+	backoff := p.Backoff
+	for i := 0; ; i++ {
+		err := job.Run(ctx)
+		if err == nil || i >= p.Retries || !retryable(ctx, err) {
+			return err
+		}
+		logrus.Warningf("image %s job failure %s, retry in %s", job.Image, err.Error(), backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// retryable whether the failed job may succeed if it's retried, only the transient network
+// and server side failures are retried. The pushes retry their uploads themselves, so a
+// failed push is not retried again.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var pushErr *PushError
+	if errors.As(err, &pushErr) {
+		return false
+	}
+	return isTransient(err)
+}
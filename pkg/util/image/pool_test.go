@@ -0,0 +1,80 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPoolRun(t *testing.T) {
+	var running, max, runs int32
+	var mu sync.Mutex
+	job := func(ctx context.Context) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		mu.Lock()
+		if n > max {
+			max = n
+		}
+		mu.Unlock()
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	var jobs []Job
+	for _, name := range []string{"a", "b", "c", "d", "a", "b"} {
+		jobs = append(jobs, Job{Image: name, Run: job})
+	}
+	if err := (Pool{Concurrency: 2}).Run(context.Background(), jobs...); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 4 {
+		t.Errorf("the jobs of the same image should run once, got %d runs", runs)
+	}
+	if max > 2 {
+		t.Errorf("expected at most 2 jobs running at the same time, got %d", max)
+	}
+}
+
+func TestPoolRetry(t *testing.T) {
+	var flaky, daemon, broken, denied int32
+	pool := Pool{Concurrency: 2, Retries: 2, Backoff: time.Millisecond}
+	err := pool.Run(context.Background(),
+		Job{Image: "flaky", Run: func(ctx context.Context) error {
+			if atomic.AddInt32(&flaky, 1) < 3 {
+				return fmt.Errorf("pull flaky failure: %w", syscall.ECONNRESET)
+			}
+			return nil
+		}},
+		Job{Image: "daemon", Run: func(ctx context.Context) error {
+			if atomic.AddInt32(&daemon, 1) < 2 {
+				return errors.New("Error response from daemon: Get https://goodrain.me/v2/: net/http: TLS handshake timeout")
+			}
+			return nil
+		}},
+		Job{Image: "broken", Run: func(ctx context.Context) error {
+			atomic.AddInt32(&broken, 1)
+			return errors.New("manifest unknown: not found")
+		}},
+		Job{Image: "denied", Run: func(ctx context.Context) error {
+			atomic.AddInt32(&denied, 1)
+			// the transient push failures are retried by the pusher already
+			return &PushError{Image: "denied", Err: syscall.ECONNRESET}
+		}},
+	)
+	var errs JobErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected the errors of 2 jobs, got %v", err)
+	}
+	if flaky != 3 || daemon != 2 {
+		t.Errorf("expected the transient failures retried, got %d and %d runs", flaky, daemon)
+	}
+	if broken != 1 || denied != 1 {
+		t.Errorf("expected the permanent failures and pushes not retried, got %d and %d runs", broken, denied)
+	}
+}
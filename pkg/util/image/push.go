@@ -0,0 +1,202 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// pushRetries the times a blob upload is retried after a transient failure
+	pushRetries = 3
+	// pushRetryInterval the interval before the first retry, it doubles on every retry
+	pushRetryInterval = time.Second
+)
+
+// PushError the error of pushing an image to the registry
+type PushError struct {
+	Image string
+	Err   error
+}
+
+func (e *PushError) Error() string {
+	return fmt.Sprintf("push image %s failure: %s", e.Image, e.Err.Error())
+}
+
+// Unwrap returns the cause of the failure
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the push may succeed if it's retried later
+func (e *PushError) Temporary() bool {
+	return isTransient(e.Err)
+}
+
+// isTransient reports whether the error is a network or server side failure that may
+// not happen again
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var status remoteserrors.ErrUnexpectedStatus
+	if errors.As(err, &status) {
+		return status.StatusCode >= http.StatusInternalServerError || status.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	// the docker daemon returns the failures as messages only
+	msg := err.Error()
+	for _, transient := range transientMessages {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// transientMessages the messages of the network failures reported by the docker daemon
+var transientMessages = []string{
+	"connection reset by peer",
+	"connection refused",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"unexpected EOF",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+	"429 Too Many Requests",
+}
+
+// pushTracker tracks the status of the uploads like the in-memory tracker of the
+// resolver, and forgets the failed uploads so that they can be retried
+type pushTracker struct {
+	mu       sync.Mutex
+	statuses map[string]docker.Status
+	locks    map[string]*sync.Mutex
+}
+
+func newPushTracker() *pushTracker {
+	return &pushTracker{
+		statuses: make(map[string]docker.Status),
+		locks:    make(map[string]*sync.Mutex),
+	}
+}
+
+func (t *pushTracker) GetStatus(ref string) (docker.Status, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.statuses[ref]
+	if !ok {
+		return docker.Status{}, errdefs.ErrNotFound
+	}
+	return status, nil
+}
+
+func (t *pushTracker) SetStatus(ref string, status docker.Status) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.statuses[ref] = status
+}
+
+func (t *pushTracker) Lock(ref string) {
+	t.mu.Lock()
+	l, ok := t.locks[ref]
+	if !ok {
+		l = &sync.Mutex{}
+		t.locks[ref] = l
+	}
+	t.mu.Unlock()
+	l.Lock()
+}
+
+func (t *pushTracker) Unlock(ref string) {
+	t.mu.Lock()
+	l := t.locks[ref]
+	t.mu.Unlock()
+	l.Unlock()
+}
+
+func (t *pushTracker) forget(ref string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.statuses, ref)
+}
+
+// retryHandler retries the push of a descriptor on transient failures
+func (t *pushTracker) retryHandler(h images.Handler) images.Handler {
+	return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		interval := pushRetryInterval
+		for i := 0; ; i++ {
+			children, err := h.Handle(ctx, desc)
+			if err == nil || i >= pushRetries || !isTransient(err) {
+				return children, err
+			}
+			logrus.Warningf("push %s failure %s, retry in %s", desc.Digest, err.Error(), interval)
+			t.forget(remotes.MakeRefKey(ctx, desc))
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return nil, err
+			}
+			interval *= 2
+		}
+	})
+}
+
+// pushTarget returns the descriptor to push and the platforms it covers. An index is
+// pushed with all of its platforms when they are all present in the store, otherwise
+// only the manifest of the platform requested by the context is pushed.
+func pushTarget(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (ocispec.Descriptor, platforms.MatchComparer, error) {
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+	default:
+		return desc, platforms.All, nil
+	}
+	manifests, err := images.Children(ctx, cs, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	complete := true
+	for _, manifest := range manifests {
+		available, _, _, _, err := images.Check(ctx, cs, manifest, platforms.All)
+		if err != nil || !available {
+			complete = false
+			break
+		}
+	}
+	if complete {
+		return desc, platforms.All, nil
+	}
+	matcher, err := platformMatcher(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	manifest, err := platformManifest(ctx, cs, desc, matcher)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("no matching manifest: %s", err.Error())
+	}
+	return manifest, matcher, nil
+}
@@ -0,0 +1,408 @@
+package image
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/archive"
+	"github.com/containerd/containerd/platforms"
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/containerd/remotes/docker/config"
+	"github.com/goodrain/rainbond-oam/pkg/util/progress"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// registryImageCliImpl pulls and pushes images by talking to the registry directly,
+// the blobs are stored in a local content store and the image names in root/images.json
+type registryImageCliImpl struct {
+	root  string
+	store content.Store
+	mu    sync.Mutex
+}
+
+// NewRegistryClient new image client that doesn't need a containerd or docker daemon,
+// the images are stored under the root dir
+func NewRegistryClient(root string) (Client, error) {
+	store, err := local.NewStore(path.Join(root, "content"))
+	if err != nil {
+		return nil, fmt.Errorf("create content store in %s failure %s", root, err.Error())
+	}
+	return &registryImageCliImpl{
+		root:  root,
+		store: store,
+	}, nil
+}
+
+func (r *registryImageCliImpl) imagesFile() string {
+	return path.Join(r.root, "images.json")
+}
+
+func (r *registryImageCliImpl) listImages() (map[string]ocispec.Descriptor, error) {
+	imgs := make(map[string]ocispec.Descriptor)
+	body, err := ioutil.ReadFile(r.imagesFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return imgs, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &imgs); err != nil {
+		return nil, fmt.Errorf("read images file %s failure %s", r.imagesFile(), err.Error())
+	}
+	return imgs, nil
+}
+
+func (r *registryImageCliImpl) getImage(name string) (ocispec.Descriptor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	imgs, err := r.listImages()
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc, ok := imgs[name]
+	if !ok {
+		return ocispec.Descriptor{}, fmt.Errorf("No such image: %s", name)
+	}
+	return desc, nil
+}
+
+func (r *registryImageCliImpl) setImages(descs map[string]ocispec.Descriptor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	imgs, err := r.listImages()
+	if err != nil {
+		return err
+	}
+	for name, desc := range descs {
+		desc.Annotations = nil
+		imgs[name] = desc
+	}
+	body, err := json.Marshal(imgs)
+	if err != nil {
+		return err
+	}
+	tmp := r.imagesFile() + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.imagesFile())
+}
+
+func newRegistryResolver(ctx context.Context, username, password string, tracker docker.StatusTracker) remotes.Resolver {
+	hostOptions := config.HostOptions{
+		DefaultTLS: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		DefaultScheme: registryScheme(ctx),
+	}
+	hostOptions.Credentials = func(host string) (string, string, error) {
+		return username, password, nil
+	}
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts:   config.ConfigureHosts(ctx, hostOptions),
+		Tracker: tracker,
+	})
+}
+
+// platformManifest returns the manifest of the preferred platform if desc is an index
+func platformManifest(ctx context.Context, provider content.Provider, desc ocispec.Descriptor, matcher platforms.MatchComparer) (ocispec.Descriptor, error) {
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+	default:
+		return desc, nil
+	}
+	manifests, err := images.Children(ctx, provider, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	sort.SliceStable(manifests, func(i, j int) bool {
+		if manifests[i].Platform == nil || manifests[j].Platform == nil {
+			return manifests[j].Platform == nil && manifests[i].Platform != nil
+		}
+		return matcher.Less(*manifests[i].Platform, *manifests[j].Platform)
+	})
+	for _, manifest := range manifests {
+		if manifest.Platform == nil || matcher.Match(*manifest.Platform) {
+			return platformManifest(ctx, provider, manifest, matcher)
+		}
+	}
+	return ocispec.Descriptor{}, fmt.Errorf("no manifest of the requested platforms")
+}
+
+func normalizeImage(image string) (string, error) {
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return "", err
+	}
+	return named.String(), nil
+}
+
+func withTimeout(ctx context.Context, timeout int) (context.Context, context.CancelFunc) {
+	if timeout < 1 {
+		timeout = 1
+	}
+	return context.WithTimeout(ctx, time.Minute*time.Duration(timeout))
+}
+
+// ImageSave save image to tar file
+// destination destination file name eg. /tmp/xxx.tar
+func (r *registryImageCliImpl) ImageSave(destination string, images []string) error {
+	return r.ImageSaveWithContext(context.Background(), destination, images)
+}
+
+// ImageSaveWithContext save image to tar file, the half-written file is removed on failure
+func (r *registryImageCliImpl) ImageSaveWithContext(ctx context.Context, destination string, images []string) error {
+	matcher, err := platformMatcher(ctx)
+	if err != nil {
+		return err
+	}
+	// the index is kept in the archive, but only the manifests of the platforms are saved
+	exportOpts := []archive.ExportOpt{archive.WithPlatform(matcher)}
+	for _, image := range images {
+		name, err := normalizeImage(image)
+		if err != nil {
+			return errors.Wrapf(err, "parse image %s", image)
+		}
+		desc, err := r.getImage(name)
+		if err != nil {
+			return err
+		}
+		exportOpts = append(exportOpts, archive.WithManifest(desc, name))
+	}
+	w, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	tracker := progress.NewTracker(ctx, progress.PhaseSave, strings.Join(images, ","), 0)
+	if err := archive.Export(ctx, r.store, tracker.Writer(w), exportOpts...); err != nil {
+		os.Remove(destination)
+		return err
+	}
+	tracker.Done()
+	return nil
+}
+
+func (r *registryImageCliImpl) ImagePull(image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	return r.ImagePullWithContext(context.Background(), image, username, password, timeout)
+}
+
+func (r *registryImageCliImpl) ImagePullWithContext(ctx context.Context, image string, username, password string, timeout int) (*ocispec.ImageConfig, error) {
+	reference, err := normalizeImage(image)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := platformMatcher(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	resolver := newRegistryResolver(ctx, username, password, nil)
+	name, desc, err := resolver.Resolve(ctx, reference)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve image %s", image)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mu             sync.Mutex
+		current, total int64
+		tracker        = progress.NewTracker(ctx, progress.PhasePull, image, 0)
+		fetch          = remotes.FetchHandler(r.store, fetcher)
+	)
+	fetchHandler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		mu.Lock()
+		total += desc.Size
+		tracker.Set(current, total)
+		mu.Unlock()
+		children, err := fetch(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		current += desc.Size
+		tracker.Set(current, total)
+		mu.Unlock()
+		return children, nil
+	})
+	childrenHandler := images.FilterPlatforms(images.ChildrenHandler(r.store), matcher)
+	if !multiPlatform(ctx) {
+		childrenHandler = images.LimitManifests(childrenHandler, matcher, 1)
+	}
+	if err := images.Dispatch(ctx, images.Handlers(fetchHandler, childrenHandler), nil, desc); err != nil {
+		return nil, errors.Wrapf(err, "pull image %s", image)
+	}
+	// keep the index of a multi-arch pull, otherwise only the pulled manifest
+	target := desc
+	if !multiPlatform(ctx) {
+		if target, err = platformManifest(ctx, r.store, desc, matcher); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.setImages(map[string]ocispec.Descriptor{reference: target}); err != nil {
+		return nil, err
+	}
+	tracker.Done()
+	return r.imageConfig(ctx, target, matcher)
+}
+
+func (r *registryImageCliImpl) imageConfig(ctx context.Context, target ocispec.Descriptor, matcher platforms.MatchComparer) (*ocispec.ImageConfig, error) {
+	desc, err := images.Config(ctx, r.store, target, matcher)
+	if err != nil {
+		return nil, err
+	}
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageConfig, images.MediaTypeDockerSchema2Config:
+		var ocispecImage ocispec.Image
+		b, err := content.ReadBlob(ctx, r.store, desc)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &ocispecImage); err != nil {
+			return nil, err
+		}
+		return &ocispecImage.Config, nil
+	default:
+		return nil, fmt.Errorf("unknown media type %q", desc.MediaType)
+	}
+}
+
+// ImageLoad load image from  tar file
+// destination destination file name eg. /tmp/xxx.tar
+func (r *registryImageCliImpl) ImageLoad(tarFile string) error {
+	return r.ImageLoadWithContext(context.Background(), tarFile)
+}
+
+func (r *registryImageCliImpl) ImageLoadWithContext(ctx context.Context, tarFile string) error {
+	reader, err := os.OpenFile(tarFile, os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	var total int64
+	if info, err := reader.Stat(); err == nil {
+		total = info.Size()
+	}
+	tracker := progress.NewTracker(ctx, progress.PhaseLoad, tarFile, total)
+	index, err := archive.ImportIndex(ctx, r.store, tracker.Reader(reader))
+	if err != nil {
+		return err
+	}
+	manifests, err := images.Children(ctx, r.store, index)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]ocispec.Descriptor)
+	for _, manifest := range manifests {
+		name := manifest.Annotations[images.AnnotationImageName]
+		if name == "" {
+			name = manifest.Annotations[ocispec.AnnotationRefName]
+		}
+		if name, err = normalizeImage(name); err != nil {
+			logrus.Warningf("skip the image without a name in %s", tarFile)
+			continue
+		}
+		// the multi-arch index is kept, so that all the platforms are pushed
+		loaded[name] = manifest
+	}
+	if err := r.setImages(loaded); err != nil {
+		return err
+	}
+	tracker.Done()
+	return nil
+}
+
+func (r *registryImageCliImpl) ImagePush(image, user, pass string, timeout int) error {
+	return r.ImagePushWithContext(context.Background(), image, user, pass, timeout)
+}
+
+func (r *registryImageCliImpl) ImagePushWithContext(ctx context.Context, image, user, pass string, timeout int) error {
+	reference, err := normalizeImage(image)
+	if err != nil {
+		return err
+	}
+	img, err := r.getImage(reference)
+	if err != nil {
+		return errors.Wrap(err, "unable to resolve image to manifest")
+	}
+	desc, matcher, err := pushTarget(ctx, r.store, img)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	uploads := newPushTracker()
+	pusher, err := newRegistryResolver(ctx, user, pass, uploads).Pusher(ctx, reference)
+	if err != nil {
+		return err
+	}
+	var (
+		mu             sync.Mutex
+		current, total int64
+		tracker        = progress.NewTracker(ctx, progress.PhasePush, image, 0)
+	)
+	wrapper := func(h images.Handler) images.Handler {
+		return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+			mu.Lock()
+			total += desc.Size
+			tracker.Set(current, total)
+			mu.Unlock()
+			children, err := uploads.retryHandler(h).Handle(ctx, desc)
+			if err != nil {
+				return nil, err
+			}
+			mu.Lock()
+			current += desc.Size
+			tracker.Set(current, total)
+			mu.Unlock()
+			return children, nil
+		})
+	}
+	if err := remotes.PushContent(ctx, pusher, desc, r.store, nil, matcher, wrapper); err != nil {
+		return &PushError{Image: image, Err: err}
+	}
+	tracker.Done()
+	return nil
+}
+
+// ImageTag change docker image tag
+func (r *registryImageCliImpl) ImageTag(source, target string, timeout int) error {
+	return r.ImageTagWithContext(context.Background(), source, target, timeout)
+}
+
+// ImageTagWithContext change docker image tag
+func (r *registryImageCliImpl) ImageTagWithContext(ctx context.Context, source, target string, timeout int) error {
+	srcImage, err := normalizeImage(source)
+	if err != nil {
+		return err
+	}
+	targetImage, err := normalizeImage(target)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("change image tag：%s -> %s", srcImage, targetImage)
+	desc, err := r.getImage(srcImage)
+	if err != nil {
+		return err
+	}
+	return r.setImages(map[string]ocispec.Descriptor{targetImage: desc})
+}
@@ -0,0 +1,372 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testRegistry an in-process stand-in of the distribution registry api
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[digest.Digest][]byte
+	manifests map[string][]byte
+	// failUploads the number of blob uploads answered with failStatus
+	failUploads int
+	failStatus  int
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{
+		blobs:     make(map[digest.Digest][]byte),
+		manifests: make(map[string][]byte),
+	}
+}
+
+func (t *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := req.URL.Path
+	switch {
+	case p == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(p, "/manifests/"):
+		i := strings.Index(p, "/manifests/")
+		key := p[len("/v2/"):i] + "@" + p[i+len("/manifests/"):]
+		if req.Method == http.MethodPut {
+			body, _ := ioutil.ReadAll(req.Body)
+			t.putManifest(key, body)
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(body).String())
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		body, ok := t.manifests[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var m struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(body, &m)
+		t.serve(w, req, m.MediaType, body)
+	case strings.Contains(p, "/blobs/uploads/"):
+		if req.Method == http.MethodPost {
+			w.Header().Set("Location", p+"upload")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if t.failUploads > 0 {
+			t.failUploads--
+			w.WriteHeader(t.failStatus)
+			return
+		}
+		t.blobs[digest.Digest(req.URL.Query().Get("digest"))] = body
+		w.Header().Set("Docker-Content-Digest", req.URL.Query().Get("digest"))
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/blobs/"):
+		body, ok := t.blobs[digest.Digest(path.Base(p))]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		t.serve(w, req, "application/octet-stream", body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (t *testRegistry) serve(w http.ResponseWriter, req *http.Request, mediaType string, body []byte) {
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(body).String())
+	if req.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+func (t *testRegistry) putManifest(key string, body []byte) {
+	t.manifests[key] = body
+	t.manifests[key[:strings.Index(key, "@")]+"@"+digest.FromBytes(body).String()] = body
+}
+
+func (t *testRegistry) putImage(repository, tag string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.putManifest(repository+"@"+tag, t.manifest(runtime.GOOS, runtime.GOARCH))
+}
+
+// manifest creates the blobs and manifest of an image of the platform
+func (t *testRegistry) manifest(os, arch string) []byte {
+	config, _ := json.Marshal(ocispec.Image{
+		Architecture: arch,
+		OS:           os,
+		Config:       ocispec.ImageConfig{Env: []string{"FOO=bar"}},
+	})
+	layer := []byte("layer-" + arch)
+	t.blobs[digest.FromBytes(config)] = config
+	t.blobs[digest.FromBytes(layer)] = layer
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     images.MediaTypeDockerSchema2Manifest,
+		"config":        ocispec.Descriptor{MediaType: images.MediaTypeDockerSchema2Config, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		"layers":        []ocispec.Descriptor{{MediaType: images.MediaTypeDockerSchema2LayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	})
+	return manifest
+}
+
+// putIndex puts a multi-arch image of linux/amd64 and linux/arm64
+func (t *testRegistry) putIndex(repository, tag string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var manifests []ocispec.Descriptor
+	for _, arch := range []string{"amd64", "arm64"} {
+		manifest := t.manifest("linux", arch)
+		t.putManifest(repository+"@"+arch, manifest)
+		manifests = append(manifests, ocispec.Descriptor{
+			MediaType: images.MediaTypeDockerSchema2Manifest,
+			Digest:    digest.FromBytes(manifest),
+			Size:      int64(len(manifest)),
+			Platform:  &ocispec.Platform{OS: "linux", Architecture: arch},
+		})
+	}
+	index, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     images.MediaTypeDockerSchema2ManifestList,
+		"manifests":     manifests,
+	})
+	t.putManifest(repository+"@"+tag, index)
+}
+
+func TestRegistryClient(t *testing.T) {
+	registry := newTestRegistry()
+	registry.putImage("demo/app", "1.0")
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	client, err := NewRegistryClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := client.ImagePull(host+"/demo/app:1.0", "", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Env) != 1 || config.Env[0] != "FOO=bar" {
+		t.Errorf("unexpected image config %+v", config)
+	}
+
+	if err := client.ImageTag(host+"/demo/app:1.0", host+"/demo/copy:2.0", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ImagePush(host+"/demo/copy:2.0", "", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(registry.manifests["demo/copy@2.0"], registry.manifests["demo/app@1.0"]) {
+		t.Errorf("the tagged image is not pushed")
+	}
+
+	tarFile := path.Join(t.TempDir(), "component-images.tar")
+	if err := client.ImageSave(tarFile, []string{host + "/demo/app:1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := ReadArchiveFile(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 || archived[0].Digest != digest.FromBytes(registry.manifests["demo/app@1.0"]) || len(archived[0].Blobs) != 3 {
+		t.Errorf("unexpected images of the archive %+v", archived)
+	}
+	loader, err := NewRegistryClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ImageLoadWithContext(context.Background(), loader, tarFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.ImageTag(host+"/demo/app:1.0", host+"/demo/loaded:1.0", 1); err != nil {
+		t.Fatalf("the saved image is not loaded: %v", err)
+	}
+}
+
+func TestNewClientWithoutDaemon(t *testing.T) {
+	if _, err := NewClient(nil, nil); err == nil {
+		t.Errorf("the client without containerd or docker should not be created")
+	}
+}
+
+func TestRegistryClientPushRetry(t *testing.T) {
+	pushRetryInterval = time.Millisecond
+	defer func() { pushRetryInterval = time.Second }()
+	registry := newTestRegistry()
+	registry.putImage("demo/app", "1.0")
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+	client, err := NewRegistryClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ImagePull(host+"/demo/app:1.0", "", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ImageTag(host+"/demo/app:1.0", host+"/demo/copy:1.0", 1); err != nil {
+		t.Fatal(err)
+	}
+	// the blobs are already in the registry, drop them so that they are uploaded again
+	registry.mu.Lock()
+	registry.blobs = make(map[digest.Digest][]byte)
+	registry.failUploads, registry.failStatus = 2, http.StatusServiceUnavailable
+	registry.mu.Unlock()
+	if err := client.ImagePush(host+"/demo/copy:1.0", "", "", 1); err != nil {
+		t.Fatalf("transient upload failures should be retried: %v", err)
+	}
+	if len(registry.blobs) != 2 {
+		t.Errorf("expected 2 blobs uploaded, got %d", len(registry.blobs))
+	}
+
+	registry.mu.Lock()
+	registry.blobs = make(map[digest.Digest][]byte)
+	registry.failUploads, registry.failStatus = 100, http.StatusForbidden
+	registry.mu.Unlock()
+	err = client.ImagePush(host+"/demo/copy:1.0", "", "", 1)
+	var pushErr *PushError
+	if !errors.As(err, &pushErr) {
+		t.Fatalf("expected a push error, got %v", err)
+	}
+	if pushErr.Temporary() || registry.failUploads < 98 {
+		t.Errorf("permanent upload failures should not be retried")
+	}
+}
+
+func TestRegistryClientMultiPlatform(t *testing.T) {
+	registry := newTestRegistry()
+	registry.putIndex("demo/app", "1.0")
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	client, err := NewRegistryClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithPlatforms(context.Background(), "linux/amd64", "linux/arm64")
+	if _, err := ImagePullWithContext(ctx, client, host+"/demo/app:1.0", "", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	tarFile := path.Join(t.TempDir(), "component-images.tar")
+	if err := ImageSaveWithContext(ctx, client, tarFile, []string{host + "/demo/app:1.0", "Invalid:Name"}); err == nil {
+		t.Fatal("the image that can not be parsed should fail the save")
+	}
+	if err := ImageSaveWithContext(ctx, client, tarFile, []string{host + "/demo/app:1.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	loader, err := NewRegistryClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.ImageLoad(tarFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.ImageTag(host+"/demo/app:1.0", host+"/demo/copy:1.0", 1); err != nil {
+		t.Fatal(err)
+	}
+	registry.mu.Lock()
+	registry.blobs = make(map[digest.Digest][]byte)
+	registry.mu.Unlock()
+	if err := loader.ImagePush(host+"/demo/copy:1.0", "", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(registry.manifests["demo/copy@1.0"], registry.manifests["demo/app@1.0"]) {
+		t.Errorf("the multi-arch index is not restored")
+	}
+	if len(registry.blobs) != 4 {
+		t.Errorf("expected the blobs of 2 platforms pushed, got %d", len(registry.blobs))
+	}
+}
+
+func TestRemoteHasImage(t *testing.T) {
+	registry := newTestRegistry()
+	registry.putImage("demo/app", "1.0")
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	manifest := registry.manifests["demo/app@1.0"]
+	var m struct {
+		Config ocispec.Descriptor `json:"config"`
+	}
+	json.Unmarshal(manifest, &m)
+	for dgst, expected := range map[digest.Digest]bool{
+		digest.FromBytes(manifest): true,
+		m.Config.Digest:            true,
+		digest.FromString("other"): false,
+	} {
+		ok, err := RemoteHasImage(context.Background(), host+"/demo/app:1.0", "", "", dgst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != expected {
+			t.Errorf("expected the image of %s present %v, got %v", dgst, expected, ok)
+		}
+	}
+	if ok, err := RemoteHasImage(context.Background(), host+"/demo/app:2.0", "", "", digest.FromBytes(manifest)); ok || err != nil {
+		t.Errorf("expected the missing image reported absent, got %v %v", ok, err)
+	}
+}
+
+func TestArtifact(t *testing.T) {
+	registry := newTestRegistry()
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	configType := "application/vnd.example.app.config.v1+json"
+	artifact := &Artifact{
+		ConfigMediaType: configType,
+		Config:          []byte(`{"app":"demo"}`),
+		Layers:          []ArtifactLayer{{MediaType: "application/vnd.example.app.images.v1+json", Data: []byte(`["nginx:1.19"]`)}},
+		Annotations:     map[string]string{ocispec.AnnotationTitle: "demo"},
+	}
+	desc, err := PushArtifact(context.Background(), host+"/demo/app:1.0", "", "", artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registry.blobs) != 2 {
+		t.Errorf("expected the config and layer pushed, got %d blobs", len(registry.blobs))
+	}
+	pulled, pulledDesc, err := PullArtifact(context.Background(), host+"/demo/app:1.0", "", "", configType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulledDesc.Digest != desc.Digest || !bytes.Equal(pulled.Config, artifact.Config) || len(pulled.Layers) != 1 ||
+		!bytes.Equal(pulled.Layers[0].Data, artifact.Layers[0].Data) || pulled.Annotations[ocispec.AnnotationTitle] != "demo" {
+		t.Errorf("unexpected artifact %+v", pulled)
+	}
+	if _, _, err := PullArtifact(context.Background(), host+"/demo/app:1.0", "", "", "application/other"); err == nil {
+		t.Errorf("the artifact of another type should be rejected")
+	}
+
+	registry.putImage("demo/image", "1.0")
+	if _, _, err := PullArtifact(context.Background(), host+"/demo/image:1.0", "", "", configType); err == nil {
+		t.Errorf("a container image should not be pulled as an artifact")
+	}
+}
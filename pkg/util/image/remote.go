@@ -0,0 +1,99 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxRemoteManifestSize the max size of the manifests fetched from the registry
+const maxRemoteManifestSize = 4 << 20
+
+// RemoteHasImage whether the image in its registry is the one of the digest, which is the
+// digest of its manifest or index, or the image id of an archive written by docker save.
+// It returns false if the registry does not have the image.
+func RemoteHasImage(ctx context.Context, image, username, password string, dgst digest.Digest) (bool, error) {
+	reference, err := normalizeImage(image)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := withTimeout(ctx, 1)
+	defer cancel()
+	resolver := newRegistryResolver(ctx, username, password, nil)
+	_, desc, err := resolver.Resolve(ctx, reference)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if desc.Digest == dgst {
+		return true, nil
+	}
+	fetcher, err := resolver.Fetcher(ctx, reference)
+	if err != nil {
+		return false, err
+	}
+	return remoteMatches(ctx, fetcher, desc, dgst)
+}
+
+// RemoteDigest resolve the digest of the manifest or index of the image in its registry
+func RemoteDigest(ctx context.Context, image, username, password string) (digest.Digest, error) {
+	reference, err := normalizeImage(image)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := withTimeout(ctx, 1)
+	defer cancel()
+	_, desc, err := newRegistryResolver(ctx, username, password, nil).Resolve(ctx, reference)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+// remoteMatches whether the manifest of the platforms of the index, or the config of the
+// manifest is the digest
+func remoteMatches(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, dgst digest.Digest) (bool, error) {
+	var body []byte
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex,
+		images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			return false, err
+		}
+		defer rc.Close()
+		if body, err = ioutil.ReadAll(io.LimitReader(rc, maxRemoteManifestSize)); err != nil {
+			return false, err
+		}
+	default:
+		return false, nil
+	}
+	var manifest struct {
+		Config    ocispec.Descriptor   `json:"config"`
+		Manifests []ocispec.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return false, err
+	}
+	if manifest.Config.Digest == dgst {
+		return true, nil
+	}
+	for _, child := range manifest.Manifests {
+		if child.Digest == dgst {
+			return true, nil
+		}
+		if ok, err := remoteMatches(ctx, fetcher, child, dgst); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,212 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package progress publishes the progress of exports, imports and image
+// operations to a sink supplied by the caller through the context.
+package progress
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Phase the phase of an export or import
+type Phase string
+
+const (
+	//PhasePrepare prepare the export or import directory
+	PhasePrepare Phase = "prepare"
+	//PhasePull pull the image from the registry
+	PhasePull Phase = "pull"
+	//PhaseSave save the images to the export directory
+	PhaseSave Phase = "save"
+	//PhasePackage package the export directory
+	PhasePackage Phase = "package"
+	//PhaseExtract extract the package
+	PhaseExtract Phase = "extract"
+	//PhaseVerify verify the files of the package against its manifest
+	PhaseVerify Phase = "verify"
+	//PhaseLoad load the images of the package
+	PhaseLoad Phase = "load"
+	//PhaseTag tag the image
+	PhaseTag Phase = "tag"
+	//PhasePush push the image to the registry
+	PhasePush Phase = "push"
+	//PhaseDone the export or import is finished
+	PhaseDone Phase = "done"
+)
+
+// Event a progress event, Current and Total are bytes, Total is zero if unknown
+type Event struct {
+	Phase     Phase         `json:"phase"`
+	Component string        `json:"component,omitempty"`
+	Image     string        `json:"image,omitempty"`
+	Current   int64         `json:"current"`
+	Total     int64         `json:"total"`
+	ETA       time.Duration `json:"eta,omitempty"`
+	Message   string        `json:"message,omitempty"`
+	Time      time.Time     `json:"time"`
+}
+
+// Sink receives the progress events, it must not block for long
+type Sink interface {
+	Publish(event Event)
+}
+
+// SinkFunc a function as sink
+type SinkFunc func(event Event)
+
+// Publish publish the event
+func (f SinkFunc) Publish(event Event) {
+	f(event)
+}
+
+type sinkKey struct{}
+
+type componentKey struct{}
+
+// WithSink return a context whose progress events are published to the sink
+func WithSink(ctx context.Context, sink Sink) context.Context {
+	return context.WithValue(ctx, sinkKey{}, sink)
+}
+
+// WithComponent return a context whose progress events belong to the component
+func WithComponent(ctx context.Context, component string) context.Context {
+	return context.WithValue(ctx, componentKey{}, component)
+}
+
+// Publish publish the event to the sink of the context, if any
+func Publish(ctx context.Context, event Event) {
+	sink, ok := ctx.Value(sinkKey{}).(Sink)
+	if !ok || sink == nil {
+		return
+	}
+	if event.Component == "" {
+		event.Component, _ = ctx.Value(componentKey{}).(string)
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	sink.Publish(event)
+}
+
+// Enabled whether the context has a sink, the publishers can skip the work of
+// collecting progress when it doesn't
+func Enabled(ctx context.Context) bool {
+	sink, ok := ctx.Value(sinkKey{}).(Sink)
+	return ok && sink != nil
+}
+
+// Tracker track the bytes of an operation and publish throttled events with ETA
+type Tracker struct {
+	ctx      context.Context
+	event    Event
+	start    time.Time
+	last     time.Time
+	interval time.Duration
+	mu       sync.Mutex
+}
+
+// NewTracker new tracker of the phase of the image, total is zero if unknown
+func NewTracker(ctx context.Context, phase Phase, image string, total int64) *Tracker {
+	now := time.Now()
+	return &Tracker{
+		ctx:      ctx,
+		event:    Event{Phase: phase, Image: image, Total: total},
+		start:    now,
+		interval: 200 * time.Millisecond,
+	}
+}
+
+// Add add the done bytes
+func (t *Tracker) Add(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.set(t.event.Current+n, t.event.Total)
+}
+
+// Set set the done and total bytes
+func (t *Tracker) Set(current, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.set(current, total)
+}
+
+func (t *Tracker) set(current, total int64) {
+	t.event.Current, t.event.Total = current, total
+	now := time.Now()
+	if now.Sub(t.last) < t.interval {
+		return
+	}
+	t.last = now
+	t.publish(now)
+}
+
+func (t *Tracker) publish(now time.Time) {
+	event := t.event
+	if event.Total > 0 && event.Current > 0 && event.Current < event.Total {
+		elapsed := now.Sub(t.start)
+		event.ETA = time.Duration(float64(elapsed) * float64(event.Total-event.Current) / float64(event.Current))
+	}
+	event.Time = now
+	Publish(t.ctx, event)
+}
+
+// Done publish the final event of the operation
+func (t *Tracker) Done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.event.Total > 0 {
+		t.event.Current = t.event.Total
+	}
+	t.publish(time.Now())
+}
+
+// Reader count the bytes read through the reader
+func (t *Tracker) Reader(r io.Reader) io.Reader {
+	return &countReader{r: r, tracker: t}
+}
+
+// Writer count the bytes written through the writer
+func (t *Tracker) Writer(w io.Writer) io.Writer {
+	return &countWriter{w: w, tracker: t}
+}
+
+type countReader struct {
+	r       io.Reader
+	tracker *Tracker
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.tracker.Add(int64(n))
+	return n, err
+}
+
+type countWriter struct {
+	w       io.Writer
+	tracker *Tracker
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.tracker.Add(int64(n))
+	return n, err
+}
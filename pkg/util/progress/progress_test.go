@@ -0,0 +1,62 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package progress
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestPublishWithoutSink(t *testing.T) {
+	ctx := context.Background()
+	if Enabled(ctx) {
+		t.Fatal("context without sink is enabled")
+	}
+	Publish(ctx, Event{Phase: PhasePull})
+}
+
+func TestTracker(t *testing.T) {
+	var events []Event
+	ctx := WithSink(context.Background(), SinkFunc(func(event Event) {
+		events = append(events, event)
+	}))
+	ctx = WithComponent(ctx, "web")
+	tracker := NewTracker(ctx, PhaseLoad, "nginx", 100)
+	tracker.start = time.Now().Add(-time.Second)
+	if _, err := ioutil.ReadAll(tracker.Reader(bytes.NewReader(make([]byte, 50)))); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected the events to be throttled, got %d", len(events))
+	}
+	if events[0].Component != "web" || events[0].Image != "nginx" || events[0].Phase != PhaseLoad {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+	if events[0].ETA <= 0 {
+		t.Errorf("expected ETA for partial progress, got %s", events[0].ETA)
+	}
+	tracker.Done()
+	last := events[len(events)-1]
+	if last.Current != 100 || last.ETA != 0 {
+		t.Errorf("unexpected done event %+v", last)
+	}
+}
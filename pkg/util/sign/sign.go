@@ -0,0 +1,164 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package sign signs and verifies detached signatures of files with ed25519 or
+// ECDSA P-256 keys. The signatures are base64 encoded, ECDSA signatures are
+// compatible with `cosign sign-blob` and `cosign verify-blob --key`.
+package sign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ErrInvalidSignature the signature does not match the payload or the keys
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// LoadPrivateKey load the ed25519 or ECDSA P-256 private key of the PEM file
+func LoadPrivateKey(file string) (crypto.Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		if strings.Contains(block.Type, "ENCRYPTED") {
+			return nil, fmt.Errorf("key %s is encrypted, use an unencrypted PKCS#8 key", file)
+		}
+		return nil, fmt.Errorf("key %s is not a private key: %s", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse key %s failure %s", file, err.Error())
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || !supported(signer.Public()) {
+		return nil, fmt.Errorf("key %s is neither ed25519 nor ECDSA P-256", file)
+	}
+	return signer, nil
+}
+
+// LoadPublicKey load the ed25519 or ECDSA P-256 public key of the PEM file, such as
+// the cosign.pub written by `cosign generate-key-pair`
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("key %s is not a public key: %s", file, block.Type)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse key %s failure %s", file, err.Error())
+	}
+	if !supported(key) {
+		return nil, fmt.Errorf("key %s is neither ed25519 nor ECDSA P-256", file)
+	}
+	return key, nil
+}
+
+// Sign return the base64 encoded signature of the payload
+func Sign(key crypto.Signer, payload []byte) ([]byte, error) {
+	var (
+		sig []byte
+		err error
+	)
+	switch key.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err = key.Sign(rand.Reader, payload, crypto.Hash(0))
+	default:
+		digest := sha256.Sum256(payload)
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+// Verify check the base64 encoded signature of the payload against the keys, it
+// returns the key that made the signature or ErrInvalidSignature
+func Verify(keys []crypto.PublicKey, payload, signature []byte) (crypto.PublicKey, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignature, err.Error())
+	}
+	digest := sha256.Sum256(payload)
+	for _, key := range keys {
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, payload, sig) {
+				return key, nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(k, digest[:], sig) {
+				return key, nil
+			}
+		}
+	}
+	return nil, ErrInvalidSignature
+}
+
+// KeyID the sha256 of the public key, it identifies the key in logs
+func KeyID(key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", file)
+	}
+	return block, nil
+}
+
+func supported(key crypto.PublicKey) bool {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return true
+	case *ecdsa.PublicKey:
+		return k.Curve == elliptic.P256()
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+// RAINBOND, Application Management Platform
+// Copyright (C) 2020-2020 Goodrain Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Rainbond,
+// one or multiple Commercial Licenses authorized by Goodrain Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// writeKeyPair write the PEM files of the key pair, it returns the private and public key files
+func writeKeyPair(t *testing.T, key crypto.Signer) (string, string) {
+	dir := t.TempDir()
+	private, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateFile, publicFile := path.Join(dir, "key.pem"), path.Join(dir, "key.pub")
+	ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}), 0600)
+	ioutil.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0644)
+	return privateFile, publicFile
+}
+
+func TestSignVerify(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var trusted []crypto.PublicKey
+	var signers []crypto.Signer
+	for _, key := range []crypto.Signer{edKey, ecKey} {
+		privateFile, publicFile := writeKeyPair(t, key)
+		signer, err := LoadPrivateKey(privateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, err := LoadPublicKey(publicFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+		trusted = append(trusted, public)
+	}
+	payload := []byte(`{"files":[]}`)
+	for i, signer := range signers {
+		sig, err := Sign(signer, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := Verify(trusted, payload, sig)
+		if err != nil {
+			t.Fatalf("the signature of key %d is not verified: %v", i, err)
+		}
+		if KeyID(key) != KeyID(signer.Public()) {
+			t.Errorf("the signature is verified by the wrong key")
+		}
+		if _, err := Verify(trusted, []byte(`{"files":null}`), sig); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("the signature of a modified payload should be invalid, got %v", err)
+		}
+		if _, err := Verify(trusted[1-i:2-i], payload, sig); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("the signature of an untrusted key should be invalid, got %v", err)
+		}
+	}
+
+	encrypted := path.Join(t.TempDir(), "cosign.key")
+	ioutil.WriteFile(encrypted, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED COSIGN PRIVATE KEY", Bytes: []byte("x")}), 0600)
+	if _, err := LoadPrivateKey(encrypted); err == nil {
+		t.Errorf("encrypted keys should be rejected")
+	}
+}